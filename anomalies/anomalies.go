@@ -0,0 +1,359 @@
+package anomalies
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/scheduler"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// Receipts or weeks needed to know what's typical
+	MIN_SAMPLES = 5
+	// Spending is unusual when it's at least this many times the typical one
+	MIN_FACTOR = 2
+	// and this many deviations above it
+	DEVIATIONS = 3
+	// Weeks before the current one a week of a category is compared with
+	SPIKE_WEEKS = 8
+	// How far back receipts are compared with
+	HISTORY = 365 * 24 * time.Hour
+	// Receipts in a currency after which it isn't foreign for the user
+	USUAL_CURRENCY_RECEIPTS = 3
+	// Receipts created this long before the background check are checked again, e.g. when they got exchange rates later
+	RECHECK_WINDOW = 2 * 24 * time.Hour
+	// When the background check runs, in the timezone of the user
+	CHECK_CRON  = "30 10 * * *"
+	DATE_FORMAT = "Jan 2"
+)
+
+// Scales median absolute deviation to standard deviation of normally distributed values
+var madScale = decimal.NewFromFloat(1.4826)
+
+// Anomalies of the user, the latest first, with their receipts and merchants
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint, limit int) ([]db.Anomaly, error) {
+	var anomalies []db.Anomaly
+	if err := dbc.WithContext(ctx).Preload("Receipt.Merchant").Preload("Merchant").
+		Where("user_id = ?", userID).Order("id desc").Limit(limit).Find(&anomalies).Error; err != nil {
+		return nil, fmt.Errorf("fetching anomalies: %w", errors.WithStack(err))
+	}
+	return anomalies, nil
+}
+
+// Marks the anomaly as expected, so similar spending isn't alerted. Returns nil if the user has no such anomaly
+func Expect(ctx context.Context, dbc *gorm.DB, userID uint, id uint) (*db.Anomaly, error) {
+	var anomaly db.Anomaly
+	if err := dbc.WithContext(ctx).Preload("Receipt.Merchant").Preload("Merchant").Where("user_id = ?", userID).Limit(1).Find(&anomaly, id).Error; err != nil {
+		return nil, fmt.Errorf("finding anomaly: %w", errors.WithStack(err))
+	}
+	if anomaly.ID == 0 {
+		return nil, nil
+	}
+	if err := dbc.WithContext(ctx).Model(&anomaly).Update("expected", true).Error; err != nil {
+		return nil, fmt.Errorf("marking anomaly expected: %w", errors.WithStack(err))
+	}
+	return &anomaly, nil
+}
+
+// Looks for unusual receipts among the given ones and for a spike of a category in the current week.
+// Receipts LLM was unsure of are left out, as their amounts are likely wrong. Stores and returns anomalies found for the first time
+func Check(ctx context.Context, dbc *gorm.DB, user db.User, receipts []db.Receipt, now time.Time) ([]db.Anomaly, error) {
+	dbc = dbc.WithContext(ctx)
+	receipts = lo.Filter(receipts, func(r db.Receipt, _ int) bool { return r.DuplicateOfID == nil && !r.Unsure })
+	var found []db.Anomaly
+	if len(receipts) > 0 {
+		spec, _ := scheduler.ParseSpec(CHECK_CRON)
+		if err := scheduler.Ensure(ctx, dbc, user, db.ScheduleKindAnomalyCheck, spec); err != nil {
+			return nil, err
+		}
+		unusual, err := unusualReceipts(ctx, dbc, user, receipts)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, unusual...)
+	}
+	spikes, err := spikes(ctx, dbc, user, now)
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, spikes...)
+
+	var created []db.Anomaly
+	for _, anomaly := range found {
+		isNew, err := record(dbc, &anomaly)
+		if err != nil {
+			return created, err
+		}
+		if isNew {
+			created = append(created, anomaly)
+		}
+	}
+	return created, nil
+}
+
+func unusualReceipts(ctx context.Context, dbc *gorm.DB, user db.User, receipts []db.Receipt) ([]db.Anomaly, error) {
+	baseCurrency := user.EffectiveBaseCurrency()
+	earliest := lo.MinBy(receipts, func(a db.Receipt, b db.Receipt) bool { return a.OccuredAt.Before(b.OccuredAt) }).OccuredAt
+	latest := lo.MaxBy(receipts, func(a db.Receipt, b db.Receipt) bool { return a.OccuredAt.After(b.OccuredAt) }).OccuredAt
+	categoryTotals, err := stats.SureReceiptCategoryTotalsBetween(ctx, dbc, user, earliest.Add(-HISTORY), latest.Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []db.Anomaly
+	for _, receipt := range receipts {
+		if receipt.Currency != "" && receipt.Currency != baseCurrency {
+			anomaly, err := foreignCurrency(dbc, user, receipt)
+			if err != nil {
+				return nil, err
+			}
+			if anomaly != nil {
+				found = append(found, *anomaly)
+			}
+		}
+		if receipt.BaseCurrency != baseCurrency {
+			continue
+		}
+		anomaly, known, err := merchantAmount(dbc, user, receipt)
+		if err != nil {
+			return nil, err
+		}
+		// what's usual at the merchant tells more than what's usual for the category
+		if !known {
+			anomaly, err = categoryAmount(dbc, user, receipt, categoryTotals)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if anomaly != nil {
+			found = append(found, *anomaly)
+		}
+	}
+	return found, nil
+}
+
+// A charge in a currency the user rarely pays in
+func foreignCurrency(dbc *gorm.DB, user db.User, receipt db.Receipt) (*db.Anomaly, error) {
+	var count int64
+	if err := dbc.Model(&db.Receipt{}).Where("user_id = ? AND currency = ? AND id <> ? AND duplicate_of_id IS NULL AND unsure = ?", user.ID, receipt.Currency, receipt.ID, false).
+		Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("counting receipts in %s: %w", receipt.Currency, errors.WithStack(err))
+	}
+	if count >= USUAL_CURRENCY_RECEIPTS {
+		return nil, nil
+	}
+	expected, err := isExpected(dbc.Where("currency = ?", receipt.Currency), user.ID, db.AnomalyKindForeignCurrency)
+	if expected || err != nil {
+		return nil, err
+	}
+	return &db.Anomaly{UserID: user.ID, Kind: db.AnomalyKindForeignCurrency, ReceiptID: &receipt.ID, MerchantID: receipt.MerchantID,
+		Amount: receipt.TotalWithTax, Currency: receipt.Currency, Receipt: &receipt, Merchant: receipt.Merchant}, nil
+}
+
+// A receipt far above what the user usually spends at its merchant. Returns false if the merchant has too few receipts to know
+func merchantAmount(dbc *gorm.DB, user db.User, receipt db.Receipt) (*db.Anomaly, bool, error) {
+	if receipt.MerchantID == nil {
+		return nil, false, nil
+	}
+	var samples []decimal.Decimal
+	if err := dbc.Model(&db.Receipt{}).
+		Where("user_id = ? AND merchant_id = ? AND id <> ? AND duplicate_of_id IS NULL AND unsure = ?", user.ID, *receipt.MerchantID, receipt.ID, false).
+		Where("base_currency = ? AND occured_at >= ?", user.EffectiveBaseCurrency(), receipt.OccuredAt.Add(-HISTORY).UTC()).
+		Pluck("base_total_with_tax", &samples).Error; err != nil {
+		return nil, false, fmt.Errorf("fetching merchant receipts: %w", errors.WithStack(err))
+	}
+	known := len(samples) >= MIN_SAMPLES
+	typical, ok := unusual(receipt.BaseTotalWithTax, samples)
+	if !ok {
+		return nil, known, nil
+	}
+	expected, err := isExpected(dbc.Where("merchant_id = ? AND amount >= ?", *receipt.MerchantID, receipt.BaseTotalWithTax), user.ID, db.AnomalyKindMerchantAmount)
+	if expected || err != nil {
+		return nil, known, err
+	}
+	return &db.Anomaly{UserID: user.ID, Kind: db.AnomalyKindMerchantAmount, ReceiptID: &receipt.ID, MerchantID: receipt.MerchantID,
+		Amount: receipt.BaseTotalWithTax, Baseline: typical, Currency: receipt.BaseCurrency, Receipt: &receipt, Merchant: receipt.Merchant}, known, nil
+}
+
+// The category of the receipt, which the user spent on far more than they usually spend on it per receipt
+func categoryAmount(dbc *gorm.DB, user db.User, receipt db.Receipt, totals map[uint]map[string]decimal.Decimal) (*db.Anomaly, error) {
+	var found *db.Anomaly
+	var foundRatio decimal.Decimal
+	for category, amount := range totals[receipt.ID] {
+		var samples []decimal.Decimal
+		for receiptID, receiptTotals := range totals {
+			if total, ok := receiptTotals[category]; ok && receiptID != receipt.ID {
+				samples = append(samples, total)
+			}
+		}
+		typical, ok := unusual(amount, samples)
+		if !ok {
+			continue
+		}
+		if ratio := amount.Div(typical); found == nil || ratio.GreaterThan(foundRatio) {
+			found, foundRatio = &db.Anomaly{UserID: user.ID, Kind: db.AnomalyKindCategoryAmount, ReceiptID: &receipt.ID, MerchantID: receipt.MerchantID,
+				Category: category, Amount: amount.Round(2), Baseline: typical, Currency: receipt.BaseCurrency, Receipt: &receipt, Merchant: receipt.Merchant}, ratio
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	expected, err := isExpected(dbc.Where("category = ? AND amount >= ?", found.Category, found.Amount), user.ID, db.AnomalyKindCategoryAmount)
+	if expected || err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Categories the user spent on in the current week far more than in a usual week
+func spikes(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time) ([]db.Anomaly, error) {
+	from, to := budgets.PeriodBounds(db.BudgetPeriodWeek, now, user.Location())
+	current, err := stats.SureCategoryTotalsBetween(ctx, dbc, user, from, to)
+	if err != nil || len(current) == 0 {
+		return nil, err
+	}
+	var weeks []map[string]decimal.Decimal
+	for i := 1; i <= SPIKE_WEEKS; i++ {
+		weekFrom := from.AddDate(0, 0, -7*i)
+		totals, err := stats.SureCategoryTotalsBetween(ctx, dbc, user, weekFrom, weekFrom.AddDate(0, 0, 7))
+		if err != nil {
+			return nil, err
+		}
+		// weeks the user sent no receipts tell nothing
+		if len(totals) > 0 {
+			weeks = append(weeks, lo.SliceToMap(totals, func(t stats.CategoryTotal) (string, decimal.Decimal) { return t.Category, t.Total }))
+		}
+	}
+
+	// stored in UTC, as sqlite compares times as text with their offsets, see record
+	periodFrom := from.UTC()
+	var found []db.Anomaly
+	for _, total := range current {
+		samples := lo.Map(weeks, func(week map[string]decimal.Decimal, _ int) decimal.Decimal { return week[total.Category] })
+		if typical, ok := unusual(total.Total, samples); ok {
+			found = append(found, db.Anomaly{UserID: user.ID, Kind: db.AnomalyKindCategorySpike, Category: total.Category, PeriodFrom: &periodFrom,
+				Amount: total.Total, Baseline: typical, Currency: user.EffectiveBaseCurrency()})
+		}
+	}
+	return found, nil
+}
+
+// The value is unusual when it's far above the median of the samples. Returns the median
+func unusual(value decimal.Decimal, samples []decimal.Decimal) (decimal.Decimal, bool) {
+	if len(samples) < MIN_SAMPLES {
+		return decimal.Zero, false
+	}
+	typical := median(samples)
+	if !typical.IsPositive() {
+		return typical, false
+	}
+	deviation := median(lo.Map(samples, func(s decimal.Decimal, _ int) decimal.Decimal { return s.Sub(typical).Abs() })).Mul(madScale)
+	typical = typical.Round(2)
+	return typical, value.GreaterThanOrEqual(typical.Mul(decimal.NewFromInt(MIN_FACTOR))) &&
+		value.GreaterThan(typical.Add(deviation.Mul(decimal.NewFromInt(DEVIATIONS))))
+}
+
+func median(values []decimal.Decimal) decimal.Decimal {
+	sorted := append([]decimal.Decimal{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return sorted[middle-1].Add(sorted[middle]).Div(decimal.NewFromInt(2))
+}
+
+// Whether the user marked as expected an anomaly of the kind matching the scope
+func isExpected(scope *gorm.DB, userID uint, kind db.AnomalyKind) (bool, error) {
+	var count int64
+	if err := scope.Model(&db.Anomaly{}).Where("user_id = ? AND kind = ? AND expected", userID, kind).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("counting expected anomalies: %w", errors.WithStack(err))
+	}
+	return count > 0, nil
+}
+
+// Creates the anomaly, unless it was found before. Returns false if it was
+func record(dbc *gorm.DB, anomaly *db.Anomaly) (bool, error) {
+	scope := dbc.Model(&db.Anomaly{}).Where("user_id = ? AND kind = ? AND category = ?", anomaly.UserID, anomaly.Kind, anomaly.Category)
+	if anomaly.ReceiptID != nil {
+		scope = scope.Where("receipt_id = ?", *anomaly.ReceiptID)
+	}
+	if anomaly.PeriodFrom != nil {
+		scope = scope.Where("period_from = ?", anomaly.PeriodFrom.UTC())
+	}
+	var count int64
+	if err := scope.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("counting anomalies: %w", errors.WithStack(err))
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := dbc.Omit("User", "Receipt", "Merchant").Create(anomaly).Error; err != nil {
+		return false, fmt.Errorf("creating anomaly: %w", errors.WithStack(err))
+	}
+	return true, nil
+}
+
+// Checks receipts created recently and spending of the current week, and alerts about anomalies, for scheduler.NewScheduler
+func Job(ctx context.Context, deps deps.Deps, user db.User, at time.Time) error {
+	var receipts []db.Receipt
+	if err := deps.DBC.WithContext(ctx).Preload("Merchant").Where("user_id = ? AND created_at >= ? AND unsure = ?", user.ID, at.Add(-RECHECK_WINDOW).UTC(), false).
+		Find(&receipts).Error; err != nil {
+		return fmt.Errorf("fetching recent receipts: %w", errors.WithStack(err))
+	}
+	found, err := Check(ctx, deps.DBC, user, receipts, at)
+	if err != nil {
+		return err
+	}
+	for _, anomaly := range found {
+		if err := deps.Notifier.Notify(ctx, user.ID, Alert(anomaly, user.Location())); err != nil {
+			return fmt.Errorf("sending anomaly alert: %w", err)
+		}
+	}
+	return nil
+}
+
+// Describes the anomaly with how to mark it as expected
+func Alert(anomaly db.Anomaly, loc *time.Location) string {
+	return fmt.Sprintf("%s\nIf it's fine, send /expected %d and I won't alert about similar spending", Describe(anomaly, loc), anomaly.ID)
+}
+
+// Describes the anomaly, e.g. "Unusual receipt at Rewe on Oct 3: 250.00 EUR, you usually spend about 40.00 there"
+func Describe(anomaly db.Anomaly, loc *time.Location) string {
+	where := ""
+	if anomaly.Receipt != nil {
+		merchant := anomaly.Receipt.Origin
+		if anomaly.Merchant != nil {
+			merchant = anomaly.Merchant.Name
+		}
+		if merchant != "" {
+			where = " at " + merchant
+		}
+		where += " on " + anomaly.Receipt.OccuredAt.In(loc).Format(DATE_FORMAT)
+	}
+	amount := anomaly.Amount.StringFixed(2) + " " + anomaly.Currency
+	switch anomaly.Kind {
+	case db.AnomalyKindMerchantAmount:
+		return fmt.Sprintf("Unusual receipt%s: %s, you usually spend about %s there", where, amount, anomaly.Baseline.StringFixed(2))
+	case db.AnomalyKindCategoryAmount:
+		return fmt.Sprintf("Unusual spending on %s%s: %s, usually it's about %s per receipt", anomaly.Category, where, amount, anomaly.Baseline.StringFixed(2))
+	case db.AnomalyKindCategorySpike:
+		week := ""
+		if anomaly.PeriodFrom != nil {
+			week = " of " + anomaly.PeriodFrom.In(loc).Format(DATE_FORMAT)
+		}
+		return fmt.Sprintf("Spending on %s jumped to %s in the week%s, a usual week is about %s", anomaly.Category, amount, week, anomaly.Baseline.StringFixed(2))
+	default:
+		return fmt.Sprintf("Charge in %s%s: %s. Is it yours?", anomaly.Currency, where, amount)
+	}
+}
@@ -0,0 +1,56 @@
+package anomalies
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+)
+
+func decimals(values ...string) []decimal.Decimal {
+	return lo.Map(values, func(v string, _ int) decimal.Decimal { return decimal.RequireFromString(v) })
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected string
+	}{
+		{[]string{"7"}, "7"},
+		{[]string{"3", "1", "2"}, "2"},
+		{[]string{"4", "1", "3", "2"}, "2.5"},
+		{[]string{"10", "10", "-5", "100", "10"}, "10"},
+	}
+	for _, test := range tests {
+		if m := median(decimals(test.values...)); !m.Equal(decimal.RequireFromString(test.expected)) {
+			t.Errorf("median(%v) = %s, expected %s", test.values, m, test.expected)
+		}
+	}
+}
+
+func TestUnusual(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		samples []string
+		typical string
+		unusual bool
+	}{
+		{"too few samples", "1000", []string{"10", "10", "10", "10"}, "0", false},
+		{"twice the same amounts", "20", []string{"10", "10", "10", "10", "10"}, "10", true},
+		{"just below twice the same amounts", "19.99", []string{"10", "10", "10", "10", "10"}, "10", false},
+		{"far above close amounts", "20", []string{"10", "12", "8", "11", "9"}, "10", true},
+		{"within deviations of spread amounts", "50", []string{"5", "10", "20", "40", "80"}, "20", false},
+		{"beyond deviations of spread amounts", "90", []string{"5", "10", "20", "40", "80"}, "20", true},
+		{"free usually", "10", []string{"0", "0", "0", "5", "5"}, "0", false},
+		{"typical is rounded", "1", []string{"0.333", "0.333", "0.333", "0.333", "0.333"}, "0.33", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			typical, unusual := unusual(decimal.RequireFromString(test.value), decimals(test.samples...))
+			if unusual != test.unusual || !typical.Equal(decimal.RequireFromString(test.typical)) {
+				t.Errorf("unusual = %s, %v, expected %s, %v", typical, unusual, test.typical, test.unusual)
+			}
+		})
+	}
+}
@@ -11,18 +11,26 @@ import (
 	"github.com/EPecherkin/catty-counting/config"
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 )
 
+// Serves its own endpoints on the API, e.g. the web chat
+type Routes interface {
+	Routes(router gin.IRouter)
+}
+
 type Api struct {
-	deps deps.Deps
+	llmc   llm.Client
+	routes []Routes
+	deps   deps.Deps
 }
 
-func NewApi(deps deps.Deps) *Api {
+func NewApi(llmc llm.Client, deps deps.Deps, routes ...Routes) *Api {
 	deps.Logger = deps.Logger.With(log.CALLER, "api.api")
-	return &Api{deps: deps}
+	return &Api{llmc: llmc, routes: routes, deps: deps}
 }
 
 func (a *Api) Run(ctx context.Context) {
@@ -31,6 +39,20 @@ func (a *Api) Run(ctx context.Context) {
 
 	router.GET("/api/file/:key", a.provideFile)
 
+	authorized := router.Group("/api", a.authenticate())
+	authorized.POST("/files", a.uploadFiles)
+	authorized.GET("/jobs/:key", a.provideJob)
+	authorized.GET("/rules", a.listRules)
+	authorized.POST("/rules", a.addRule)
+	authorized.DELETE("/rules/:id", a.deleteRule)
+	authorized.PUT("/products/:id/category", a.recategorizeProduct)
+	authorized.GET("/charts/:kind", a.renderChart)
+	authorized.GET("/export", a.exportReceipts)
+
+	for _, r := range a.routes {
+		r.Routes(router)
+	}
+
 	a.deps.Logger.Info("Starting API server on port " + config.ApiPort())
 	// TODO: handle graceful shutdown; context
 	if err := router.Run(":" + config.ApiPort()); err != nil {
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	USER_KEY = "user"
+)
+
+// Finds the user by "Authorization: Bearer <token>" header. Tokens are issued with /token command
+func (a *Api) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+			return
+		}
+
+		user, err := db.FindUserByApiToken(c.Request.Context(), a.deps.DBC, strings.TrimSpace(token))
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				a.deps.Logger.With(log.ERROR, err).Error("failed to find user by token")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		c.Set(USER_KEY, user)
+		c.Next()
+	}
+}
+
+func currentUser(c *gin.Context) db.User {
+	return c.MustGet(USER_KEY).(db.User)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/charts"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Renders a spending chart, /api/charts/bar?from=2025-01-01&to=2025-06-30&category=Food&format=svg.
+// from, to and category are optional, format is png by default
+func (a *Api) renderChart(c *gin.Context) {
+	user := currentUser(c)
+	logger := a.deps.Logger.With(log.USER_ID, user.ID)
+
+	query := c.Request.URL.Query()
+	query.Set("kind", c.Param("kind"))
+	spec, err := charts.ParseSpec(query, user.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "svg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format should be png or svg"})
+		return
+	}
+
+	chart, err := charts.Build(c.Request.Context(), a.deps.DBC, user, spec, time.Now())
+	if errors.Is(err, charts.ErrTooManyPeriods) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to build chart")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build chart"})
+		return
+	}
+	if format == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", charts.RenderSVG(chart))
+		return
+	}
+	png, err := charts.RenderPNG(chart)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to render chart")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render chart"})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/export"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/gin-gonic/gin"
+)
+
+// Exports receipts as spreadsheets or a journal, /api/export?format=csv&sheet=products&from=2025-01-01&to=2025-03-31&category=Food.
+// format is xlsx by default, with all sheets. csv has a single sheet: receipts by default, products or categories. ledger, hledger and beancount are journals
+func (a *Api) exportReceipts(c *gin.Context) {
+	user := currentUser(c)
+	logger := a.deps.Logger.With(log.USER_ID, user.ID)
+
+	spec, err := export.ParseSpec(c.Request.URL.Query(), user.Location())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if spec.Format.IsJournal() {
+		files, err := export.Files(c.Request.Context(), a.deps.DBC, user, spec, time.Now())
+		if err != nil {
+			logger.With(log.ERROR, err).Error("failed to export")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export"})
+			return
+		}
+		sendFile(c, files[0])
+		return
+	}
+	workbook, err := export.Build(c.Request.Context(), a.deps.DBC, user, spec, time.Now())
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export"})
+		return
+	}
+	files, err := workbook.Files(spec.Format)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to write export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export"})
+		return
+	}
+
+	file := files[0]
+	if spec.Format == export.FormatCsv {
+		sheet := strings.ToLower(c.DefaultQuery("sheet", "receipts"))
+		found := false
+		for i, s := range workbook.Sheets {
+			if strings.ToLower(s.Name) == sheet {
+				file, found = files[i], true
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sheet should be receipts, products or categories"})
+			return
+		}
+	}
+	sendFile(c, file)
+}
+
+func sendFile(c *gin.Context, file export.File) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	c.Data(http.StatusOK, file.MimeType, file.Data)
+}
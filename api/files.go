@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/llm"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type filesResponse struct {
+	Files []llm.File4Llm `json:"files"`
+}
+
+type jobResponse struct {
+	JobID  string          `json:"job_id"`
+	Status db.JobStatus    `json:"status"`
+	Files  json.RawMessage `json:"files,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Accepts multipart "file" fields with optional "text", stores and parses them.
+// With ?async=true responds right away with a job to poll on /api/jobs/:key
+func (a *Api) uploadFiles(c *gin.Context) {
+	user := currentUser(c)
+	logger := a.deps.Logger.With(log.USER_ID, user.ID)
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart form expected"})
+		return
+	}
+	fileHeaders := form.File["file"]
+	if len(fileHeaders) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file provided"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	message := db.Message{UserID: user.ID, Text: c.PostForm("text"), Direction: db.MessageDirectionFromUser}
+	if err := a.deps.DBC.WithContext(ctx).Create(&message).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to create message")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"})
+		return
+	}
+	logger = logger.With(log.MESSAGE_ID, message.ID)
+
+	for _, fh := range fileHeaders {
+		content, err := fh.Open()
+		if err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("failed to open uploaded file")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+			return
+		}
+		blobKey, size, err := base.SaveBlob(ctx, a.deps.Files, user.ID, content)
+		content.Close()
+		if err != nil {
+			logger.With(log.ERROR, err).Error("failed to save file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return
+		}
+		file := db.File{
+			MessageID:    message.ID,
+			OriginalName: fh.Filename,
+			MimeType:     fh.Header.Get("Content-Type"),
+			Size:         size,
+			BlobKey:      blobKey,
+		}
+		if err := a.deps.DBC.WithContext(ctx).Create(&file).Error; err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("failed to create file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return
+		}
+		message.Files = append(message.Files, file)
+	}
+
+	if c.Query("async") != "true" {
+		files, err := a.parseFiles(ctx, message)
+		if err != nil {
+			logger.With(log.ERROR, err).Error("failed to parse files")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to parse files"})
+			return
+		}
+		c.JSON(http.StatusOK, filesResponse{Files: files})
+		return
+	}
+
+	job := db.Job{Key: uuid.New().String(), UserID: user.ID, MessageID: message.ID, Status: db.JobStatusPending}
+	if err := a.deps.DBC.WithContext(ctx).Create(&job).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to create job")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create job"})
+		return
+	}
+	go a.runJob(context.WithoutCancel(ctx), job, message)
+	c.JSON(http.StatusAccepted, jobResponse{JobID: job.Key, Status: job.Status})
+}
+
+func (a *Api) provideJob(c *gin.Context) {
+	user := currentUser(c)
+	var job db.Job
+	if err := a.deps.DBC.WithContext(c.Request.Context()).Where("key = ? AND user_id = ?", c.Param("key"), user.ID).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	response := jobResponse{JobID: job.Key, Status: job.Status, Error: job.Error}
+	if job.Result != "" {
+		response.Files = json.RawMessage(job.Result)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (a *Api) parseFiles(ctx context.Context, message db.Message) ([]llm.File4Llm, error) {
+	var files []llm.File4Llm
+	for _, file := range message.Files {
+		f4l, err := a.llmc.ParseFile(ctx, message.UserID, file)
+		if err != nil {
+			return nil, fmt.Errorf("parsing file %d: %w", file.ID, err)
+		}
+		files = append(files, f4l)
+	}
+	return files, nil
+}
+
+func (a *Api) runJob(ctx context.Context, job db.Job, message db.Message) {
+	logger := a.deps.Logger.With(log.USER_ID, job.UserID, log.MESSAGE_ID, job.MessageID).With("job", job.Key)
+	defer func() {
+		if err := recover(); err != nil {
+			logger.With(log.ERROR, err).Error("panic in runJob")
+		}
+	}()
+
+	files, err := a.parseFiles(ctx, message)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("job failed")
+		job.Status = db.JobStatusFailed
+		job.Error = "failed to parse files"
+	} else if result, err := json.Marshal(files); err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to marshal job result")
+		job.Status = db.JobStatusFailed
+		job.Error = "failed to save result"
+	} else {
+		job.Status = db.JobStatusDone
+		job.Result = string(result)
+	}
+	if err := a.deps.DBC.WithContext(ctx).Save(&job).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to save job")
+		return
+	}
+	logger.With("status", job.Status).Debug("job finished")
+}
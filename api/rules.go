@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/rules"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type ruleResponse struct {
+	ID         uint   `json:"id"`
+	Pattern    string `json:"pattern"`
+	MerchantID *uint  `json:"merchant_id,omitempty"`
+	Merchant   string `json:"merchant,omitempty"`
+	Category   string `json:"category"`
+	Learned    bool   `json:"learned"`
+	Hits       int    `json:"hits"`
+}
+
+type rulesResponse struct {
+	Rules []ruleResponse `json:"rules"`
+}
+
+type addRuleRequest struct {
+	Pattern    string `json:"pattern" binding:"required"`
+	Category   string `json:"category" binding:"required"`
+	MerchantID *uint  `json:"merchant_id"`
+}
+
+type recategorizeRequest struct {
+	Category string `json:"category" binding:"required"`
+}
+
+func ruleToResponse(rule db.CategoryRule) ruleResponse {
+	response := ruleResponse{ID: rule.ID, Pattern: rule.Pattern, MerchantID: rule.MerchantID, Learned: rule.Learned, Hits: rule.Hits}
+	if rule.Merchant != nil {
+		response.Merchant = rule.Merchant.Name
+	}
+	if rule.Category != nil {
+		response.Category = categories.Path(*rule.Category)
+	}
+	return response
+}
+
+// Lists rules of the user, to review the learned ones
+func (a *Api) listRules(c *gin.Context) {
+	user := currentUser(c)
+	userRules, err := rules.ForUser(c.Request.Context(), a.deps.DBC, user.ID)
+	if err != nil {
+		a.deps.Logger.With(log.USER_ID, user.ID).With(log.ERROR, err).Error("failed to list rules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rules"})
+		return
+	}
+	response := rulesResponse{Rules: []ruleResponse{}}
+	for _, rule := range userRules {
+		response.Rules = append(response.Rules, ruleToResponse(rule))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Adds a rule from JSON {"pattern": "oat milk", "category": "Food > Groceries", "merchant_id": 3}, merchant_id is optional
+func (a *Api) addRule(c *gin.Context) {
+	user := currentUser(c)
+	logger := a.deps.Logger.With(log.USER_ID, user.ID)
+	ctx := c.Request.Context()
+
+	var request addRuleRequest
+	if err := c.ShouldBindJSON(&request); err != nil || catalog.Normalize(request.Pattern) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pattern and category expected"})
+		return
+	}
+	category, ok := a.findCategory(c, user.ID, request.Category)
+	if !ok {
+		return
+	}
+	if request.MerchantID != nil {
+		if err := a.deps.DBC.WithContext(ctx).Where("user_id = ?", user.ID).First(&db.Merchant{}, *request.MerchantID).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.With(log.ERROR, errors.WithStack(err)).Error("failed to find merchant")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find merchant"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "merchant not found"})
+			return
+		}
+	}
+
+	rule, err := rules.Add(ctx, a.deps.DBC, user.ID, request.MerchantID, request.Pattern, category.ID)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to add rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add rule"})
+		return
+	}
+	rule.Category = category
+	c.JSON(http.StatusCreated, ruleToResponse(*rule))
+}
+
+func (a *Api) deleteRule(c *gin.Context) {
+	user := currentUser(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	deleted, err := rules.Delete(c.Request.Context(), a.deps.DBC, user.ID, uint(id))
+	if err != nil {
+		a.deps.Logger.With(log.USER_ID, user.ID).With(log.ERROR, err).Error("failed to delete rule")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete rule"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Moves the product to the category from JSON {"category": "Food > Groceries"} and learns a rule from it
+func (a *Api) recategorizeProduct(c *gin.Context) {
+	user := currentUser(c)
+	logger := a.deps.Logger.With(log.USER_ID, user.ID)
+
+	var request recategorizeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category expected"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	category, ok := a.findCategory(c, user.ID, request.Category)
+	if !ok {
+		return
+	}
+
+	product, rule, err := rules.Recategorize(c.Request.Context(), a.deps.DBC, user.ID, uint(id), *category)
+	if err != nil {
+		logger.With(log.PRODUCT_ID, id).With(log.ERROR, err).Error("failed to recategorize product")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recategorize product"})
+		return
+	}
+	if product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	response := gin.H{"product_id": product.ID, "category": categories.Path(*category)}
+	if rule != nil {
+		rule.Category = category
+		response["rule"] = ruleToResponse(*rule)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Finds a category of the user by path or title. Responds with an error and returns false if there is none
+func (a *Api) findCategory(c *gin.Context, userID uint, name string) (*db.Category, bool) {
+	category, err := categories.Find(c.Request.Context(), a.deps.DBC, userID, name)
+	if err != nil {
+		a.deps.Logger.With(log.USER_ID, userID).With(log.ERROR, err).Error("failed to find category")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find category"})
+		return nil, false
+	}
+	if category == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "category not found"})
+		return nil, false
+	}
+	return category, true
+}
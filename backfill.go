@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/llm"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/merchants"
+)
+
+// Handles `backfill-receipts` subcommand: fills missing fields of receipts and links them to merchants. Returns exit code
+func runBackfillReceipts() int {
+	logger, dbc, err := initializeCommand(true)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Initialization failed")
+		return 1
+	}
+	result, err := llm.BackfillReceipts(dbc, logger)
+	fmt.Printf("%d receipts updated\n", result.Updated)
+	if result.WithoutResponse > 0 {
+		fmt.Printf("%d receipts were parsed before LLM responses were stored: their currency is left empty and their date is the time of the message\n", result.WithoutResponse)
+	}
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Backfill failed")
+		return 1
+	}
+	linked, err := merchants.LinkReceipts(context.Background(), dbc, logger)
+	fmt.Printf("%d receipts linked to merchants\n", linked)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Linking merchants failed")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package budgets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/currency"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Percents of a budget the user is alerted at, ascending
+var alertPercents = []int{80, 100}
+
+// Spending in the category of the budget over its current period, in the base currency of the user.
+// Converted is false when there is no exchange rate for the currency of the budget, then Limit and Percent are unknown
+type Status struct {
+	Budget    db.Budget
+	From      time.Time
+	To        time.Time
+	Currency  string
+	Limit     decimal.Decimal
+	Spent     decimal.Decimal
+	Percent   int
+	Converted bool
+}
+
+// Totals per category of the user over a period, see stats.CategoryTotalsBetween
+type categoryTotalsFunc func(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) ([]stats.CategoryTotal, error)
+
+func ParsePeriod(period string) (db.BudgetPeriod, bool) {
+	switch p := db.BudgetPeriod(strings.ToLower(strings.TrimSpace(period))); p {
+	case db.BudgetPeriodWeek, db.BudgetPeriodMonth, db.BudgetPeriodYear:
+		return p, true
+	}
+	return "", false
+}
+
+// Calendar period containing the time in the location, as [from, to). Weeks start on Monday
+func PeriodBounds(period db.BudgetPeriod, t time.Time, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch period {
+	case db.BudgetPeriodWeek:
+		from := time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 0, 7)
+	case db.BudgetPeriodYear:
+		from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(1, 0, 0)
+	default:
+		from := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, 1, 0)
+	}
+}
+
+// Budgets of the user with their categories, oldest first
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint) ([]db.Budget, error) {
+	var budgets []db.Budget
+	if err := dbc.WithContext(ctx).Preload("Category.Parent").Where("user_id = ?", userID).Order("id asc").Find(&budgets).Error; err != nil {
+		return nil, fmt.Errorf("fetching budgets: %w", errors.WithStack(err))
+	}
+	return budgets, nil
+}
+
+// Sets the budget of the user for the category, replacing the one the category had
+func Set(ctx context.Context, dbc *gorm.DB, userID uint, category db.Category, period db.BudgetPeriod, amount decimal.Decimal, currency string) (*db.Budget, error) {
+	budget := db.Budget{UserID: userID, CategoryID: category.ID, Period: period, Amount: amount, Currency: currency}
+	err := dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND category_id = ?", userID, category.ID).Delete(&db.Budget{}).Error; err != nil {
+			return fmt.Errorf("deleting previous budget: %w", errors.WithStack(err))
+		}
+		if err := tx.Create(&budget).Error; err != nil {
+			return fmt.Errorf("creating budget: %w", errors.WithStack(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	budget.Category = &category
+	return &budget, nil
+}
+
+// Deletes the budget of the category. Returns false if the category had none
+func Delete(ctx context.Context, dbc *gorm.DB, userID uint, categoryID uint) (bool, error) {
+	result := dbc.WithContext(ctx).Where("user_id = ? AND category_id = ?", userID, categoryID).Delete(&db.Budget{})
+	if result.Error != nil {
+		return false, fmt.Errorf("deleting budget: %w", errors.WithStack(result.Error))
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Statuses of the budgets of the user in their periods containing the time
+func Statuses(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time) ([]Status, error) {
+	return computeStatuses(ctx, dbc, user, now, stats.CategoryTotalsBetween)
+}
+
+func computeStatuses(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time, categoryTotals categoryTotalsFunc) ([]Status, error) {
+	budgets, err := ForUser(ctx, dbc, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	baseCurrency := user.EffectiveBaseCurrency()
+	totalsPerPeriod := map[db.BudgetPeriod][]stats.CategoryTotal{}
+	var statuses []Status
+	for _, budget := range budgets {
+		// the category was deleted
+		if budget.Category == nil {
+			continue
+		}
+		status := Status{Budget: budget, Currency: baseCurrency, Limit: budget.Amount, Converted: true}
+		status.From, status.To = PeriodBounds(budget.Period, now, user.Location())
+
+		totals, ok := totalsPerPeriod[budget.Period]
+		if !ok {
+			if totals, err = categoryTotals(ctx, dbc, user, status.From, status.To); err != nil {
+				return nil, err
+			}
+			totalsPerPeriod[budget.Period] = totals
+		}
+		path := categories.Path(*budget.Category)
+		for _, total := range totals {
+			if total.Category == path || strings.HasPrefix(total.Category, path+categories.PATH_SEPARATOR) {
+				status.Spent = status.Spent.Add(total.Total)
+			}
+		}
+
+		if budget.Currency != "" && budget.Currency != baseCurrency {
+			status.Limit, err = currency.Convert(ctx, dbc, budget.Amount, budget.Currency, baseCurrency, now)
+			if err != nil {
+				status.Limit, status.Converted = decimal.Zero, false
+			}
+		}
+		if status.Limit.IsPositive() {
+			status.Percent = int(status.Spent.Mul(decimal.NewFromInt(100)).Div(status.Limit).IntPart())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Alerts about budgets of the user which reached 80% or 100% in the current period. Every threshold is alerted once per period.
+// Receipts LLM was unsure of don't count, as their totals are likely wrong
+func Check(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time) ([]string, error) {
+	statuses, err := computeStatuses(ctx, dbc, user, now, stats.SureCategoryTotalsBetween)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []string
+	for _, status := range statuses {
+		reached := 0
+		for _, percent := range alertPercents {
+			if status.Converted && status.Percent >= percent {
+				reached = percent
+			}
+		}
+		budget := status.Budget
+		alerted := budget.AlertedFrom != nil && budget.AlertedFrom.Equal(status.From) && budget.AlertedPercent >= reached
+		if reached == 0 || alerted {
+			continue
+		}
+		if err := dbc.WithContext(ctx).Model(&budget).Updates(map[string]any{"alerted_from": status.From, "alerted_percent": reached}).Error; err != nil {
+			return alerts, fmt.Errorf("remembering alert: %w", errors.WithStack(err))
+		}
+		alerts = append(alerts, Describe(status))
+	}
+	return alerts, nil
+}
+
+// Describes how much of the budget is spent, e.g. "Food: 245.00 of 300.00 EUR spent this month, 81%"
+func Describe(status Status) string {
+	period := "this " + string(status.Budget.Period)
+	path := categories.Path(*status.Budget.Category)
+	if !status.Converted {
+		return fmt.Sprintf("%s: %s %s spent %s of %s %s, no exchange rate to compare",
+			path, status.Spent.StringFixed(2), status.Currency, period, status.Budget.Amount.StringFixed(2), status.Budget.Currency)
+	}
+	text := fmt.Sprintf("%s: %s of %s %s spent %s, %d%%", path, status.Spent.StringFixed(2), status.Limit.StringFixed(2), status.Currency, period, status.Percent)
+	if status.Percent >= 100 {
+		text = "Budget exceeded! " + text
+	}
+	return text
+}
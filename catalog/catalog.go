@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Units LLM may return for the package size, by their canonical form
+var units = map[string]string{
+	"g": "g", "gr": "g", "gram": "g", "grams": "g",
+	"kg": "kg", "kilo": "kg", "kilogram": "kg",
+	"ml": "ml", "milliliter": "ml", "millilitre": "ml",
+	"l": "l", "liter": "l", "litre": "l", "ltr": "l",
+	"pcs": "pcs", "pc": "pcs", "piece": "pcs", "pieces": "pcs", "st": "pcs", "stk": "pcs",
+}
+
+// What is known about the product from a receipt
+type Item struct {
+	Name string
+	Unit string
+	Size decimal.Decimal
+}
+
+// Lowercase words of the name without punctuation, e.g. "Milk, 3.5%" -> "milk 3 5"
+func Normalize(name string) string {
+	return strings.Join(Words(name), " ")
+}
+
+func Words(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// Canonical unit, or empty if the unit is unknown
+func NormalizeUnit(unit string) string {
+	return units[strings.ToLower(strings.TrimSpace(unit))]
+}
+
+// Finds the catalog product of the user with the same name and package, or creates one. Returns nil if the item has no name
+func Resolve(ctx context.Context, dbc *gorm.DB, userID uint, item Item) (*db.CatalogProduct, error) {
+	dbc = dbc.WithContext(ctx)
+	normalized := Normalize(item.Name)
+	if normalized == "" {
+		return nil, nil
+	}
+	unit := NormalizeUnit(item.Unit)
+	size := item.Size
+	if unit == "" {
+		size = decimal.Zero
+	}
+
+	var candidates []db.CatalogProduct
+	if err := dbc.Where("user_id = ? AND normalized_name = ? AND unit = ?", userID, normalized, unit).Order("id asc").Find(&candidates).Error; err != nil {
+		return nil, fmt.Errorf("fetching catalog products: %w", errors.WithStack(err))
+	}
+	for i, candidate := range candidates {
+		if candidate.Size.Equal(size) {
+			return &candidates[i], nil
+		}
+	}
+
+	product := db.CatalogProduct{UserID: userID, Name: strings.TrimSpace(item.Name), NormalizedName: normalized, Unit: unit, Size: size}
+	if err := dbc.Create(&product).Error; err != nil {
+		return nil, fmt.Errorf("creating catalog product: %w", errors.WithStack(err))
+	}
+	return &product, nil
+}
+
+// Catalog products of the user, which names have all the words of the query, e.g. "milk" finds "Milk 3.5%" and "Oat milk"
+func Search(ctx context.Context, dbc *gorm.DB, userID uint, query string) ([]db.CatalogProduct, error) {
+	queryWords := Words(query)
+	if len(queryWords) == 0 {
+		return nil, nil
+	}
+	var products []db.CatalogProduct
+	if err := dbc.WithContext(ctx).Where("user_id = ?", userID).Order("name asc").Find(&products).Error; err != nil {
+		return nil, fmt.Errorf("fetching catalog products: %w", errors.WithStack(err))
+	}
+
+	var found []db.CatalogProduct
+	for _, product := range products {
+		nameWords := map[string]bool{}
+		for _, word := range strings.Fields(product.NormalizedName) {
+			nameWords[word] = true
+		}
+		matches := true
+		for _, word := range queryWords {
+			if !nameWords[word] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, product)
+		}
+	}
+	return found, nil
+}
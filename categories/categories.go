@@ -0,0 +1,264 @@
+package categories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	// Separates a category from its parent in paths, e.g. "Food > Groceries"
+	PATH_SEPARATOR = " > "
+)
+
+// A mistake in what the user asked for, to be explained to the user
+type InputError struct {
+	message string
+}
+
+func (e InputError) Error() string {
+	return e.message
+}
+
+func inputErrorf(format string, args ...any) error {
+	return InputError{message: fmt.Sprintf(format, args...)}
+}
+
+// Path of the category from the top of the tree, e.g. "Food > Groceries". The parent has to be loaded
+func Path(category db.Category) string {
+	if category.Parent != nil {
+		return category.Parent.Title + PATH_SEPARATOR + category.Title
+	}
+	return category.Title
+}
+
+// Copies the templates to the user, if the user has no categories yet. Subcategories are built from Details of the templates.
+// Products and merchants of the user linked to the templates are moved to the copies
+func EnsureTree(ctx context.Context, dbc *gorm.DB, userID uint) error {
+	dbc = dbc.WithContext(ctx)
+	if has, err := hasCategories(dbc, userID); err != nil || has {
+		return err
+	}
+
+	return dbc.Transaction(func(tx *gorm.DB) error {
+		// concurrent calls wait here for the tree instead of copying it twice. An update locks the row in postgres
+		// and the database in sqlite, which has no SELECT FOR UPDATE
+		if err := tx.Model(&db.User{}).Where("id = ?", userID).UpdateColumn("id", gorm.Expr("id")).Error; err != nil {
+			return fmt.Errorf("locking user: %w", errors.WithStack(err))
+		}
+		if has, err := hasCategories(tx, userID); err != nil || has {
+			return err
+		}
+		var templates []db.Category
+		if err := tx.Where("user_id IS NULL AND parent_id IS NULL").Order("id asc").Find(&templates).Error; err != nil {
+			return fmt.Errorf("fetching templates: %w", errors.WithStack(err))
+		}
+		for _, template := range templates {
+			category := db.Category{UserID: &userID, Title: template.Title}
+			if err := tx.Create(&category).Error; err != nil {
+				return fmt.Errorf("copying category %q: %w", template.Title, errors.WithStack(err))
+			}
+			for _, title := range strings.Split(template.Details, ";") {
+				if title = strings.TrimSpace(title); title == "" {
+					continue
+				}
+				child := db.Category{UserID: &userID, ParentID: &category.ID, Title: title}
+				if err := tx.Create(&child).Error; err != nil {
+					return fmt.Errorf("creating subcategory %q: %w", title, errors.WithStack(err))
+				}
+			}
+
+			userProducts := tx.Table("products").Select("products.id").
+				Joins("JOIN receipts ON receipts.id = products.receipt_id").
+				Where("receipts.user_id = ?", userID)
+			if err := tx.Model(&db.ProductCategory{}).
+				Where("category_id = ? AND product_id IN (?)", template.ID, userProducts).
+				Update("category_id", category.ID).Error; err != nil {
+				return fmt.Errorf("moving products to category %q: %w", template.Title, errors.WithStack(err))
+			}
+			if err := tx.Model(&db.Merchant{}).
+				Where("user_id = ? AND default_category_id = ?", userID, template.ID).
+				Update("default_category_id", category.ID).Error; err != nil {
+				return fmt.Errorf("moving merchants to category %q: %w", template.Title, errors.WithStack(err))
+			}
+		}
+		return nil
+	})
+}
+
+func hasCategories(dbc *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	if err := dbc.Model(&db.Category{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("counting categories: %w", errors.WithStack(err))
+	}
+	return count > 0, nil
+}
+
+// Categories of the user with their parents, ordered by path
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint) ([]db.Category, error) {
+	if err := EnsureTree(ctx, dbc, userID); err != nil {
+		return nil, err
+	}
+	var categories []db.Category
+	if err := dbc.WithContext(ctx).Preload("Parent").Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		return nil, fmt.Errorf("fetching categories: %w", errors.WithStack(err))
+	}
+	sort.Slice(categories, func(i, j int) bool { return Path(categories[i]) < Path(categories[j]) })
+	return categories, nil
+}
+
+// Finds a category of the user by path, like "Food > Groceries", or by title. Top categories win over subcategories with the same title.
+// Returns nil if there is no such category
+func Find(ctx context.Context, dbc *gorm.DB, userID uint, name string) (*db.Category, error) {
+	categories, err := ForUser(ctx, dbc, userID)
+	if err != nil {
+		return nil, err
+	}
+	name = normalize(name)
+	for i, category := range categories {
+		if normalize(Path(category)) == name {
+			return &categories[i], nil
+		}
+	}
+	var found *db.Category
+	for i, category := range categories {
+		if normalize(category.Title) == name && (found == nil || category.ParentID == nil) {
+			found = &categories[i]
+		}
+	}
+	return found, nil
+}
+
+// Whether the category path is the category or its subcategory, e.g. "Food > Groceries" is within "food"
+func Within(path string, category string) bool {
+	path, category = normalize(path), normalize(category)
+	return path == category || strings.HasPrefix(path, category+PATH_SEPARATOR)
+}
+
+func normalize(name string) string {
+	parts := strings.Split(name, strings.TrimSpace(PATH_SEPARATOR))
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.Join(strings.Fields(part), " "))
+	}
+	return strings.Join(parts, PATH_SEPARATOR)
+}
+
+// Adds a category by path, e.g. "Food > Snacks" adds Snacks to Food. Only two levels are supported
+func Add(ctx context.Context, dbc *gorm.DB, userID uint, path string) (*db.Category, error) {
+	parentName, title, isChild := strings.Cut(path, strings.TrimSpace(PATH_SEPARATOR))
+	if !isChild {
+		parentName, title = "", parentName
+	}
+	title = strings.TrimSpace(title)
+	if err := checkTitle(title); err != nil {
+		return nil, err
+	}
+	categories, err := ForUser(ctx, dbc, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range categories {
+		if normalize(Path(existing)) == normalize(path) {
+			return nil, inputErrorf("category %q already exists", Path(existing))
+		}
+	}
+
+	category := db.Category{UserID: &userID, Title: title}
+	if isChild {
+		parent, err := Find(ctx, dbc, userID, parentName)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil || parent.ParentID != nil {
+			return nil, inputErrorf("no top category %q", strings.TrimSpace(parentName))
+		}
+		category.ParentID = &parent.ID
+		category.Parent = parent
+	}
+	if err := dbc.WithContext(ctx).Omit("Parent").Create(&category).Error; err != nil {
+		return nil, fmt.Errorf("creating category: %w", errors.WithStack(err))
+	}
+	return &category, nil
+}
+
+// Titles can't be empty or have the separator of paths, as the category couldn't be found by its path
+func checkTitle(title string) error {
+	if title == "" || strings.Contains(title, strings.TrimSpace(PATH_SEPARATOR)) {
+		return inputErrorf("%q can't be a category title", title)
+	}
+	return nil
+}
+
+// Renames the category, keeping its place in the tree. Other categories under the same parent can't have the title
+func Rename(ctx context.Context, dbc *gorm.DB, category db.Category, title string) error {
+	title = strings.TrimSpace(title)
+	if err := checkTitle(title); err != nil {
+		return err
+	}
+	if category.UserID != nil {
+		categories, err := ForUser(ctx, dbc, *category.UserID)
+		if err != nil {
+			return err
+		}
+		for _, sibling := range categories {
+			if sibling.ID != category.ID && lo.FromPtr(sibling.ParentID) == lo.FromPtr(category.ParentID) && normalize(sibling.Title) == normalize(title) {
+				return inputErrorf("category %q already exists", Path(sibling))
+			}
+		}
+	}
+	if err := dbc.WithContext(ctx).Model(&category).Update("title", title).Error; err != nil {
+		return fmt.Errorf("renaming category: %w", errors.WithStack(err))
+	}
+	return nil
+}
+
+// Moves products, merchants, rules, budget and subcategories of the category to another one and deletes the category
+func Merge(ctx context.Context, dbc *gorm.DB, from db.Category, into db.Category) error {
+	if from.ID == into.ID {
+		return inputErrorf("can't merge a category into itself")
+	}
+	if into.ParentID != nil && *into.ParentID == from.ID {
+		return inputErrorf("can't merge a category into its subcategory")
+	}
+	return dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// products in both categories would be linked twice
+		alreadyInto := tx.Table("product_categories").Select("product_id").Where("category_id = ?", into.ID)
+		if err := tx.Unscoped().Where("category_id = ? AND product_id IN (?)", from.ID, alreadyInto).Delete(&db.ProductCategory{}).Error; err != nil {
+			return fmt.Errorf("unlinking products in both categories: %w", errors.WithStack(err))
+		}
+		if err := tx.Model(&db.ProductCategory{}).Where("category_id = ?", from.ID).Update("category_id", into.ID).Error; err != nil {
+			return fmt.Errorf("moving products: %w", errors.WithStack(err))
+		}
+		if err := tx.Model(&db.Merchant{}).Where("default_category_id = ?", from.ID).Update("default_category_id", into.ID).Error; err != nil {
+			return fmt.Errorf("moving merchants: %w", errors.WithStack(err))
+		}
+		if err := tx.Model(&db.CategoryRule{}).Where("category_id = ?", from.ID).Update("category_id", into.ID).Error; err != nil {
+			return fmt.Errorf("moving rules: %w", errors.WithStack(err))
+		}
+		// a category has one budget, the one of the category merged into stays
+		intoBudgets := tx.Table("budgets").Select("user_id").Where("category_id = ? AND deleted_at IS NULL", into.ID)
+		if err := tx.Where("category_id = ? AND user_id IN (?)", from.ID, intoBudgets).Delete(&db.Budget{}).Error; err != nil {
+			return fmt.Errorf("deleting budget: %w", errors.WithStack(err))
+		}
+		if err := tx.Model(&db.Budget{}).Where("category_id = ?", from.ID).Update("category_id", into.ID).Error; err != nil {
+			return fmt.Errorf("moving budget: %w", errors.WithStack(err))
+		}
+		parentID := &into.ID
+		if into.ParentID != nil {
+			parentID = into.ParentID
+		}
+		if err := tx.Model(&db.Category{}).Where("parent_id = ?", from.ID).Update("parent_id", parentID).Error; err != nil {
+			return fmt.Errorf("moving subcategories: %w", errors.WithStack(err))
+		}
+		if err := tx.Delete(&from).Error; err != nil {
+			return fmt.Errorf("deleting category: %w", errors.WithStack(err))
+		}
+		return nil
+	})
+}
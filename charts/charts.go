@@ -0,0 +1,220 @@
+package charts
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type Kind string
+
+const (
+	KindPie  Kind = "pie"
+	KindBar  Kind = "bar"
+	KindLine Kind = "line"
+)
+
+const (
+	// Slices of a pie, smaller categories are merged into OTHER
+	MAX_SLICES = 8
+	OTHER      = "Other"
+	// Bars and points charted when the period isn't set, ending with the current month or week
+	DEFAULT_MONTHS = 12
+	DEFAULT_WEEKS  = 12
+	// Bars or points a chart may have
+	MAX_PERIODS = 60
+)
+
+var ErrTooManyPeriods = fmt.Errorf("a chart may have at most %d bars or points, make the period shorter", MAX_PERIODS)
+
+// What to chart: spending by category over [From, To) as a pie, per month as bars or per week as a line.
+// Category limits the chart to the category with its subcategories. Zero From and To are set by Build
+type Spec struct {
+	Kind     Kind
+	From     time.Time
+	To       time.Time
+	Category string
+}
+
+type Point struct {
+	Label string          `json:"label"`
+	Value decimal.Decimal `json:"value"`
+}
+
+type Chart struct {
+	Kind     Kind    `json:"kind"`
+	Title    string  `json:"title"`
+	Currency string  `json:"currency"`
+	Points   []Point `json:"points"`
+}
+
+func ParseKind(kind string) (Kind, bool) {
+	switch k := Kind(strings.ToLower(strings.TrimSpace(kind))); k {
+	case KindPie, KindBar, KindLine:
+		return k, true
+	}
+	return "", false
+}
+
+// Reads the spec from query parameters: kind, from and to as YYYY-MM-DD in the location, to inclusive, and category
+func ParseSpec(query url.Values, loc *time.Location) (Spec, error) {
+	kind, ok := ParseKind(query.Get("kind"))
+	if !ok {
+		return Spec{}, fmt.Errorf("unknown chart kind %q, use pie, bar or line", query.Get("kind"))
+	}
+	spec := Spec{Kind: kind, Category: strings.TrimSpace(query.Get("category"))}
+	if from := query.Get("from"); from != "" {
+		t, err := time.ParseInLocation(time.DateOnly, from, loc)
+		if err != nil {
+			return Spec{}, fmt.Errorf("from %q isn't YYYY-MM-DD", from)
+		}
+		spec.From = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.ParseInLocation(time.DateOnly, to, loc)
+		if err != nil {
+			return Spec{}, fmt.Errorf("to %q isn't YYYY-MM-DD", to)
+		}
+		spec.To = t.AddDate(0, 0, 1)
+	}
+	if !spec.From.IsZero() && !spec.To.IsZero() && !spec.From.Before(spec.To) {
+		return Spec{}, errors.New("from should be before to")
+	}
+	return spec, nil
+}
+
+// Charts spending of the user with the same totals spending summaries use, in the base currency of the user
+func Build(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, now time.Time) (Chart, error) {
+	chart := Chart{Kind: spec.Kind, Currency: user.EffectiveBaseCurrency()}
+	loc := user.Location()
+	of := ""
+	if spec.Category != "" {
+		of = " on " + spec.Category
+	}
+	switch spec.Kind {
+	case KindPie:
+		from, to := budgets.PeriodBounds(db.BudgetPeriodMonth, now, loc)
+		if !spec.From.IsZero() {
+			from = spec.From
+		}
+		if !spec.To.IsZero() {
+			to = spec.To
+		}
+		totals, err := stats.CategoryTotalsBetween(ctx, dbc, user, from, to)
+		if err != nil {
+			return chart, err
+		}
+		chart.Points = slices(totals, spec.Category)
+		chart.Title = fmt.Sprintf("Spending%s by category, %s - %s", of, from.Format("Jan 2"), to.AddDate(0, 0, -1).Format("Jan 2, 2006"))
+	case KindBar:
+		points, err := perPeriod(ctx, dbc, user, spec, db.BudgetPeriodMonth, DEFAULT_MONTHS, "Jan 06", now)
+		if err != nil {
+			return chart, err
+		}
+		chart.Points = points
+		chart.Title = fmt.Sprintf("Spending%s per month, %s", of, chart.Currency)
+	case KindLine:
+		points, err := perPeriod(ctx, dbc, user, spec, db.BudgetPeriodWeek, DEFAULT_WEEKS, "Jan 2", now)
+		if err != nil {
+			return chart, err
+		}
+		chart.Points = points
+		chart.Title = fmt.Sprintf("Spending%s per week, %s", of, chart.Currency)
+	default:
+		return chart, fmt.Errorf("unknown chart kind %q", spec.Kind)
+	}
+	return chart, nil
+}
+
+// Totals of the category and its subcategories, or of all categories, biggest first. Small ones are merged into OTHER
+func slices(totals []stats.CategoryTotal, category string) []Point {
+	var points []Point
+	for _, total := range totals {
+		if category != "" && !categories.Within(total.Category, category) {
+			continue
+		}
+		if total.Total.IsPositive() {
+			points = append(points, Point{Label: total.Category, Value: total.Total})
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Value.GreaterThan(points[j].Value) })
+	if len(points) > MAX_SLICES {
+		other := Point{Label: OTHER}
+		for _, point := range points[MAX_SLICES-1:] {
+			other.Value = other.Value.Add(point.Value)
+		}
+		points = append(points[:MAX_SLICES-1], other)
+	}
+	return points
+}
+
+// Totals of consecutive periods from the spec, or the last count periods till now
+func perPeriod(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, period db.BudgetPeriod, count int, labelFormat string, now time.Time) ([]Point, error) {
+	loc := user.Location()
+	from, to := spec.From, spec.To
+	if to.IsZero() {
+		_, to = budgets.PeriodBounds(period, now, loc)
+	}
+	if from.IsZero() {
+		from = to.Add(-time.Nanosecond)
+		for i := 0; i < count; i++ {
+			from, _ = budgets.PeriodBounds(period, from.Add(-time.Nanosecond), loc)
+		}
+	}
+
+	var points []Point
+	for start, _ := budgets.PeriodBounds(period, from, loc); start.Before(to); {
+		if len(points) == MAX_PERIODS {
+			return nil, ErrTooManyPeriods
+		}
+		_, end := budgets.PeriodBounds(period, start, loc)
+		total, err := totalBetween(ctx, dbc, user, spec.Category, start, end)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, Point{Label: start.Format(labelFormat), Value: total})
+		start = end
+	}
+	return points, nil
+}
+
+func totalBetween(ctx context.Context, dbc *gorm.DB, user db.User, category string, from time.Time, to time.Time) (decimal.Decimal, error) {
+	if category == "" {
+		spending, err := stats.SpendingBetween(ctx, dbc, user, from, to)
+		return spending.Total, err
+	}
+	totals, err := stats.CategoryTotalsBetween(ctx, dbc, user, from, to)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	total := decimal.Zero
+	for _, t := range totals {
+		if categories.Within(t.Category, category) {
+			total = total.Add(t.Total)
+		}
+	}
+	return total, nil
+}
+
+// The chart as text, for clients which can't show images
+func (chart Chart) Text() string {
+	lines := []string{chart.Title + ":"}
+	if len(chart.Points) == 0 {
+		lines = append(lines, "no spending")
+	}
+	for _, point := range chart.Points {
+		lines = append(lines, fmt.Sprintf("%s: %s %s", point.Label, point.Value.StringFixed(2), chart.Currency))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,116 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Pixels drawn per pixel of the picture, averaged to smooth edges
+const SUPERSAMPLING = 2
+
+// Draws on an image SUPERSAMPLING times bigger than the picture
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+// Renders the chart as a PNG picture
+func RenderPNG(chart Chart) ([]byte, error) {
+	c := pngCanvas{img: image.NewRGBA(image.Rect(0, 0, WIDTH*SUPERSAMPLING, HEIGHT*SUPERSAMPLING))}
+	paint(c, chart)
+	var out bytes.Buffer
+	if err := png.Encode(&out, c.downsample()); err != nil {
+		return nil, fmt.Errorf("encoding png: %w", errors.WithStack(err))
+	}
+	return out.Bytes(), nil
+}
+
+func (c pngCanvas) downsample() *image.RGBA {
+	small := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for y := 0; y < HEIGHT; y++ {
+		for x := 0; x < WIDTH; x++ {
+			var r, g, b int
+			for dy := 0; dy < SUPERSAMPLING; dy++ {
+				for dx := 0; dx < SUPERSAMPLING; dx++ {
+					pixel := c.img.RGBAAt(x*SUPERSAMPLING+dx, y*SUPERSAMPLING+dy)
+					r, g, b = r+int(pixel.R), g+int(pixel.G), b+int(pixel.B)
+				}
+			}
+			n := SUPERSAMPLING * SUPERSAMPLING
+			small.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff})
+		}
+	}
+	return small
+}
+
+// Pixels of the bigger image covering the box of the picture, clipped to the image
+func (c pngCanvas) bounds(x1, y1, x2, y2 float64) image.Rectangle {
+	s := float64(SUPERSAMPLING)
+	return image.Rect(int(math.Floor(x1*s)), int(math.Floor(y1*s)), int(math.Ceil(x2*s)), int(math.Ceil(y2*s))).Intersect(c.img.Bounds())
+}
+
+func (c pngCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	draw.Draw(c.img, c.bounds(x, y, x+w, y+h), &image.Uniform{fill}, image.Point{}, draw.Src)
+}
+
+func (c pngCanvas) line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	half := width / 2
+	box := c.bounds(math.Min(x1, x2)-half, math.Min(y1, y2)-half, math.Max(x1, x2)+half, math.Max(y1, y2)+half)
+	dx, dy := x2-x1, y2-y1
+	length := dx*dx + dy*dy
+	for py := box.Min.Y; py < box.Max.Y; py++ {
+		for px := box.Min.X; px < box.Max.X; px++ {
+			x, y := (float64(px)+0.5)/SUPERSAMPLING, (float64(py)+0.5)/SUPERSAMPLING
+			// distance to the closest point of the segment
+			t := 0.0
+			if length > 0 {
+				t = math.Max(0, math.Min(1, ((x-x1)*dx+(y-y1)*dy)/length))
+			}
+			if math.Hypot(x-(x1+t*dx), y-(y1+t*dy)) <= half {
+				c.img.SetRGBA(px, py, stroke)
+			}
+		}
+	}
+}
+
+func (c pngCanvas) wedge(cx, cy, r, from, to float64, fill color.RGBA) {
+	box := c.bounds(cx-r, cy-r, cx+r, cy+r)
+	for py := box.Min.Y; py < box.Max.Y; py++ {
+		for px := box.Min.X; px < box.Max.X; px++ {
+			x, y := (float64(px)+0.5)/SUPERSAMPLING-cx, (float64(py)+0.5)/SUPERSAMPLING-cy
+			if math.Hypot(x, y) > r {
+				continue
+			}
+			if angle := math.Mod(math.Atan2(y, x)-from+4*math.Pi, 2*math.Pi); angle <= to-from {
+				c.img.SetRGBA(px, py, fill)
+			}
+		}
+	}
+}
+
+func (c pngCanvas) text(x, y float64, text string, size int, alignment align, fill color.RGBA) {
+	text = asciiFold(text)
+	switch alignment {
+	case alignCenter:
+		x -= textWidth(text, size) / 2
+	case alignRight:
+		x -= textWidth(text, size)
+	}
+	for _, r := range text {
+		columns := glyph(r)
+		for column, bits := range columns {
+			for row := 0; row < GLYPH_HEIGHT; row++ {
+				if bits&(1<<row) != 0 {
+					c.rect(x+float64(column*size), y+float64(row*size), float64(size), float64(size), fill)
+				}
+			}
+		}
+		x += float64(GLYPH_ADVANCE * size)
+	}
+}
@@ -0,0 +1,177 @@
+package charts
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	WIDTH  = 1200
+	HEIGHT = 800
+	// Scales of the font: titles, labels
+	TITLE_SIZE = 4
+	LABEL_SIZE = 2
+	// Plot area of bars and lines
+	PLOT_LEFT   = 150
+	PLOT_RIGHT  = WIDTH - 40
+	PLOT_TOP    = 110
+	PLOT_BOTTOM = HEIGHT - 80
+	Y_TICKS     = 5
+)
+
+type align int
+
+const (
+	alignLeft align = iota
+	alignCenter
+	alignRight
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	ink        = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	grid       = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	palette    = []color.RGBA{
+		{0x4e, 0x79, 0xa7, 0xff}, {0xf2, 0x8e, 0x2b, 0xff}, {0xe1, 0x57, 0x59, 0xff}, {0x76, 0xb7, 0xb2, 0xff},
+		{0x59, 0xa1, 0x4f, 0xff}, {0xed, 0xc9, 0x48, 0xff}, {0xb0, 0x7a, 0xa1, 0xff}, {0x9c, 0x75, 0x5f, 0xff},
+	}
+	otherColor = color.RGBA{0xba, 0xb0, 0xac, 0xff}
+)
+
+// Where a chart is drawn. Coordinates are in pixels of a WIDTH x HEIGHT picture from the top left corner, angles in radians clockwise from 3 o'clock
+type canvas interface {
+	rect(x, y, w, h float64, fill color.RGBA)
+	line(x1, y1, x2, y2, width float64, stroke color.RGBA)
+	wedge(cx, cy, r, from, to float64, fill color.RGBA)
+	// y is the top of the text, size scales the font
+	text(x, y float64, text string, size int, alignment align, fill color.RGBA)
+}
+
+func textWidth(text string, size int) float64 {
+	return float64(utf8.RuneCountInString(text)*GLYPH_ADVANCE*size - size)
+}
+
+// Cuts the text to fit the width, marking the cut with ".."
+func fit(text string, width float64, size int) string {
+	if textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"..", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + ".."
+}
+
+func paint(c canvas, chart Chart) {
+	c.rect(0, 0, WIDTH, HEIGHT, background)
+	c.text(WIDTH/2, 30, fit(chart.Title, WIDTH-40, TITLE_SIZE), TITLE_SIZE, alignCenter, ink)
+	total := decimal.Zero
+	for _, point := range chart.Points {
+		total = total.Add(point.Value)
+	}
+	if !total.IsPositive() {
+		c.text(WIDTH/2, HEIGHT/2, "No spending in the period", LABEL_SIZE*2, alignCenter, ink)
+		return
+	}
+	switch chart.Kind {
+	case KindPie:
+		drawPie(c, chart, total)
+	default:
+		drawAxes(c, chart)
+	}
+}
+
+func drawPie(c canvas, chart Chart, total decimal.Decimal) {
+	cx, cy, r := 360.0, 450.0, 300.0
+	angle := -math.Pi / 2
+	legendX, legendY := 720.0, 170.0
+	for i, point := range chart.Points {
+		fill := palette[i%len(palette)]
+		if point.Label == OTHER {
+			fill = otherColor
+		}
+		share := point.Value.Div(total).InexactFloat64()
+		c.wedge(cx, cy, r, angle, angle+share*2*math.Pi, fill)
+		angle += share * 2 * math.Pi
+
+		y := legendY + float64(i)*60
+		c.rect(legendX, y, 28, 28, fill)
+		c.text(legendX+44, y-2, fit(point.Label, WIDTH-legendX-60, LABEL_SIZE), LABEL_SIZE, alignLeft, ink)
+		c.text(legendX+44, y+22, fmt.Sprintf("%s %s, %.0f%%", point.Value.StringFixed(2), chart.Currency, share*100), LABEL_SIZE, alignLeft, ink)
+	}
+}
+
+// Bars or a line over a grid with values on the left axis and periods below
+func drawAxes(c canvas, chart Chart) {
+	maxValue := 0.0
+	for _, point := range chart.Points {
+		maxValue = math.Max(maxValue, point.Value.InexactFloat64())
+	}
+	step := niceStep(maxValue / Y_TICKS)
+	top := step * math.Ceil(maxValue/step)
+	plotHeight := float64(PLOT_BOTTOM - PLOT_TOP)
+	y := func(value float64) float64 { return PLOT_BOTTOM - value/top*plotHeight }
+
+	for tick := 0.0; tick <= top+step/2; tick += step {
+		c.line(PLOT_LEFT, y(tick), PLOT_RIGHT, y(tick), 2, grid)
+		c.text(PLOT_LEFT-14, y(tick)-8, formatTick(tick, step), LABEL_SIZE, alignRight, ink)
+	}
+
+	slot := float64(PLOT_RIGHT-PLOT_LEFT) / float64(len(chart.Points))
+	// labels which don't fit the slot are shown for every n-th point
+	every := 1
+	for _, point := range chart.Points {
+		every = max(every, int(math.Ceil((textWidth(point.Label, LABEL_SIZE)+12)/slot)))
+	}
+	fill := palette[0]
+	for i, point := range chart.Points {
+		x := PLOT_LEFT + slot*(float64(i)+0.5)
+		value := point.Value.InexactFloat64()
+		if chart.Kind == KindBar {
+			width := slot * 0.7
+			c.rect(x-width/2, y(value), width, PLOT_BOTTOM-y(value), fill)
+			if label := point.Value.StringFixed(0); textWidth(label, LABEL_SIZE) <= slot && value > 0 {
+				c.text(x, y(value)-24, label, LABEL_SIZE, alignCenter, ink)
+			}
+		} else {
+			if i > 0 {
+				previous := PLOT_LEFT + slot*(float64(i)-0.5)
+				c.line(previous, y(chart.Points[i-1].Value.InexactFloat64()), x, y(value), 5, fill)
+			}
+			c.wedge(x, y(value), 8, 0, 2*math.Pi, fill)
+		}
+		if (len(chart.Points)-1-i)%every == 0 {
+			c.text(x, PLOT_BOTTOM+16, point.Label, LABEL_SIZE, alignCenter, ink)
+		}
+	}
+	c.line(PLOT_LEFT, PLOT_BOTTOM, PLOT_RIGHT, PLOT_BOTTOM, 2, ink)
+}
+
+// A round step for ticks at least as big as the raw one: 1, 2, 2.5 or 5 times a power of 10
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, nice := range []float64{1, 2, 2.5, 5, 10} {
+		if raw <= nice*magnitude {
+			return nice * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatTick(tick float64, step float64) string {
+	if step < 1 {
+		return fmt.Sprintf("%.2f", tick)
+	}
+	if step != math.Trunc(step) {
+		return fmt.Sprintf("%.1f", tick)
+	}
+	return fmt.Sprintf("%.0f", tick)
+}
@@ -0,0 +1,57 @@
+package charts
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Writes SVG elements. Text is monospace, sized to take as much space as the PNG font
+type svgCanvas struct {
+	out *bytes.Buffer
+}
+
+// Renders the chart as an SVG picture
+func RenderSVG(chart Chart) []byte {
+	c := svgCanvas{out: &bytes.Buffer{}}
+	fmt.Fprintf(c.out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, WIDTH, HEIGHT, WIDTH, HEIGHT)
+	paint(c, chart)
+	c.out.WriteString("</svg>")
+	return c.out.Bytes()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (c svgCanvas) rect(x, y, w, h float64, fill color.RGBA) {
+	fmt.Fprintf(c.out, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, hex(fill))
+}
+
+func (c svgCanvas) line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	fmt.Fprintf(c.out, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%.1f" stroke-linecap="round"/>`, x1, y1, x2, y2, hex(stroke), width)
+}
+
+func (c svgCanvas) wedge(cx, cy, r, from, to float64, fill color.RGBA) {
+	if to-from >= 2*math.Pi-1e-9 {
+		fmt.Fprintf(c.out, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"/>`, cx, cy, r, hex(fill))
+		return
+	}
+	large := 0
+	if to-from > math.Pi {
+		large = 1
+	}
+	fmt.Fprintf(c.out, `<path d="M%.1f %.1f L%.2f %.2f A%.1f %.1f 0 %d 1 %.2f %.2f Z" fill="%s"/>`,
+		cx, cy, cx+r*math.Cos(from), cy+r*math.Sin(from), r, r, large, cx+r*math.Cos(to), cy+r*math.Sin(to), hex(fill))
+}
+
+func (c svgCanvas) text(x, y float64, text string, size int, alignment align, fill color.RGBA) {
+	anchor := map[align]string{alignLeft: "start", alignCenter: "middle", alignRight: "end"}[alignment]
+	// monospace glyphs are 0.6 of the font size wide, as GLYPH_ADVANCE of 10 rows
+	fmt.Fprintf(c.out, `<text x="%.1f" y="%.1f" font-family="monospace" font-size="%d" text-anchor="%s" fill="%s">`,
+		x, y+float64(7*size), 10*size, anchor, hex(fill))
+	xml.EscapeText(c.out, []byte(text))
+	c.out.WriteString("</text>")
+}
@@ -0,0 +1,70 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/export"
+)
+
+const accountUsage = `Send /account Food > Groceries = Expenses:Food:Groceries to post products of a category to an account in ledger, hledger and beancount exports,
+/account payment = Liabilities:CreditCard for the account receipts are paid from, /account tax = Expenses:Taxes:VAT for tax,
+and /account Food = to go back to the default. Subcategories go under the account of their category unless they have their own`
+
+// Shows accounts of plain-text accounting exports, or sets one
+func editAccount(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	if args == "" {
+		return listAccounts(ctx, chatter, message.UserID)
+	}
+	name, account, ok := strings.Cut(args, "=")
+	if !ok {
+		return accountUsage, nil
+	}
+	name, account = strings.TrimSpace(name), strings.TrimSpace(account)
+	if account != "" {
+		if err := export.ValidateAccount(account); err != nil {
+			return err.Error(), nil
+		}
+	}
+
+	role, categoryID, subject := db.LedgerAccountRoleCategory, (*uint)(nil), ""
+	switch strings.ToLower(name) {
+	case string(db.LedgerAccountRolePayment):
+		role, subject = db.LedgerAccountRolePayment, "Receipts are paid from"
+	case string(db.LedgerAccountRoleTax):
+		role, subject = db.LedgerAccountRoleTax, "Tax goes to"
+	default:
+		category, reply, err := chatter.findCategory(ctx, message.UserID, name)
+		if category == nil {
+			return reply, err
+		}
+		categoryID, subject = &category.ID, fmt.Sprintf("Products of %s go to", categories.Path(*category))
+	}
+	if err := export.SetAccount(ctx, chatter.deps.DBC, message.UserID, role, categoryID, account); err != nil {
+		return "", err
+	}
+	if account == "" {
+		return subject + " the default account again", nil
+	}
+	return fmt.Sprintf("%s %s", subject, account), nil
+}
+
+func listAccounts(ctx context.Context, chatter *Chatter, userID uint) (string, error) {
+	accounts, err := export.LoadAccounts(ctx, chatter.deps.DBC, userID)
+	if err != nil {
+		return "", err
+	}
+	userCategories, err := categories.ForUser(ctx, chatter.deps.DBC, userID)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{"Accounts of ledger, hledger and beancount exports:", "Paid from: " + accounts.Payment, "Tax: " + accounts.Tax}
+	for _, category := range userCategories {
+		lines = append(lines, fmt.Sprintf("%s: %s", categories.Path(category), accounts.ForCategory(category)))
+	}
+	lines = append(lines, "", accountUsage)
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,59 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/anomalies"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+)
+
+// How many latest anomalies /anomalies lists
+const anomaliesListed = 20
+
+const anomalyUsage = `I alert about receipts far above what you usually spend at a merchant or on a category, weeks you spend much more on a category, and charges in foreign currencies.
+Send /expected ID when an alert is fine, and I won't alert about similar spending`
+
+// Lists the latest anomalies of the user
+func listAnomalies(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	found, err := anomalies.ForUser(ctx, chatter.deps.DBC, user.ID, anomaliesListed)
+	if err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return "I haven't noticed unusual spending yet.\n\n" + anomalyUsage, nil
+	}
+	lines := []string{"Unusual spending:"}
+	for _, anomaly := range found {
+		line := fmt.Sprintf("%d. %s", anomaly.ID, anomalies.Describe(anomaly, user.Location()))
+		if anomaly.Expected {
+			line += " - expected"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", anomalyUsage)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Marks an anomaly as expected
+func expectAnomaly(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	id, err := strconv.ParseUint(args, 10, 64)
+	if err != nil {
+		return "Send /expected ID, where ID is the number from the alert or /anomalies", nil
+	}
+	anomaly, err := anomalies.Expect(ctx, chatter.deps.DBC, message.UserID, uint(id))
+	if err != nil {
+		return "", err
+	}
+	if anomaly == nil {
+		return fmt.Sprintf("There is no alert %d. Send /anomalies to list them.", id), nil
+	}
+	return fmt.Sprintf("Got it, alert %d is fine. I won't alert about similar spending", id), nil
+}
@@ -0,0 +1,89 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const budgetUsage = `Send /budget Food = 300 to limit spending on Food to 300 in your base currency per month,
+/budget Food > Restaurants = 50 USD week to set currency and period: week, month or year, /budget delete Food to remove the budget.
+I'll tell you when you spend 80% and 100% of a budget`
+
+// Shows budgets of the user with spending in their current periods, or sets or deletes a budget
+func editBudget(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	if args == "" {
+		return listBudgets(ctx, chatter, user)
+	}
+
+	if action, name, _ := strings.Cut(args, " "); strings.ToLower(action) == "delete" {
+		category, reply, err := chatter.findCategory(ctx, user.ID, name)
+		if category == nil {
+			return reply, err
+		}
+		deleted, err := budgets.Delete(ctx, chatter.deps.DBC, user.ID, category.ID)
+		if err != nil {
+			return "", err
+		}
+		if !deleted {
+			return fmt.Sprintf("%s has no budget", categories.Path(*category)), nil
+		}
+		return fmt.Sprintf("Budget of %s deleted", categories.Path(*category)), nil
+	}
+
+	name, limit, ok := strings.Cut(args, "=")
+	fields := strings.Fields(limit)
+	if !ok || len(fields) == 0 || len(fields) > 3 {
+		return budgetUsage, nil
+	}
+	amount, err := decimal.NewFromString(fields[0])
+	if err != nil || !amount.IsPositive() {
+		return fmt.Sprintf("%q isn't an amount. %s", fields[0], budgetUsage), nil
+	}
+	period, code := db.BudgetPeriodMonth, user.EffectiveBaseCurrency()
+	for _, field := range fields[1:] {
+		if p, ok := budgets.ParsePeriod(field); ok {
+			period = p
+		} else if currencyCode.MatchString(strings.ToUpper(field)) {
+			code = strings.ToUpper(field)
+		} else {
+			return fmt.Sprintf("%q is neither a currency nor a period. %s", field, budgetUsage), nil
+		}
+	}
+	category, reply, err := chatter.findCategory(ctx, user.ID, name)
+	if category == nil {
+		return reply, err
+	}
+
+	if _, err := budgets.Set(ctx, chatter.deps.DBC, user.ID, *category, period, amount.Round(2), code); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Budget of %s set to %s %s per %s", categories.Path(*category), amount.StringFixed(2), code, period), nil
+}
+
+func listBudgets(ctx context.Context, chatter *Chatter, user db.User) (string, error) {
+	statuses, err := budgets.Statuses(ctx, chatter.deps.DBC, user, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if len(statuses) == 0 {
+		return "You have no budgets yet.\n\n" + budgetUsage, nil
+	}
+	lines := []string{"Your budgets:"}
+	for _, status := range statuses {
+		lines = append(lines, budgets.Describe(status))
+	}
+	lines = append(lines, "", budgetUsage)
+	return strings.Join(lines, "\n"), nil
+}
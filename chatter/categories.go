@@ -0,0 +1,103 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+)
+
+const categoryUsage = `Send /category add Food > Snacks to add Snacks to Food, or /category add Pets for a new top category,
+/category rename Food > Snacks = Treats to rename, /category merge Food > Snacks = Food > Groceries to move everything from Snacks to Groceries`
+
+// Lists categories of the user as a tree
+func listCategories(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	userCategories, err := categories.ForUser(ctx, chatter.deps.DBC, message.UserID)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{"Your categories:"}
+	for _, category := range userCategories {
+		if category.ParentID == nil {
+			lines = append(lines, category.Title)
+		} else {
+			lines = append(lines, "  "+category.Title)
+		}
+	}
+	lines = append(lines, "", categoryUsage)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Adds, renames or merges categories of the user
+func editCategory(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	action, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return categoryUsage, nil
+	}
+
+	switch strings.ToLower(action) {
+	case "add":
+		category, err := categories.Add(ctx, chatter.deps.DBC, message.UserID, value)
+		if err != nil {
+			return replyToInputError(err)
+		}
+		return "Category added: " + categories.Path(*category), nil
+	case "rename":
+		name, title, ok := strings.Cut(value, "=")
+		if !ok {
+			return categoryUsage, nil
+		}
+		category, reply, err := chatter.findCategory(ctx, message.UserID, name)
+		if category == nil {
+			return reply, err
+		}
+		if err := categories.Rename(ctx, chatter.deps.DBC, *category, title); err != nil {
+			return replyToInputError(err)
+		}
+		return fmt.Sprintf("%s renamed to %s", categories.Path(*category), strings.TrimSpace(title)), nil
+	case "merge":
+		fromName, intoName, ok := strings.Cut(value, "=")
+		if !ok {
+			return categoryUsage, nil
+		}
+		from, reply, err := chatter.findCategory(ctx, message.UserID, fromName)
+		if from == nil {
+			return reply, err
+		}
+		into, reply, err := chatter.findCategory(ctx, message.UserID, intoName)
+		if into == nil {
+			return reply, err
+		}
+		if err := categories.Merge(ctx, chatter.deps.DBC, *from, *into); err != nil {
+			return replyToInputError(err)
+		}
+		return fmt.Sprintf("%s merged into %s", categories.Path(*from), categories.Path(*into)), nil
+	default:
+		return categoryUsage, nil
+	}
+}
+
+// Finds a category of the user by path or title. Returns a reply to the user, when there is no such category
+func (chatter *Chatter) findCategory(ctx context.Context, userID uint, name string) (*db.Category, string, error) {
+	category, err := categories.Find(ctx, chatter.deps.DBC, userID, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if category == nil {
+		return nil, fmt.Sprintf("I don't know category %q. Send /categories to list them.", strings.TrimSpace(name)), nil
+	}
+	return category, "", nil
+}
+
+// Explains mistakes of the user, other errors are failures
+func replyToInputError(err error) (string, error) {
+	var inputErr categories.InputError
+	if errors.As(err, &inputErr) {
+		return inputErr.Error(), nil
+	}
+	return "", err
+}
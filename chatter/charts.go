@@ -0,0 +1,35 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/charts"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/pkg/errors"
+)
+
+const chartUsage = `Send /chart pie, /chart bar or /chart line, optionally with a category, e.g. /chart bar Food > Groceries.
+pie shows spending by category this month, bar per month over the last %d months, line per week over the last %d weeks`
+
+// Shows a chart of spending
+func showChart(ctx context.Context, chatter *Chatter, message db.Message, args string) (reply.Chunk, error) {
+	kind, category, _ := strings.Cut(strings.TrimSpace(args), " ")
+	spec := charts.Spec{Category: strings.TrimSpace(category)}
+	var ok bool
+	if spec.Kind, ok = charts.ParseKind(kind); !ok {
+		return reply.Text(fmt.Sprintf(chartUsage, charts.DEFAULT_MONTHS, charts.DEFAULT_WEEKS)), nil
+	}
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return reply.Chunk{}, fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	chart, err := charts.Build(ctx, chatter.deps.DBC, user, spec, time.Now())
+	if err != nil {
+		return reply.Text("Failed to build the chart: " + err.Error()), nil
+	}
+	return reply.Chart(chart), nil
+}
@@ -2,35 +2,49 @@ package chatter
 
 import (
 	"context"
+	"sync"
 
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
 	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/messenger"
+	"github.com/EPecherkin/catty-counting/reply"
 )
 
 type Chatter struct {
-	messengerc messenger.Client
-	llmc       llm.Client
+	messengercs []messenger.Client
+	llmc        llm.Client
 
 	deps deps.Deps
 }
 
-func NewChatter(msgc messenger.Client, llmc llm.Client, deps deps.Deps) *Chatter {
+func NewChatter(msgcs []messenger.Client, llmc llm.Client, deps deps.Deps) *Chatter {
 	deps.Logger = deps.Logger.With(log.CALLER, "Chatter")
 	deps.Logger.Debug("Creating chatter")
-	return &Chatter{messengerc: msgc, llmc: llmc, deps: deps}
+	return &Chatter{messengercs: msgcs, llmc: llmc, deps: deps}
 }
 
 func (chatter *Chatter) Run(ctx context.Context) {
 	chatter.deps.Logger.Debug("Running chatter")
-	chatter.messengerc.OnMessage(chatter.handleMessage)
-	chatter.messengerc.Listen(ctx)
+	var wg sync.WaitGroup
+	for _, msgc := range chatter.messengercs {
+		msgc.OnMessage(chatter.handleMessage)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgc.Listen(ctx)
+		}()
+	}
+	wg.Wait()
 	chatter.deps.Logger.Info("Chatter finished")
 }
 
-func (chatter *Chatter) handleMessage(ctx context.Context, message db.Message, response chan<- string) {
+func (chatter *Chatter) handleMessage(ctx context.Context, message db.Message, response chan<- reply.Chunk) {
+	if name, args, ok := parseCommand(message.Text); ok && len(message.Files) == 0 {
+		chatter.handleCommand(ctx, message, name, args, response)
+		return
+	}
 	logger := chatter.deps.Logger.With(log.USER_ID, message.UserID, log.MESSAGE_ID, message.ID)
 	logger.Debug("llc handling message")
 	chatter.llmc.HandleMessage(ctx, message, response)
@@ -0,0 +1,97 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/EPecherkin/catty-counting/texts"
+)
+
+// A command from the user, like /token, handled without LLM
+type command struct {
+	description string
+	// Returns a response to the user
+	handle func(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error)
+	// Returns a response which may be a chart or files, used in place of handle
+	respond func(ctx context.Context, chatter *Chatter, message db.Message, args string) (reply.Chunk, error)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"/help":          {description: "list commands", handle: help},
+		"/token":         {description: "issue a new API token, the previous one stops working", handle: issueApiToken},
+		"/account":       {description: "set accounts of ledger, hledger and beancount exports, e.g. /account payment = Assets:Bank", handle: editAccount},
+		"/anomalies":     {description: "list unusual spending I noticed", handle: listAnomalies},
+		"/budget":        {description: "show or set budgets per category, e.g. /budget Food = 300", handle: editBudget},
+		"/categories":    {description: "list your categories", handle: listCategories},
+		"/category":      {description: "add, rename or merge categories, e.g. /category add Food > Snacks", handle: editCategory},
+		"/chart":         {description: "show a chart of your spending, e.g. /chart bar", respond: showChart},
+		"/currency":      {description: "show or set your base currency, e.g. /currency USD", handle: setBaseCurrency},
+		"/digest":        {description: "get weekly or monthly summaries of your spending, e.g. /digest weekly on", handle: editDigest},
+		"/expected":      {description: "mark an alert about unusual spending as fine, e.g. /expected 12", handle: expectAnomaly},
+		"/export":        {description: "get your receipts as spreadsheets, e.g. /export csv 2025-01-01 2025-03-31", respond: exportReceipts},
+		"/merchants":     {description: "list merchants of your receipts", handle: listMerchants},
+		"/merchant":      {description: "edit a merchant, e.g. /merchant 3 alias Rewe City", handle: editMerchant},
+		"/rules":         {description: "list rules which categorize your products", handle: listRules},
+		"/rule":          {description: "add or delete a rule, e.g. /rule add coffee = Food > Restaurants", handle: editRule},
+		"/subscriptions": {description: "list recurring expenses found in your receipts, like subscriptions", handle: listRecurring},
+		"/timezone":      {description: "show or set your timezone, e.g. /timezone Europe/Berlin", handle: setTimezone},
+	}
+}
+
+// Splits "/command@bot args" to "/command" and "args". Returns false if the text isn't a command
+func parseCommand(text string) (name string, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(text, " ")
+	name, _, _ = strings.Cut(name, "@")
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+func (chatter *Chatter) handleCommand(ctx context.Context, message db.Message, name string, args string, response chan<- reply.Chunk) {
+	logger := chatter.deps.Logger.With(log.USER_ID, message.UserID, log.MESSAGE_ID, message.ID).With("command", name)
+	cmd, ok := commands[name]
+	if !ok {
+		logger.Debug("unknown command")
+		response <- reply.Text(fmt.Sprintf("Unknown command %s. Send /help to list commands.", name))
+		return
+	}
+	logger.Debug("handling command")
+	chunk, err := cmd.run(ctx, chatter, message, args)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to handle command")
+		response <- reply.Text(texts.FAILED_TRY_AGAIN)
+		return
+	}
+	response <- chunk
+}
+
+func (cmd command) run(ctx context.Context, chatter *Chatter, message db.Message, args string) (reply.Chunk, error) {
+	if cmd.respond != nil {
+		return cmd.respond(ctx, chatter, message, args)
+	}
+	text, err := cmd.handle(ctx, chatter, message, args)
+	return reply.Text(text), err
+}
+
+func help(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"Send me receipts or ask about your spending. Commands:"}
+	for _, name := range names {
+		lines = append(lines, name+" - "+commands[name].description)
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,48 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/currency"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Shows or sets the base currency, which spendings are reported in. Existing receipts are converted to the new one
+func setBaseCurrency(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	if args == "" {
+		return fmt.Sprintf("Your base currency is %s. Send /currency CODE to change it, e.g. /currency USD", user.EffectiveBaseCurrency()), nil
+	}
+	code := strings.ToUpper(args)
+	if !currencyCode.MatchString(code) {
+		return fmt.Sprintf("%q doesn't look like a currency code. Use 3 letters, e.g. USD", args), nil
+	}
+	known, err := currency.Known(ctx, chatter.deps.DBC)
+	if err != nil {
+		return "", err
+	}
+	if !lo.Contains(known, code) {
+		return fmt.Sprintf("I have no exchange rates for %s, so receipts can't be converted to it. Known currencies: %s", code, strings.Join(known, ", ")), nil
+	}
+	if err := chatter.deps.DBC.WithContext(ctx).Model(&user).Update("base_currency", code).Error; err != nil {
+		return "", fmt.Errorf("saving base currency: %w", errors.WithStack(err))
+	}
+
+	logger := chatter.deps.Logger.With(log.USER_ID, user.ID)
+	converted, err := currency.ConvertUserReceipts(ctx, chatter.deps.DBC, user.ID, logger)
+	if err != nil {
+		return "", fmt.Errorf("converting receipts: %w", err)
+	}
+	return fmt.Sprintf("Base currency set to %s, %d receipts converted", code, converted), nil
+}
@@ -0,0 +1,96 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/digests"
+	"github.com/EPecherkin/catty-counting/scheduler"
+	"github.com/pkg/errors"
+)
+
+const digestUsage = `Send /digest weekly on or /digest monthly on to get a summary of your spending every Monday or 1st of the month at 9:00,
+/digest weekly 0 18 * * 0 to pick the time with a cron expression: minute, hour, day of month, month, day of week,
+/digest weekly off to stop it. /digest weekly now sends the digest right away.
+A digest covers the last week, from Monday, or month which ends by the end of its day`
+
+var digestKinds = map[string]db.ScheduleKind{
+	"weekly":  db.ScheduleKindWeeklyDigest,
+	"monthly": db.ScheduleKindMonthlyDigest,
+}
+
+// Shows, schedules or stops weekly and monthly digests of spending
+func editDigest(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	if args == "" {
+		return listDigests(ctx, chatter, user)
+	}
+
+	name, expression, _ := strings.Cut(args, " ")
+	kind, ok := digestKinds[strings.ToLower(name)]
+	if !ok {
+		return digestUsage, nil
+	}
+	expression = strings.TrimSpace(expression)
+	switch strings.ToLower(expression) {
+	case "off":
+		deleted, err := scheduler.Delete(ctx, chatter.deps.DBC, user.ID, kind)
+		if err != nil {
+			return "", err
+		}
+		if !deleted {
+			return fmt.Sprintf("You don't get the %s digest", name), nil
+		}
+		return fmt.Sprintf("The %s digest is off", name), nil
+	case "now":
+		period := db.BudgetPeriodWeek
+		if kind == db.ScheduleKindMonthlyDigest {
+			period = db.BudgetPeriodMonth
+		}
+		text, err := digests.Digest(ctx, chatter.deps.DBC, user, period, time.Now())
+		if err != nil {
+			return "", err
+		}
+		if text == "" {
+			from, to := digests.Period(period, time.Now(), user.Location())
+			return fmt.Sprintf("You spent nothing from %s to %s", from.Format(digests.DATE_FORMAT), to.Add(-time.Nanosecond).Format(digests.DATE_FORMAT)), nil
+		}
+		return text, nil
+	case "", "on":
+		expression = digests.DefaultCron[kind]
+	}
+
+	spec, err := scheduler.ParseSpec(expression)
+	if err != nil {
+		return fmt.Sprintf("%s. %s", err, digestUsage), nil
+	}
+	schedule, err := scheduler.Set(ctx, chatter.deps.DBC, user, kind, spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("The %s digest is on, the next one comes %s", name, schedule.NextRunAt.In(user.Location()).Format(time.DateTime)), nil
+}
+
+func listDigests(ctx context.Context, chatter *Chatter, user db.User) (string, error) {
+	schedules, err := scheduler.ForUser(ctx, chatter.deps.DBC, user.ID)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{}
+	for _, name := range []string{"weekly", "monthly"} {
+		schedule, ok := schedules[digestKinds[name]]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s: off", name))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s, the next one comes %s", name, schedule.Cron, schedule.NextRunAt.In(user.Location()).Format(time.DateTime)))
+	}
+	lines = append(lines, "", digestUsage)
+	return strings.Join(lines, "\n"), nil
+}
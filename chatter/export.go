@@ -0,0 +1,55 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/export"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/pkg/errors"
+)
+
+const exportUsage = `Send /export [csv|xlsx|ledger|hledger|beancount] [from] [to] [category], e.g. /export xlsx 2025-01-01 2025-03-31 Food > Groceries.
+Dates are YYYY-MM-DD, the current month by default. xlsx has sheets of receipts, products and totals per category, csv sends a file per sheet.
+ledger, hledger and beancount send a journal for plain-text accounting, set its accounts with /account`
+
+// Sends receipts of a period as spreadsheets or a journal
+func exportReceipts(ctx context.Context, chatter *Chatter, message db.Message, args string) (reply.Chunk, error) {
+	if strings.EqualFold(args, "help") {
+		return reply.Text(exportUsage), nil
+	}
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return reply.Chunk{}, fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	query := url.Values{}
+	words := strings.Fields(args)
+	if len(words) > 0 {
+		if _, ok := export.ParseFormat(words[0]); ok {
+			query.Set("format", words[0])
+			words = words[1:]
+		}
+	}
+	for _, bound := range []string{"from", "to"} {
+		if len(words) > 0 {
+			if _, err := time.Parse(time.DateOnly, words[0]); err == nil {
+				query.Set(bound, words[0])
+				words = words[1:]
+			}
+		}
+	}
+	query.Set("category", strings.Join(words, " "))
+	spec, err := export.ParseSpec(query, user.Location())
+	if err != nil {
+		return reply.Text(err.Error() + "\n\n" + exportUsage), nil
+	}
+	files, err := export.Files(ctx, chatter.deps.DBC, user, spec, time.Now())
+	if err != nil {
+		return reply.Text("Failed to export: " + err.Error()), nil
+	}
+	return reply.Files(files), nil
+}
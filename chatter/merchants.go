@@ -0,0 +1,102 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/merchants"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const merchantUsage = `Send /merchant ID alias NAME to match receipts with NAME to the merchant and merge merchants named NAME into it,
+/merchant ID rename NAME to change the name, /merchant ID category TITLE to categorize its products which I couldn't categorize myself`
+
+// Lists merchants of the user with their ids, aliases and default categories
+func listMerchants(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var userMerchants []db.Merchant
+	if err := chatter.deps.DBC.WithContext(ctx).Preload("Aliases").Preload("DefaultCategory.Parent").
+		Where("user_id = ?", message.UserID).Order("name asc").Find(&userMerchants).Error; err != nil {
+		return "", fmt.Errorf("fetching merchants: %w", errors.WithStack(err))
+	}
+	if len(userMerchants) == 0 {
+		return "You have no merchants yet. They appear as you send receipts.", nil
+	}
+	lines := []string{"Your merchants:"}
+	for _, merchant := range userMerchants {
+		line := fmt.Sprintf("%d. %s", merchant.ID, merchant.Name)
+		if len(merchant.Aliases) > 0 {
+			line += " (also " + strings.Join(lo.Map(merchant.Aliases, func(alias db.MerchantAlias, _ int) string { return alias.Alias }), ", ") + ")"
+		}
+		if merchant.DefaultCategory != nil {
+			line += " - " + categories.Path(*merchant.DefaultCategory)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", merchantUsage)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Edits a merchant of the user: adds an alias, renames it or sets its default category
+func editMerchant(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	fields := strings.SplitN(args, " ", 3)
+	if len(fields) < 3 {
+		return merchantUsage, nil
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return merchantUsage, nil
+	}
+	action, value := strings.ToLower(fields[1]), strings.TrimSpace(fields[2])
+
+	dbc := chatter.deps.DBC.WithContext(ctx)
+	var merchant db.Merchant
+	if err := dbc.Where("user_id = ?", message.UserID).First(&merchant, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Sprintf("You have no merchant %d. Send /merchants to list them.", id), nil
+		}
+		return "", fmt.Errorf("finding merchant: %w", errors.WithStack(err))
+	}
+
+	switch action {
+	case "alias":
+		if merchants.Normalize(value) == "" {
+			return merchantUsage, nil
+		}
+		merged, err := merchants.AddAlias(ctx, chatter.deps.DBC, merchant, value)
+		if err != nil {
+			return "", fmt.Errorf("adding alias: %w", err)
+		}
+		if merged > 0 {
+			return fmt.Sprintf("%s is also %s now, %d merchants merged into it", merchant.Name, value, merged), nil
+		}
+		return fmt.Sprintf("%s is also %s now", merchant.Name, value), nil
+	case "rename":
+		if merchants.Normalize(value) == "" {
+			return merchantUsage, nil
+		}
+		if err := dbc.Model(&merchant).Updates(map[string]any{"name": value, "normalized_name": merchants.Normalize(value)}).Error; err != nil {
+			return "", fmt.Errorf("renaming merchant: %w", errors.WithStack(err))
+		}
+		return "Merchant renamed to " + value, nil
+	case "category":
+		category, err := categories.Find(ctx, chatter.deps.DBC, message.UserID, value)
+		if err != nil {
+			return "", fmt.Errorf("finding category: %w", err)
+		}
+		if category == nil {
+			return fmt.Sprintf("I don't know category %q. Send /categories to list them.", value), nil
+		}
+		if err := dbc.Model(&merchant).Update("default_category_id", category.ID).Error; err != nil {
+			return "", fmt.Errorf("setting default category: %w", errors.WithStack(err))
+		}
+		return fmt.Sprintf("Products of %s are %s by default now", merchant.Name, categories.Path(*category)), nil
+	default:
+		return merchantUsage, nil
+	}
+}
@@ -0,0 +1,32 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/recurring"
+	"github.com/pkg/errors"
+)
+
+// Lists recurring expenses of the user, like subscriptions
+func listRecurring(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	expenses, err := recurring.ForUser(ctx, chatter.deps.DBC, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(expenses) == 0 {
+		return "I haven't found recurring expenses in your receipts yet. A charge of the same merchant for about the same amount needs to repeat 3 times, or twice for yearly ones", nil
+	}
+	lines := []string{"Your recurring expenses:"}
+	for _, expense := range expenses {
+		lines = append(lines, recurring.Describe(expense, user.Location()))
+	}
+	lines = append(lines, "", "I'll tell you when a price goes up or a charge doesn't come")
+	return strings.Join(lines, "\n"), nil
+}
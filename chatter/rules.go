@@ -0,0 +1,84 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/rules"
+)
+
+const ruleUsage = `Send /rule add oat milk = Food > Groceries to put products with "oat" and "milk" in the title to Groceries, /rule delete ID to forget a rule.
+I learn rules myself when you tell me a product is in a wrong category`
+
+// Lists rules of the user, which categorize products instead of LLM
+func listRules(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	userRules, err := rules.ForUser(ctx, chatter.deps.DBC, message.UserID)
+	if err != nil {
+		return "", err
+	}
+	if len(userRules) == 0 {
+		return "You have no rules yet.\n\n" + ruleUsage, nil
+	}
+	lines := []string{"Your rules:"}
+	for _, rule := range userRules {
+		line := fmt.Sprintf("%d. %s", rule.ID, rule.Pattern)
+		if rule.Merchant != nil {
+			line += " at " + rule.Merchant.Name
+		}
+		if rule.Category != nil {
+			line += " - " + categories.Path(*rule.Category)
+		} else {
+			line += " - deleted category"
+		}
+		if rule.Learned {
+			line += ", learned"
+		}
+		line += fmt.Sprintf(", used %d times", rule.Hits)
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", ruleUsage)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Adds or deletes a rule of the user
+func editRule(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	action, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(action) {
+	case "add":
+		pattern, name, ok := strings.Cut(value, "=")
+		if !ok || catalog.Normalize(pattern) == "" {
+			return ruleUsage, nil
+		}
+		category, reply, err := chatter.findCategory(ctx, message.UserID, name)
+		if category == nil {
+			return reply, err
+		}
+		rule, err := rules.Add(ctx, chatter.deps.DBC, message.UserID, nil, pattern, category.ID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Products with %q go to %s from now on", rule.Pattern, categories.Path(*category)), nil
+	case "delete":
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return ruleUsage, nil
+		}
+		deleted, err := rules.Delete(ctx, chatter.deps.DBC, message.UserID, uint(id))
+		if err != nil {
+			return "", err
+		}
+		if !deleted {
+			return fmt.Sprintf("You have no rule %d. Send /rules to list them.", id), nil
+		}
+		return fmt.Sprintf("Rule %d deleted", id), nil
+	default:
+		return ruleUsage, nil
+	}
+}
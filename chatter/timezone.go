@@ -0,0 +1,32 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/scheduler"
+	"github.com/pkg/errors"
+)
+
+// Shows or sets the timezone used to read dates on receipts
+func setTimezone(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	var user db.User
+	if err := chatter.deps.DBC.WithContext(ctx).First(&user, message.UserID).Error; err != nil {
+		return "", fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	if args == "" {
+		return fmt.Sprintf("Your timezone is %s. Send /timezone Area/City to change it, e.g. /timezone Europe/Berlin", user.Location()), nil
+	}
+	if _, err := time.LoadLocation(args); err != nil {
+		return fmt.Sprintf("I don't know timezone %q. Use Area/City, e.g. Europe/Berlin", args), nil
+	}
+	if err := chatter.deps.DBC.WithContext(ctx).Model(&user).Update("timezone", args).Error; err != nil {
+		return "", fmt.Errorf("saving timezone: %w", errors.WithStack(err))
+	}
+	if err := scheduler.Reschedule(ctx, chatter.deps.DBC, user); err != nil {
+		return "", err
+	}
+	return "Timezone set to " + args, nil
+}
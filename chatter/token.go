@@ -0,0 +1,21 @@
+package chatter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+)
+
+// Issues a token for the API, e.g. for uploading files from scripts
+func issueApiToken(ctx context.Context, chatter *Chatter, message db.Message, args string) (string, error) {
+	token, hash, err := db.NewApiToken()
+	if err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	if err := chatter.deps.DBC.WithContext(ctx).Model(&db.User{}).Where("id = ?", message.UserID).Update("api_token_hash", hash).Error; err != nil {
+		return "", fmt.Errorf("saving token: %w", errors.WithStack(err))
+	}
+	return "Your API token, keep it secret:\n" + token + "\nUse it as \"Authorization: Bearer <token>\" header.", nil
+}
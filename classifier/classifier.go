@@ -0,0 +1,154 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// Fewer categorized products of a user tell too little to learn from
+	MIN_TRAINING_PRODUCTS = 10
+	// A product goes to the predicted category only if the classifier is at least that sure
+	MIN_CONFIDENCE = 0.6
+)
+
+// A category the product may belong to, with probability from 0 to 1
+type Prediction struct {
+	CategoryID uint
+	Confidence float64
+}
+
+// Naive Bayes over words of product titles, learned from the products a user has categorized.
+// Counts products and words of each category, and how often each word occurs in it
+type Classifier struct {
+	products         int
+	vocabulary       map[string]bool
+	categoryIDs      []uint
+	categoryProducts map[uint]int
+	categoryWords    map[uint]int
+	wordCounts       map[uint]map[string]int
+}
+
+type sample struct {
+	Title      string
+	CategoryID uint
+}
+
+// Learns from categorized products of the user, except those categorized by the classifier itself.
+// Returns nil if the user has too few categorized products
+func Train(ctx context.Context, dbc *gorm.DB, userID uint) (*Classifier, error) {
+	var samples []sample
+	if err := dbc.WithContext(ctx).Table("product_categories").
+		Select("products.title, product_categories.category_id").
+		Joins("JOIN products ON products.id = product_categories.product_id AND products.deleted_at IS NULL").
+		Joins("JOIN receipts ON receipts.id = products.receipt_id AND receipts.deleted_at IS NULL").
+		Joins("JOIN categories ON categories.id = product_categories.category_id AND categories.deleted_at IS NULL").
+		Where("receipts.user_id = ? AND categories.user_id = ?", userID, userID).
+		Where("product_categories.deleted_at IS NULL AND product_categories.source <> ?", db.ProductCategorySourceClassifier).
+		Scan(&samples).Error; err != nil {
+		return nil, fmt.Errorf("fetching categorized products: %w", errors.WithStack(err))
+	}
+	return train(samples), nil
+}
+
+func train(samples []sample) *Classifier {
+	c := &Classifier{
+		vocabulary:       map[string]bool{},
+		categoryProducts: map[uint]int{},
+		categoryWords:    map[uint]int{},
+		wordCounts:       map[uint]map[string]int{},
+	}
+	for _, s := range samples {
+		words := catalog.Words(s.Title)
+		if len(words) == 0 {
+			continue
+		}
+		if c.wordCounts[s.CategoryID] == nil {
+			c.wordCounts[s.CategoryID] = map[string]int{}
+			c.categoryIDs = append(c.categoryIDs, s.CategoryID)
+		}
+		c.products++
+		c.categoryProducts[s.CategoryID]++
+		for _, word := range words {
+			c.vocabulary[word] = true
+			c.wordCounts[s.CategoryID][word]++
+			c.categoryWords[s.CategoryID]++
+		}
+	}
+	if c.products < MIN_TRAINING_PRODUCTS || len(c.categoryIDs) < 2 {
+		return nil
+	}
+	return c
+}
+
+// Categories for the product title, most probable first. Returns nothing when the title has no words the classifier has seen,
+// or the classifier is nil for lack of training
+func (c *Classifier) Predict(title string) []Prediction {
+	if c == nil {
+		return nil
+	}
+	var words []string
+	for _, word := range catalog.Words(title) {
+		if c.vocabulary[word] {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	logs := make([]float64, len(c.categoryIDs))
+	maxLog := math.Inf(-1)
+	for i, categoryID := range c.categoryIDs {
+		logs[i] = math.Log(float64(c.categoryProducts[categoryID]) / float64(c.products))
+		// Laplace smoothing, so a word unseen in the category doesn't rule it out
+		denominator := float64(c.categoryWords[categoryID] + len(c.vocabulary))
+		for _, word := range words {
+			logs[i] += math.Log(float64(c.wordCounts[categoryID][word]+1) / denominator)
+		}
+		maxLog = math.Max(maxLog, logs[i])
+	}
+
+	sum := 0.0
+	for i := range logs {
+		logs[i] = math.Exp(logs[i] - maxLog)
+		sum += logs[i]
+	}
+	predictions := make([]Prediction, len(c.categoryIDs))
+	for i, categoryID := range c.categoryIDs {
+		predictions[i] = Prediction{CategoryID: categoryID, Confidence: logs[i] / sum}
+	}
+	sort.Slice(predictions, func(i, j int) bool { return predictions[i].Confidence > predictions[j].Confidence })
+	return predictions
+}
+
+// Probability of the category for the product title. Returns nil when the classifier has no opinion
+func (c *Classifier) Confidence(title string, categoryID uint) *float64 {
+	predictions := c.Predict(title)
+	if len(predictions) == 0 {
+		return nil
+	}
+	confidence := 0.0
+	for _, prediction := range predictions {
+		if prediction.CategoryID == categoryID {
+			confidence = prediction.Confidence
+		}
+	}
+	return &confidence
+}
+
+// The most probable category for the product title, if the classifier is sure enough
+func (c *Classifier) Best(title string) (Prediction, bool) {
+	predictions := c.Predict(title)
+	if len(predictions) == 0 || predictions[0].Confidence < MIN_CONFIDENCE {
+		return Prediction{}, false
+	}
+	return predictions[0], true
+}
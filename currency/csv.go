@@ -0,0 +1,53 @@
+package currency
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Reads "date,currency,rate" lines, where rate is the amount of currency for 1 EUR. A header line is skipped
+type csvFetcher struct {
+	source string
+}
+
+func (fetcher *csvFetcher) Fetch(ctx context.Context) ([]Rate, error) {
+	r, err := open(ctx, fetcher.source)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", fetcher.source, err)
+	}
+	defer r.Close()
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	var rates []Rate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading csv: %w", errors.WithStack(err))
+		}
+		date, err := time.Parse(time.DateOnly, record[0])
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("parsing date on line %d: %w", line, errors.WithStack(err))
+		}
+		rate, err := decimal.NewFromString(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("parsing rate on line %d: %w", line, errors.WithStack(err))
+		}
+		rates = append(rates, Rate{Date: date, Currency: strings.ToUpper(record[1]), Rate: rate})
+	}
+}
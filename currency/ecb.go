@@ -0,0 +1,55 @@
+package currency
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Reads ECB reference rates, e.g. https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml
+type ecbFetcher struct {
+	source string
+}
+
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+func (fetcher *ecbFetcher) Fetch(ctx context.Context) ([]Rate, error) {
+	r, err := open(ctx, fetcher.source)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", fetcher.source, err)
+	}
+	defer r.Close()
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("decoding ECB xml: %w", errors.WithStack(err))
+	}
+
+	var rates []Rate
+	for _, day := range envelope.Days {
+		date, err := time.Parse(time.DateOnly, day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("parsing date %q: %w", day.Time, errors.WithStack(err))
+		}
+		for _, r := range day.Rates {
+			rate, err := decimal.NewFromString(r.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("parsing rate %q: %w", r.Rate, errors.WithStack(err))
+			}
+			rates = append(rates, Rate{Date: date, Currency: r.Currency, Rate: rate})
+		}
+	}
+	return rates, nil
+}
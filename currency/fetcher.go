@@ -0,0 +1,61 @@
+package currency
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Rate is the amount of Currency for 1 EUR on Date
+type Rate struct {
+	Date     time.Time
+	Currency string
+	Rate     decimal.Decimal
+}
+
+// Fetcher provides exchange rates from some source
+type Fetcher interface {
+	Fetch(ctx context.Context) ([]Rate, error)
+}
+
+// Picks a fetcher by the source: ECB XML or CSV, a local file or an http(s) URL
+func NewFetcher(source string) (Fetcher, error) {
+	switch {
+	case strings.HasSuffix(strings.ToLower(source), ".xml"):
+		return &ecbFetcher{source: source}, nil
+	case strings.HasSuffix(strings.ToLower(source), ".csv"):
+		return &csvFetcher{source: source}, nil
+	default:
+		return nil, fmt.Errorf("unsupported exchange rates source %q, expected .xml or .csv", source)
+	}
+}
+
+func open(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return f, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,159 @@
+package currency
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Rates are quoted against EUR, as ECB publishes them
+	QUOTE_CURRENCY = "EUR"
+	BATCH_SIZE     = 500
+)
+
+var ErrNoRate = errors.New("no exchange rate")
+
+// Saves rates, replacing existing ones for the same date and currency
+func Store(ctx context.Context, dbc *gorm.DB, rates []Rate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	rows := make([]db.ExchangeRate, 0, len(rates))
+	for _, r := range rates {
+		rows = append(rows, db.ExchangeRate{Date: day(r.Date), Currency: r.Currency, Rate: r.Rate})
+	}
+	err := dbc.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).CreateInBatches(&rows, BATCH_SIZE).Error
+	if err != nil {
+		return fmt.Errorf("saving exchange rates: %w", errors.WithStack(err))
+	}
+	return nil
+}
+
+// Currencies with exchange rates, sorted, including QUOTE_CURRENCY
+func Known(ctx context.Context, dbc *gorm.DB) ([]string, error) {
+	var currencies []string
+	if err := dbc.WithContext(ctx).Model(&db.ExchangeRate{}).Distinct("currency").Pluck("currency", &currencies).Error; err != nil {
+		return nil, fmt.Errorf("fetching currencies: %w", errors.WithStack(err))
+	}
+	if !lo.Contains(currencies, QUOTE_CURRENCY) {
+		currencies = append(currencies, QUOTE_CURRENCY)
+	}
+	sort.Strings(currencies)
+	return currencies, nil
+}
+
+// Amount of the currency for 1 EUR on the date, or the latest known before it
+func rateOn(ctx context.Context, dbc *gorm.DB, currency string, date time.Time) (decimal.Decimal, error) {
+	if currency == QUOTE_CURRENCY {
+		return decimal.NewFromInt(1), nil
+	}
+	var rate db.ExchangeRate
+	if err := dbc.WithContext(ctx).Where("currency = ? AND date <= ?", currency, day(date)).Order("date desc").First(&rate).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return decimal.Zero, fmt.Errorf("%w for %s on %s", ErrNoRate, currency, date.Format(time.DateOnly))
+		}
+		return decimal.Zero, fmt.Errorf("fetching exchange rate: %w", errors.WithStack(err))
+	}
+	return rate.Rate, nil
+}
+
+// Multiplier to convert amounts in from currency to to currency on the date
+func factor(ctx context.Context, dbc *gorm.DB, from string, to string, date time.Time) (decimal.Decimal, error) {
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+	fromRate, err := rateOn(ctx, dbc, from, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toRate, err := rateOn(ctx, dbc, to, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return toRate.DivRound(fromRate, 16), nil
+}
+
+func Convert(ctx context.Context, dbc *gorm.DB, amount decimal.Decimal, from string, to string, date time.Time) (decimal.Decimal, error) {
+	f, err := factor(ctx, dbc, from, to, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return amount.Mul(f).Round(2), nil
+}
+
+// Converts totals of the receipt and its products to the base currency and saves them.
+// A receipt without currency is considered to be in the base currency
+func ConvertReceipt(ctx context.Context, dbc *gorm.DB, receipt *db.Receipt, baseCurrency string) error {
+	from := receipt.Currency
+	if from == "" {
+		from = baseCurrency
+	}
+	f, err := factor(ctx, dbc, from, baseCurrency, receipt.OccuredAt)
+	if err != nil {
+		return err
+	}
+
+	return dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		receipt.BaseCurrency = baseCurrency
+		receipt.BaseTotalWithTax = receipt.TotalWithTax.Mul(f).Round(2)
+		if err := tx.Model(receipt).Select("base_currency", "base_total_with_tax").Updates(receipt).Error; err != nil {
+			return fmt.Errorf("saving receipt base total: %w", errors.WithStack(err))
+		}
+		for i := range receipt.Products {
+			product := &receipt.Products[i]
+			product.BaseTotalWithTax = product.TotalWithTax.Mul(f).Round(2)
+			if err := tx.Model(product).Select("base_total_with_tax").Updates(product).Error; err != nil {
+				return fmt.Errorf("saving product base total: %w", errors.WithStack(err))
+			}
+		}
+		return nil
+	})
+}
+
+// Converts receipts, which weren't converted yet, e.g. because rates were missing. Returns the number of converted receipts
+func ConvertPending(ctx context.Context, dbc *gorm.DB, logger *slog.Logger) (int, error) {
+	return convert(ctx, dbc, func(tx *gorm.DB) *gorm.DB { return tx.Where("base_currency IS NULL OR base_currency = ''") }, logger)
+}
+
+// Converts all receipts of the user, e.g. after the user changed base currency. Returns the number of converted receipts
+func ConvertUserReceipts(ctx context.Context, dbc *gorm.DB, userID uint, logger *slog.Logger) (int, error) {
+	return convert(ctx, dbc, func(tx *gorm.DB) *gorm.DB { return tx.Where("user_id = ?", userID) }, logger)
+}
+
+func convert(ctx context.Context, dbc *gorm.DB, scope func(*gorm.DB) *gorm.DB, logger *slog.Logger) (int, error) {
+	var receipts []db.Receipt
+	if err := dbc.WithContext(ctx).Scopes(scope).Preload("User").Preload("Products").Find(&receipts).Error; err != nil {
+		return 0, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	converted := 0
+	for _, receipt := range receipts {
+		if receipt.User == nil {
+			continue
+		}
+		if err := ConvertReceipt(ctx, dbc, &receipt, receipt.User.EffectiveBaseCurrency()); err != nil {
+			logger.With(log.RECEIPT_ID, receipt.ID).With(log.ERROR, err).Warn("failed to convert receipt")
+			continue
+		}
+		converted++
+	}
+	return converted, nil
+}
+
+func day(t time.Time) time.Time {
+	year, month, d := t.UTC().Date()
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
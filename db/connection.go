@@ -4,18 +4,32 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/EPecherkin/catty-counting/config"
-	// "gorm.io/driver/postgres"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
 )
 
+// Connects to the database and ensures it's migrated
 func NewConnection() (*gorm.DB, error) {
+	db, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrated(db); err != nil {
+		return nil, fmt.Errorf("checking migrations: %w", err)
+	}
+	return db, nil
+}
+
+// Connects to the database without checking migrations
+func Open() (*gorm.DB, error) {
 	// TODO: need JSON format
 	logLevel := gormlogger.Warn
 	if config.LogDebug() {
@@ -32,33 +46,53 @@ func NewConnection() (*gorm.DB, error) {
 		},
 	)
 
-	// dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-	// 	os.Getenv("DB_HOSTNAME"),
-	// 	os.Getenv("DB_USERNAME"),
-	// 	os.Getenv("DB_PASSWORD"),
-	// 	os.Getenv("DB_NAME"),
-	// 	os.Getenv("DB_PORT"),
-	// )
-	// db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger})
-
-	db, err := gorm.Open(sqlite.Open("tmp/dev.sqlite3"), &gorm.Config{Logger: logger})
+	dialector, err := dialectorFor(config.DatabaseURL())
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger})
 	if err != nil {
 		return nil, fmt.Errorf("connecting to database: %w", errors.WithStack(err))
 	}
 
-	if err := db.AutoMigrate(&User{}, &Chat{}, &Message{}, &File{}, &ExposedFile{}, &Receipt{}, &Product{}, &Category{}, &ProductCategory{}); err != nil {
-		return nil, fmt.Errorf("auto-migrating database: %w", errors.WithStack(err))
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("getting database pool: %w", errors.WithStack(err))
 	}
+	sqlDB.SetMaxOpenConns(config.DBMaxOpenConns())
+	sqlDB.SetMaxIdleConns(config.DBMaxIdleConns())
+	sqlDB.SetConnMaxLifetime(config.DBConnMaxLifetime())
 
-	if err := seed(db); err != nil {
-		return nil, fmt.Errorf("seeding database: %w", errors.WithStack(err))
+	return db, nil
+}
+
+// Fills columns added after the data was created
+func backfill(db *gorm.DB) error {
+	if err := db.Exec(`UPDATE receipts SET source = ? WHERE (source IS NULL OR source = '') AND file_id IS NOT NULL`, ReceiptSourceFile).Error; err != nil {
+		return fmt.Errorf("backfilling receipts source: %w", errors.WithStack(err))
 	}
+	if err := db.Exec(`UPDATE receipts SET user_id = (
+		SELECT messages.user_id FROM files JOIN messages ON messages.id = files.message_id WHERE files.id = receipts.file_id
+	) WHERE (user_id IS NULL OR user_id = 0) AND file_id IS NOT NULL`).Error; err != nil {
+		return fmt.Errorf("backfilling receipts user: %w", errors.WithStack(err))
+	}
+	return nil
+}
 
-	return db, nil
+// Picks the driver by the scheme of DATABASE_URL
+func dialectorFor(databaseURL string) (gorm.Dialector, error) {
+	switch {
+	case strings.HasPrefix(databaseURL, "postgres://"), strings.HasPrefix(databaseURL, "postgresql://"):
+		return postgres.Open(databaseURL), nil
+	case strings.HasPrefix(databaseURL, "sqlite://"):
+		return sqlite.Open(strings.TrimPrefix(databaseURL, "sqlite://")), nil
+	default:
+		return nil, errors.New("unsupported DATABASE_URL, expected postgres:// or sqlite://")
+	}
 }
 
 func seed(db *gorm.DB) error {
-	categories := []Category{
+	categories := []m1Category{
 		{Title: "Housing", Details: "Mortgage or rent;Property taxes;Household repairs;HOA fees"},
 		{Title: "Transportation", Details: "Car payment;Car warranty;Gas;Tires;Maintenance and oil changes;Parking fees;Repairs;Registration and DMV Fees"},
 		{Title: "Food", Details: "Groceries;Restaurants;Pet food"},
@@ -76,11 +110,8 @@ func seed(db *gorm.DB) error {
 		{Title: "Entertainment", Details: "Alcohol and/or bars;Games;Movies;Concerts;Vacations;Subscriptions (Netflix, Amazon, Hulu, etc.)"},
 	}
 
-	var existingCategories []Category
-	db.Find(&existingCategories)
-
 	for _, category := range categories {
-		if err := db.First(&Category{}, &Category{Title: category.Title}).Error; err == gorm.ErrRecordNotFound {
+		if err := db.First(&m1Category{}, &m1Category{Title: category.Title}).Error; err == gorm.ErrRecordNotFound {
 			if err := db.Create(&category).Error; err != nil {
 				return fmt.Errorf("seeding categories: %w", errors.WithStack(err))
 			}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Confidence of LLM in the values it parsed, from 0 to 1, by JSON names of the fields. Fields LLM was sure about are omitted
+type FieldConfidence map[string]float64
+
+func (c FieldConfidence) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	return marshalValue(c)
+}
+
+func (c *FieldConfidence) Scan(value any) error {
+	return unmarshalValue(value, c)
+}
+
+// Where a receipt or a product is in the file: the page, counting from 1, and the rectangle as fractions of the page size
+type Box struct {
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (b Box) Value() (driver.Value, error) {
+	if b == (Box{}) {
+		return nil, nil
+	}
+	return marshalValue(b)
+}
+
+func (b *Box) Scan(value any) error {
+	return unmarshalValue(value, b)
+}
+
+func marshalValue(value any) (driver.Value, error) {
+	marshaled, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(marshaled), nil
+}
+
+func unmarshalValue(value any, into any) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return errors.WithStack(json.Unmarshal([]byte(v), into))
+	case []byte:
+		return errors.WithStack(json.Unmarshal(v, into))
+	default:
+		return fmt.Errorf("can't scan %T as JSON", value)
+	}
+}
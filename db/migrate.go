@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// A versioned change of the schema or data. Migrations are applied in order of versions, each in a transaction
+type migration struct {
+	version uint
+	name    string
+	up      func(tx *gorm.DB) error
+	// nil if the migration can't be reverted
+	down func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+func sortedMigrations() []migration {
+	sorted := append([]migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].version < sorted[j].version })
+	return sorted
+}
+
+func appliedVersions(db *gorm.DB) (map[uint]bool, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("creating schema migrations table: %w", errors.WithStack(err))
+	}
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("fetching applied migrations: %w", errors.WithStack(err))
+	}
+	versions := make(map[uint]bool, len(applied))
+	for _, m := range applied {
+		versions[m.Version] = true
+	}
+	return versions, nil
+}
+
+// Applies all pending migrations. Returns applied ones
+func Migrate(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []MigrationStatus
+	for _, m := range sortedMigrations() {
+		if applied[m.version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("applying migration %d %s: %w", m.version, m.name, errors.WithStack(err))
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name, Applied: true})
+	}
+	return done, nil
+}
+
+// Reverts the last applied migrations. Returns reverted ones
+func Rollback(db *gorm.DB, steps int) ([]MigrationStatus, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	sorted := sortedMigrations()
+	var done []MigrationStatus
+	for i := len(sorted) - 1; i >= 0 && len(done) < steps; i-- {
+		m := sorted[i]
+		if !applied[m.version] {
+			continue
+		}
+		if m.down == nil {
+			return done, fmt.Errorf("migration %d %s can't be reverted", m.version, m.name)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting migration %d %s: %w", m.version, m.name, errors.WithStack(err))
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name, Applied: false})
+	}
+	return done, nil
+}
+
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, m := range sortedMigrations() {
+		statuses = append(statuses, MigrationStatus{Version: m.version, Name: m.name, Applied: applied[m.version]})
+	}
+	return statuses, nil
+}
+
+// Fails if any migration is pending, so the app doesn't run against an outdated schema
+func checkMigrated(db *gorm.DB) error {
+	statuses, err := Status(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			return fmt.Errorf("migration %d %s is pending, run `migrate` first", s.Version, s.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var models = []any{&User{}, &Chat{}, &Message{}, &File{}, &ExposedFile{}, &Receipt{}, &ReceiptTaxLine{}, &Product{}, &CatalogProduct{}, &Category{},
+	&ProductCategory{}, &CategoryRule{}, &Merchant{}, &MerchantAlias{}, &Budget{}, &Schedule{}, &RecurringExpense{}, &Anomaly{}, &LedgerAccount{}, &Job{}, &ExchangeRate{}}
+
+func TestMigrations(t *testing.T) {
+	dbc, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := dbc.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	for round := 1; round <= 2; round++ {
+		done, err := Migrate(dbc)
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(done) != len(migrations) {
+			t.Errorf("round %d: applied %d migrations, expected %d", round, len(done), len(migrations))
+		}
+		if err := checkMigrated(dbc); err != nil {
+			t.Errorf("round %d: %v", round, err)
+		}
+		// the schema the migrations build should have every column of the models
+		for _, model := range models {
+			stmt := &gorm.Statement{DB: dbc}
+			if err := stmt.Parse(model); err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range stmt.Schema.Fields {
+				if field.DBName != "" && !dbc.Migrator().HasColumn(model, field.DBName) {
+					t.Errorf("round %d: %s.%s is missing", round, stmt.Schema.Table, field.DBName)
+				}
+			}
+		}
+
+		done, err = Rollback(dbc, len(migrations))
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(done) != len(migrations) {
+			t.Errorf("round %d: reverted %d migrations, expected %d", round, len(done), len(migrations))
+		}
+		if err := checkMigrated(dbc); err == nil {
+			t.Errorf("round %d: expected pending migrations after the rollback", round)
+		}
+		for _, model := range models {
+			if dbc.Migrator().HasTable(model) {
+				t.Errorf("round %d: %T is left after the rollback", round, model)
+			}
+		}
+	}
+}
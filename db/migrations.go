@@ -0,0 +1,675 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Append new migrations to the end. Migrations describe tables with their own structs, as models change over time
+var migrations = []migration{
+	{version: 1, name: "initial schema", up: initialSchemaUp, down: initialSchemaDown},
+	{version: 2, name: "backfill receipts user and source", up: backfill, down: noop},
+	{version: 3, name: "seed categories", up: seed, down: noop},
+	{version: 4, name: "add users timezone and messages parsed file", up: userTimezoneUp, down: userTimezoneDown},
+	{version: 5, name: "add exchange rates and base currency amounts", up: baseCurrencyUp, down: baseCurrencyDown},
+	{version: 6, name: "add files content hash and receipts duplicate of", up: duplicatesUp, down: duplicatesDown},
+	{version: 7, name: "add merchants", up: merchantsUp, down: merchantsDown},
+	{version: 8, name: "add product catalog and unit prices", up: catalogUp, down: catalogDown},
+	{version: 9, name: "add products line type, unit and discount", up: productLinesUp, down: productLinesDown},
+	{version: 10, name: "add receipt tax lines and products tax rate", up: taxLinesUp, down: taxLinesDown},
+	{version: 11, name: "add categories user and parent", up: userCategoriesUp, down: userCategoriesDown},
+	{version: 12, name: "add category rules", up: categoryRulesUp, down: categoryRulesDown},
+	{version: 13, name: "add product categories source and confidence", up: productCategoriesConfidenceUp, down: productCategoriesConfidenceDown},
+	{version: 14, name: "add receipts and products field confidence", up: fieldConfidenceUp, down: fieldConfidenceDown},
+	{version: 15, name: "add budgets", up: budgetsUp, down: budgetsDown},
+	{version: 16, name: "add schedules", up: schedulesUp, down: schedulesDown},
+	{version: 17, name: "add recurring expenses", up: recurringExpensesUp, down: recurringExpensesDown},
+	{version: 18, name: "add anomalies", up: anomaliesUp, down: anomaliesDown},
+	{version: 19, name: "add ledger accounts", up: ledgerAccountsUp, down: ledgerAccountsDown},
+	{version: 20, name: "add receipts unsure", up: receiptsUnsureUp, down: receiptsUnsureDown},
+}
+
+// Down for data migrations, which leave the schema as is
+func noop(tx *gorm.DB) error {
+	return nil
+}
+
+// Drops the index of the field, if it's still there. sqlite recreates a table to drop a column and loses its other indexes
+func dropIndex(tx *gorm.DB, model any, field string) error {
+	if !tx.Migrator().HasIndex(model, field) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(model, field)
+}
+
+type m1User struct {
+	gorm.Model
+	TelegramID       *int64 `gorm:"uniqueIndex"`
+	TelegramUserName string
+	WebName          *string `gorm:"type:varchar(256);uniqueIndex"`
+	CliName          *string `gorm:"type:varchar(256);uniqueIndex"`
+	Email            *string `gorm:"type:varchar(320);uniqueIndex"`
+	ApiTokenHash     *string `gorm:"type:varchar(64);uniqueIndex"`
+}
+
+func (m1User) TableName() string { return "users" }
+
+type m1Chat struct {
+	gorm.Model
+	UserID     uint   `gorm:"index"`
+	Summary    string `gorm:"type:text"`
+	TelegramID int64
+}
+
+func (m1Chat) TableName() string { return "chats" }
+
+type m1Message struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	ChatID      uint   `gorm:"index"`
+	Text        string `gorm:"type:text"`
+	TelegramIDs []int  `gorm:"serializer:json"`
+	Direction   string `gorm:"type:varchar(16)"`
+}
+
+func (m1Message) TableName() string { return "messages" }
+
+type m1File struct {
+	gorm.Model
+	MessageID    uint   `gorm:"index"`
+	BlobKey      string `gorm:"type:varchar(128)"`
+	Size         int64
+	MimeType     string `gorm:"type:varchar(256)"`
+	OriginalName string `gorm:"type:varchar(1024)"`
+	Summary      string `gorm:"type:text"`
+	TelegramID   string
+}
+
+func (m1File) TableName() string { return "files" }
+
+type m1ExposedFile struct {
+	gorm.Model
+	FileID uint   `gorm:"index"`
+	Key    string `gorm:"type:varchar(256);uniqueIndex"`
+}
+
+func (m1ExposedFile) TableName() string { return "exposed_files" }
+
+type m1Receipt struct {
+	gorm.Model
+	UserID         uint            `gorm:"index"`
+	FileID         *uint           `gorm:"index"`
+	Source         string          `gorm:"type:varchar(16)"`
+	TotalBeforeTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Tax            decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TotalWithTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency       string          `gorm:"type:varchar(10)"`
+	Origin         string          `gorm:"type:text"`
+	Recipient      string          `gorm:"type:text"`
+	Details        string          `gorm:"type:text"`
+	Summary        string          `gorm:"type:text"`
+	OccuredAt      time.Time       `gorm:"type:timestamp"`
+}
+
+func (m1Receipt) TableName() string { return "receipts" }
+
+type m1Product struct {
+	gorm.Model
+	ReceiptID      uint            `gorm:"index"`
+	Title          string          `gorm:"type:text"`
+	Details        string          `gorm:"type:text"`
+	TotalBeforeTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Tax            decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TotalWithTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m1Product) TableName() string { return "products" }
+
+type m1Category struct {
+	gorm.Model
+	Title   string `gorm:"type:text"`
+	Details string `gorm:"type:text"`
+}
+
+func (m1Category) TableName() string { return "categories" }
+
+type m1ProductCategory struct {
+	gorm.Model
+	ProductID  uint `gorm:"index"`
+	CategoryID uint `gorm:"index"`
+}
+
+func (m1ProductCategory) TableName() string { return "product_categories" }
+
+type m1Job struct {
+	gorm.Model
+	Key       string `gorm:"type:varchar(64);uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	MessageID uint   `gorm:"index"`
+	Status    string `gorm:"type:varchar(16)"`
+	Result    string `gorm:"type:text"`
+	Error     string `gorm:"type:text"`
+}
+
+func (m1Job) TableName() string { return "jobs" }
+
+var m1Tables = []any{&m1User{}, &m1Chat{}, &m1Message{}, &m1File{}, &m1ExposedFile{}, &m1Receipt{}, &m1Product{}, &m1Category{}, &m1ProductCategory{}, &m1Job{}}
+
+// Creates missing tables and columns, so databases created with AutoMigrate are adopted as is
+func initialSchemaUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(m1Tables...)
+}
+
+func initialSchemaDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(m1Tables...)
+}
+
+type m4User struct {
+	Timezone string `gorm:"type:varchar(64)"`
+}
+
+func (m4User) TableName() string { return "users" }
+
+type m4Message struct {
+	ParsedFileID *uint `gorm:"index"`
+}
+
+func (m4Message) TableName() string { return "messages" }
+
+func userTimezoneUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m4User{}, &m4Message{})
+}
+
+func userTimezoneDown(tx *gorm.DB) error {
+	if err := dropIndex(tx, &m4Message{}, "ParsedFileID"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m4Message{}, "ParsedFileID"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropColumn(&m4User{}, "Timezone")
+}
+
+type m5User struct {
+	BaseCurrency string `gorm:"type:varchar(10)"`
+}
+
+func (m5User) TableName() string { return "users" }
+
+type m5Receipt struct {
+	BaseCurrency     string          `gorm:"type:varchar(10)"`
+	BaseTotalWithTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m5Receipt) TableName() string { return "receipts" }
+
+type m5Product struct {
+	BaseTotalWithTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m5Product) TableName() string { return "products" }
+
+type m5ExchangeRate struct {
+	gorm.Model
+	Date     time.Time       `gorm:"type:date;uniqueIndex:idx_exchange_rates_date_currency"`
+	Currency string          `gorm:"type:varchar(10);uniqueIndex:idx_exchange_rates_date_currency"`
+	Rate     decimal.Decimal `gorm:"type:decimal(20,8)"`
+}
+
+func (m5ExchangeRate) TableName() string { return "exchange_rates" }
+
+func baseCurrencyUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m5User{}, &m5Receipt{}, &m5Product{}, &m5ExchangeRate{})
+}
+
+func baseCurrencyDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&m5ExchangeRate{}); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m5Product{}, "BaseTotalWithTax"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m5Receipt{}, "BaseTotalWithTax"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m5Receipt{}, "BaseCurrency"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropColumn(&m5User{}, "BaseCurrency")
+}
+
+type m6File struct {
+	ContentHash string `gorm:"type:varchar(64);index"`
+}
+
+func (m6File) TableName() string { return "files" }
+
+type m6Receipt struct {
+	DuplicateOfID *uint `gorm:"index"`
+}
+
+func (m6Receipt) TableName() string { return "receipts" }
+
+func duplicatesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m6File{}, &m6Receipt{})
+}
+
+func duplicatesDown(tx *gorm.DB) error {
+	if err := dropIndex(tx, &m6Receipt{}, "DuplicateOfID"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m6Receipt{}, "DuplicateOfID"); err != nil {
+		return err
+	}
+	if err := dropIndex(tx, &m6File{}, "ContentHash"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropColumn(&m6File{}, "ContentHash")
+}
+
+type m7Merchant struct {
+	gorm.Model
+	UserID            uint   `gorm:"index"`
+	Name              string `gorm:"type:text"`
+	NormalizedName    string `gorm:"type:varchar(256);index"`
+	Address           string `gorm:"type:text"`
+	TaxID             string `gorm:"type:varchar(64);index"`
+	DefaultCategoryID *uint  `gorm:"index"`
+}
+
+func (m7Merchant) TableName() string { return "merchants" }
+
+type m7MerchantAlias struct {
+	gorm.Model
+	MerchantID      uint   `gorm:"index"`
+	Alias           string `gorm:"type:text"`
+	NormalizedAlias string `gorm:"type:varchar(256);index"`
+}
+
+func (m7MerchantAlias) TableName() string { return "merchant_aliases" }
+
+type m7Receipt struct {
+	MerchantID *uint `gorm:"index"`
+}
+
+func (m7Receipt) TableName() string { return "receipts" }
+
+func merchantsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m7Merchant{}, &m7MerchantAlias{}, &m7Receipt{})
+}
+
+func merchantsDown(tx *gorm.DB) error {
+	if err := dropIndex(tx, &m7Receipt{}, "MerchantID"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropColumn(&m7Receipt{}, "MerchantID"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(&m7MerchantAlias{}, &m7Merchant{})
+}
+
+type m8CatalogProduct struct {
+	gorm.Model
+	UserID         uint            `gorm:"index"`
+	Name           string          `gorm:"type:text"`
+	NormalizedName string          `gorm:"type:varchar(256);index"`
+	Unit           string          `gorm:"type:varchar(16)"`
+	Size           decimal.Decimal `gorm:"type:decimal(20,3)"`
+}
+
+func (m8CatalogProduct) TableName() string { return "catalog_products" }
+
+type m8Product struct {
+	CatalogProductID *uint           `gorm:"index"`
+	Quantity         decimal.Decimal `gorm:"type:decimal(20,3)"`
+	UnitPrice        decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m8Product) TableName() string { return "products" }
+
+// Existing products are single items
+func catalogUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&m8CatalogProduct{}, &m8Product{}); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE products SET quantity = 1, unit_price = total_with_tax WHERE quantity IS NULL OR quantity = 0").Error
+}
+
+func catalogDown(tx *gorm.DB) error {
+	if err := dropIndex(tx, &m8Product{}, "CatalogProductID"); err != nil {
+		return err
+	}
+	for _, column := range []string{"CatalogProductID", "Quantity", "UnitPrice"} {
+		if err := tx.Migrator().DropColumn(&m8Product{}, column); err != nil {
+			return err
+		}
+	}
+	return tx.Migrator().DropTable(&m8CatalogProduct{})
+}
+
+type m9Product struct {
+	LineType string          `gorm:"type:varchar(16)"`
+	Unit     string          `gorm:"type:varchar(16)"`
+	Discount decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m9Product) TableName() string { return "products" }
+
+// Existing products are items
+func productLinesUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&m9Product{}); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE products SET line_type = 'item' WHERE line_type IS NULL OR line_type = ''").Error
+}
+
+func productLinesDown(tx *gorm.DB) error {
+	for _, column := range []string{"LineType", "Unit", "Discount"} {
+		if err := tx.Migrator().DropColumn(&m9Product{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type m10ReceiptTaxLine struct {
+	gorm.Model
+	ReceiptID uint            `gorm:"index"`
+	Rate      decimal.Decimal `gorm:"type:decimal(5,2)"`
+	Base      decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Amount    decimal.Decimal `gorm:"type:decimal(20,2)"`
+}
+
+func (m10ReceiptTaxLine) TableName() string { return "receipt_tax_lines" }
+
+type m10Product struct {
+	TaxRate decimal.Decimal `gorm:"type:decimal(5,2)"`
+}
+
+func (m10Product) TableName() string { return "products" }
+
+func taxLinesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m10ReceiptTaxLine{}, &m10Product{})
+}
+
+func taxLinesDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropColumn(&m10Product{}, "TaxRate"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropTable(&m10ReceiptTaxLine{})
+}
+
+type m11Category struct {
+	UserID   *uint `gorm:"index"`
+	ParentID *uint `gorm:"index"`
+}
+
+func (m11Category) TableName() string { return "categories" }
+
+// Categories of users are copied from the templates on first use, see categories.EnsureTree
+func userCategoriesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m11Category{})
+}
+
+// Products and merchants are moved back to the templates of the same title, or of the parent for subcategories
+func userCategoriesDown(tx *gorm.DB) error {
+	template := `(SELECT t.id FROM categories t, categories c
+		WHERE c.id = %s AND t.user_id IS NULL AND t.parent_id IS NULL AND t.deleted_at IS NULL
+		AND t.title = COALESCE((SELECT p.title FROM categories p WHERE p.id = c.parent_id), c.title))`
+	userCategories := "(SELECT id FROM categories WHERE user_id IS NOT NULL)"
+	statements := []string{
+		"UPDATE product_categories SET category_id = " + fmt.Sprintf(template, "product_categories.category_id") + " WHERE category_id IN " + userCategories,
+		"DELETE FROM product_categories WHERE category_id IS NULL",
+		"UPDATE merchants SET default_category_id = " + fmt.Sprintf(template, "merchants.default_category_id") + " WHERE default_category_id IN " + userCategories,
+		"DELETE FROM categories WHERE user_id IS NOT NULL",
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"ParentID", "UserID"} {
+		if err := dropIndex(tx, &m11Category{}, column); err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropColumn(&m11Category{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type m12CategoryRule struct {
+	gorm.Model
+	UserID     uint   `gorm:"index"`
+	MerchantID *uint  `gorm:"index"`
+	Pattern    string `gorm:"type:varchar(256)"`
+	CategoryID uint   `gorm:"index"`
+	Learned    bool
+	Hits       int
+}
+
+func (m12CategoryRule) TableName() string { return "category_rules" }
+
+func categoryRulesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m12CategoryRule{})
+}
+
+func categoryRulesDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m12CategoryRule{})
+}
+
+type m13ProductCategory struct {
+	Source     string   `gorm:"type:varchar(16)"`
+	Confidence *float64 `gorm:"type:decimal(5,4)"`
+}
+
+func (m13ProductCategory) TableName() string { return "product_categories" }
+
+// Existing products were categorized by LLM
+func productCategoriesConfidenceUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&m13ProductCategory{}); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE product_categories SET source = 'llm' WHERE source IS NULL OR source = ''").Error
+}
+
+func productCategoriesConfidenceDown(tx *gorm.DB) error {
+	for _, column := range []string{"Confidence", "Source"} {
+		if err := tx.Migrator().DropColumn(&m13ProductCategory{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type m14Receipt struct {
+	FieldConfidence string `gorm:"type:text"`
+	Box             string `gorm:"type:text"`
+}
+
+func (m14Receipt) TableName() string { return "receipts" }
+
+type m14Product struct {
+	Line            int
+	FieldConfidence string `gorm:"type:text"`
+	Box             string `gorm:"type:text"`
+}
+
+func (m14Product) TableName() string { return "products" }
+
+func fieldConfidenceUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m14Receipt{}, &m14Product{})
+}
+
+func fieldConfidenceDown(tx *gorm.DB) error {
+	for _, column := range []string{"Box", "FieldConfidence", "Line"} {
+		if err := tx.Migrator().DropColumn(&m14Product{}, column); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"Box", "FieldConfidence"} {
+		if err := tx.Migrator().DropColumn(&m14Receipt{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type m15Budget struct {
+	gorm.Model
+	UserID         uint            `gorm:"index"`
+	CategoryID     uint            `gorm:"index"`
+	Period         string          `gorm:"type:varchar(16)"`
+	Amount         decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency       string          `gorm:"type:varchar(10)"`
+	AlertedFrom    *time.Time      `gorm:"type:timestamp"`
+	AlertedPercent int
+}
+
+func (m15Budget) TableName() string { return "budgets" }
+
+func budgetsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m15Budget{})
+}
+
+func budgetsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m15Budget{})
+}
+
+type m16Schedule struct {
+	gorm.Model
+	UserID    uint       `gorm:"index"`
+	Kind      string     `gorm:"type:varchar(32)"`
+	Cron      string     `gorm:"type:varchar(128)"`
+	NextRunAt time.Time  `gorm:"type:timestamp;index"`
+	LastRunAt *time.Time `gorm:"type:timestamp"`
+}
+
+func (m16Schedule) TableName() string { return "schedules" }
+
+func schedulesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m16Schedule{})
+}
+
+func schedulesDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m16Schedule{})
+}
+
+type m17RecurringExpense struct {
+	gorm.Model
+	UserID          uint            `gorm:"index"`
+	MerchantID      uint            `gorm:"index"`
+	Cadence         string          `gorm:"type:varchar(16)"`
+	Amount          decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency        string          `gorm:"type:varchar(10)"`
+	Occurrences     int
+	LastReceiptID   uint       `gorm:"index"`
+	LastChargedAt   time.Time  `gorm:"type:timestamp"`
+	NextExpectedAt  time.Time  `gorm:"type:timestamp;index"`
+	MissedAlertedAt *time.Time `gorm:"type:timestamp"`
+}
+
+func (m17RecurringExpense) TableName() string { return "recurring_expenses" }
+
+func recurringExpensesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m17RecurringExpense{})
+}
+
+func recurringExpensesDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m17RecurringExpense{})
+}
+
+type m18Anomaly struct {
+	gorm.Model
+	UserID     uint            `gorm:"index"`
+	Kind       string          `gorm:"type:varchar(32)"`
+	ReceiptID  *uint           `gorm:"index"`
+	MerchantID *uint           `gorm:"index"`
+	Category   string          `gorm:"type:text"`
+	PeriodFrom *time.Time      `gorm:"type:timestamp"`
+	Amount     decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Baseline   decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency   string          `gorm:"type:varchar(10)"`
+	Expected   bool
+}
+
+func (m18Anomaly) TableName() string { return "anomalies" }
+
+func anomaliesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m18Anomaly{})
+}
+
+func anomaliesDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m18Anomaly{})
+}
+
+type m19LedgerAccount struct {
+	gorm.Model
+	UserID     uint   `gorm:"index"`
+	Role       string `gorm:"type:varchar(16)"`
+	CategoryID *uint  `gorm:"index"`
+	Account    string `gorm:"type:varchar(256)"`
+}
+
+func (m19LedgerAccount) TableName() string { return "ledger_accounts" }
+
+func ledgerAccountsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&m19LedgerAccount{})
+}
+
+func ledgerAccountsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&m19LedgerAccount{})
+}
+
+type m20Receipt struct {
+	ID              uint
+	FieldConfidence string `gorm:"type:text"`
+	Unsure          bool   `gorm:"index"`
+}
+
+func (m20Receipt) TableName() string { return "receipts" }
+
+// Flags receipts LLM was unsure of the total, the date or the currency of, as llm.IsReceiptUnsure did when it was written
+func receiptsUnsureUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&m20Receipt{}); err != nil {
+		return err
+	}
+	if err := tx.Model(&m20Receipt{}).Where("unsure IS NULL").Update("unsure", false).Error; err != nil {
+		return err
+	}
+	var receipts []m20Receipt
+	if err := tx.Where("field_confidence IS NOT NULL AND field_confidence <> ''").Find(&receipts).Error; err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		var confidence map[string]float64
+		if err := json.Unmarshal([]byte(receipt.FieldConfidence), &confidence); err != nil {
+			return fmt.Errorf("reading field confidence of receipt %d: %w", receipt.ID, err)
+		}
+		unsure := false
+		for _, field := range []string{"total_with_tax", "occured_at", "currency"} {
+			if value, ok := confidence[field]; ok && value < 0.7 {
+				unsure = true
+			}
+		}
+		if !unsure {
+			continue
+		}
+		if err := tx.Model(&receipt).Update("unsure", true).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func receiptsUnsureDown(tx *gorm.DB) error {
+	if err := dropIndex(tx, &m20Receipt{}, "Unsure"); err != nil {
+		return err
+	}
+	return tx.Migrator().DropColumn(&m20Receipt{}, "Unsure")
+}
@@ -16,10 +16,94 @@ const (
 	MessageDirectionLlmToSystem MessageDirection = "llm-to-system"
 )
 
+type JobStatus string
+
+const (
+	JobStatusPending JobStatus = "pending"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type ProductLineType string
+
+const (
+	ProductLineTypeItem     ProductLineType = "item"
+	ProductLineTypeDiscount ProductLineType = "discount"
+	ProductLineTypeDeposit  ProductLineType = "deposit"
+	ProductLineTypeFee      ProductLineType = "fee"
+	ProductLineTypeTip      ProductLineType = "tip"
+)
+
+type ProductCategorySource string
+
+const (
+	ProductCategorySourceLlm        ProductCategorySource = "llm"
+	ProductCategorySourceMerchant   ProductCategorySource = "merchant"
+	ProductCategorySourceRule       ProductCategorySource = "rule"
+	ProductCategorySourceUser       ProductCategorySource = "user"
+	ProductCategorySourceClassifier ProductCategorySource = "classifier"
+)
+
+type BudgetPeriod string
+
+const (
+	BudgetPeriodWeek  BudgetPeriod = "week"
+	BudgetPeriodMonth BudgetPeriod = "month"
+	BudgetPeriodYear  BudgetPeriod = "year"
+)
+
+type ScheduleKind string
+
+const (
+	ScheduleKindWeeklyDigest   ScheduleKind = "weekly_digest"
+	ScheduleKindMonthlyDigest  ScheduleKind = "monthly_digest"
+	ScheduleKindRecurringCheck ScheduleKind = "recurring_check"
+	ScheduleKindAnomalyCheck   ScheduleKind = "anomaly_check"
+)
+
+type RecurringCadence string
+
+const (
+	RecurringCadenceWeekly    RecurringCadence = "weekly"
+	RecurringCadenceMonthly   RecurringCadence = "monthly"
+	RecurringCadenceQuarterly RecurringCadence = "quarterly"
+	RecurringCadenceYearly    RecurringCadence = "yearly"
+)
+
+type AnomalyKind string
+
+const (
+	AnomalyKindMerchantAmount  AnomalyKind = "merchant_amount"
+	AnomalyKindCategoryAmount  AnomalyKind = "category_amount"
+	AnomalyKindCategorySpike   AnomalyKind = "category_spike"
+	AnomalyKindForeignCurrency AnomalyKind = "foreign_currency"
+)
+
+type LedgerAccountRole string
+
+const (
+	LedgerAccountRoleCategory LedgerAccountRole = "category"
+	LedgerAccountRolePayment  LedgerAccountRole = "payment"
+	LedgerAccountRoleTax      LedgerAccountRole = "tax"
+)
+
+type ReceiptSource string
+
+const (
+	ReceiptSourceFile   ReceiptSource = "file"
+	ReceiptSourceManual ReceiptSource = "manual"
+)
+
 type User struct {
 	gorm.Model
-	TelegramID       int64 `gorm:"uniqueIndex"`
+	TelegramID       *int64 `gorm:"uniqueIndex"`
 	TelegramUserName string
+	WebName          *string `gorm:"type:varchar(256);uniqueIndex"`
+	CliName          *string `gorm:"type:varchar(256);uniqueIndex"`
+	Email            *string `gorm:"type:varchar(320);uniqueIndex"`
+	ApiTokenHash     *string `gorm:"type:varchar(64);uniqueIndex"`
+	Timezone         string  `gorm:"type:varchar(64)"`
+	BaseCurrency     string  `gorm:"type:varchar(10)"`
 	Chats            []Chat
 	Messages         []Message
 }
@@ -35,21 +119,23 @@ type Chat struct {
 
 type Message struct {
 	gorm.Model
-	UserID      uint             `gorm:"index"`
-	ChatID      uint             `gorm:"index"`
-	Text        string           `gorm:"type:text"`
-	TelegramIDs []int            `gorm:"serializer:json"`
-	Direction   MessageDirection `gorm:"type:varchar(16)"`
-	User        *User
-	Chat        *Chat
-	Files       []File
+	UserID       uint             `gorm:"index"`
+	ChatID       uint             `gorm:"index"`
+	Text         string           `gorm:"type:text"`
+	TelegramIDs  []int            `gorm:"serializer:json"`
+	Direction    MessageDirection `gorm:"type:varchar(16)"`
+	ParsedFileID *uint            `gorm:"index"`
+	User         *User
+	Chat         *Chat
+	Files        []File
 }
 
-// A file provided by the User
+// A file provided by the User. ContentHash is sha256 of the blob, to recognize the same file sent again
 type File struct {
 	gorm.Model
 	MessageID    uint   `gorm:"index"`
-	BlobKey      string `gorm:"varchar(128)"`
+	BlobKey      string `gorm:"type:varchar(128)"`
+	ContentHash  string `gorm:"type:varchar(64);index"`
 	Size         int64
 	MimeType     string `gorm:"type:varchar(256)"`
 	OriginalName string `gorm:"type:varchar(1024)"`
@@ -68,47 +154,248 @@ type ExposedFile struct {
 	File   File
 }
 
-// Represents a parsed receipt/document extracted from a File
+// Represents a parsed receipt/document extracted from a File, or an expense entered manually by the User.
+// BaseTotalWithTax is converted to BaseCurrency of the User at OccuredAt.
+// DuplicateOfID is set when the receipt looks like one recorded before, till the User decides to keep both.
+// FieldConfidence and Box tell which values LLM was unsure of and where the receipt is in the File.
+// Unsure is set when LLM was unsure of the total, the date or the currency, then the receipt is left out of alerts
 type Receipt struct {
 	gorm.Model
-	FileID         uint            `gorm:"index"`
-	TotalBeforeTax decimal.Decimal `gorm:"type:decimal(20,2)"`
-	Tax            decimal.Decimal `gorm:"type:decimal(20,2)"`
-	TotalWithTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
-	Currency       string          `gorm:"type:varchar(10)"`
-	Origin         string          `gorm:"type:text"`
-	Recipient      string          `gorm:"type:text"`
-	Details        string          `gorm:"type:text"`
-	Summary        string          `gorm:"type:text"`
-	OccuredAt      time.Time       `gorm:"type:timestamp"`
-	File           *File
-	Products       []Product
+	UserID           uint            `gorm:"index"`
+	FileID           *uint           `gorm:"index"`
+	Source           ReceiptSource   `gorm:"type:varchar(16)"`
+	TotalBeforeTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Tax              decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TotalWithTax     decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency         string          `gorm:"type:varchar(10)"`
+	BaseCurrency     string          `gorm:"type:varchar(10)"`
+	BaseTotalWithTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Origin           string          `gorm:"type:text"`
+	Recipient        string          `gorm:"type:text"`
+	Details          string          `gorm:"type:text"`
+	Summary          string          `gorm:"type:text"`
+	OccuredAt        time.Time       `gorm:"type:timestamp"`
+	DuplicateOfID    *uint           `gorm:"index"`
+	MerchantID       *uint           `gorm:"index"`
+	FieldConfidence  FieldConfidence `gorm:"type:text"`
+	Box              Box             `gorm:"type:text"`
+	Unsure           bool            `gorm:"index"`
+	User             *User
+	File             *File
+	DuplicateOf      *Receipt
+	Merchant         *Merchant
+	Products         []Product
+	TaxLines         []ReceiptTaxLine
+}
+
+// ReceiptTaxLine is the tax of a Receipt at one rate, as in the VAT summary of european receipts. Rate is in percent
+type ReceiptTaxLine struct {
+	gorm.Model
+	ReceiptID uint            `gorm:"index"`
+	Rate      decimal.Decimal `gorm:"type:decimal(5,2)"`
+	Base      decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Amount    decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Receipt   *Receipt
 }
 
-// Product represents an item parsed from a Receipt
+// Product represents a line parsed from a Receipt: an item, or a discount, deposit, fee or tip. BaseTotalWithTax is converted to Receipt.BaseCurrency.
+// Totals are after Discount, so discount lines have negative totals. CatalogProduct is the same product across receipts, to follow its price.
+// Line is the number of the line on the receipt, FieldConfidence and Box are as for Receipt
 type Product struct {
 	gorm.Model
-	ReceiptID      uint            `gorm:"index"`
-	Title          string          `gorm:"type:text"`
-	Details        string          `gorm:"type:text"`
-	TotalBeforeTax decimal.Decimal `gorm:"type:decimal(20,2)"`
-	Tax            decimal.Decimal `gorm:"type:decimal(20,2)"`
-	TotalWithTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
-	Receipt        *Receipt
-	Categories     []Category `gorm:"many2many:product_categories"`
+	ReceiptID        uint            `gorm:"index"`
+	CatalogProductID *uint           `gorm:"index"`
+	LineType         ProductLineType `gorm:"type:varchar(16)"`
+	Title            string          `gorm:"type:text"`
+	Details          string          `gorm:"type:text"`
+	Quantity         decimal.Decimal `gorm:"type:decimal(20,3)"`
+	Unit             string          `gorm:"type:varchar(16)"`
+	UnitPrice        decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Discount         decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TotalBeforeTax   decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TaxRate          decimal.Decimal `gorm:"type:decimal(5,2)"`
+	Tax              decimal.Decimal `gorm:"type:decimal(20,2)"`
+	TotalWithTax     decimal.Decimal `gorm:"type:decimal(20,2)"`
+	BaseTotalWithTax decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Line             int
+	FieldConfidence  FieldConfidence `gorm:"type:text"`
+	Box              Box             `gorm:"type:text"`
+	Receipt          *Receipt
+	CatalogProduct   *CatalogProduct
+	Categories       []Category `gorm:"many2many:product_categories"`
+}
+
+// CatalogProduct is a canonical product of the User, e.g. "Milk 3.5%" of 1 l, which line items of receipts link to
+type CatalogProduct struct {
+	gorm.Model
+	UserID         uint            `gorm:"index"`
+	Name           string          `gorm:"type:text"`
+	NormalizedName string          `gorm:"type:varchar(256);index"`
+	Unit           string          `gorm:"type:varchar(16)"`
+	Size           decimal.Decimal `gorm:"type:decimal(20,3)"`
+	User           *User
+	Products       []Product
 }
 
-// Category groups products
+// Category groups products. Categories without a User are templates, which are copied to every User to edit.
+// Details of a template lists titles of its subcategories, separated by ";"
 type Category struct {
 	gorm.Model
-	Title    string    `gorm:"type:text"`
-	Details  string    `gorm:"type:text"`
-	Products []Product `gorm:"many2many:product_categories"`
+	UserID   *uint  `gorm:"index"`
+	ParentID *uint  `gorm:"index"`
+	Title    string `gorm:"type:text"`
+	Details  string `gorm:"type:text"`
+	User     *User
+	Parent   *Category
+	Children []Category `gorm:"foreignKey:ParentID"`
+	Products []Product  `gorm:"many2many:product_categories"`
 }
 
-// ProductCategory is the join table for many-to-many Product<->Category
+// ProductCategory is the join table for many-to-many Product<->Category. Source tells what categorized the product.
+// Confidence is the probability of the category by the local classifier, nil when it had too few products to learn from
 type ProductCategory struct {
 	gorm.Model
-	ProductID  uint `gorm:"index"`
-	CategoryID uint `gorm:"index"`
+	ProductID  uint                  `gorm:"index"`
+	CategoryID uint                  `gorm:"index"`
+	Source     ProductCategorySource `gorm:"type:varchar(16)"`
+	Confidence *float64              `gorm:"type:decimal(5,4)"`
+}
+
+// CategoryRule categorizes products of the User instead of LLM: products which titles have all words of Pattern, bought at Merchant if it's set.
+// Learned rules come from corrections of the User, the others are added by the User. Hits counts products categorized by the rule
+type CategoryRule struct {
+	gorm.Model
+	UserID     uint   `gorm:"index"`
+	MerchantID *uint  `gorm:"index"`
+	Pattern    string `gorm:"type:varchar(256)"`
+	CategoryID uint   `gorm:"index"`
+	Learned    bool
+	Hits       int
+	User       *User
+	Merchant   *Merchant
+	Category   *Category
+}
+
+// Merchant is a store or a service the User pays to, extracted from Receipt.Origin.
+// NormalizedName is the name without case, punctuation and legal form, to match spellings of the same merchant.
+// DefaultCategory is used for products of the merchant which LLM couldn't categorize
+type Merchant struct {
+	gorm.Model
+	UserID            uint   `gorm:"index"`
+	Name              string `gorm:"type:text"`
+	NormalizedName    string `gorm:"type:varchar(256);index"`
+	Address           string `gorm:"type:text"`
+	TaxID             string `gorm:"type:varchar(64);index"`
+	DefaultCategoryID *uint  `gorm:"index"`
+	User              *User
+	DefaultCategory   *Category
+	Aliases           []MerchantAlias
+	Receipts          []Receipt
+}
+
+// Another name of a Merchant set by the User, e.g. how the merchant is spelled on some receipts
+type MerchantAlias struct {
+	gorm.Model
+	MerchantID      uint   `gorm:"index"`
+	Alias           string `gorm:"type:text"`
+	NormalizedAlias string `gorm:"type:varchar(256);index"`
+	Merchant        *Merchant
+}
+
+// Budget limits spending of the User in a Category, with its subcategories, per calendar Period in the timezone of the User.
+// AlertedFrom and AlertedPercent are the start of the period and the threshold the User was last alerted about
+type Budget struct {
+	gorm.Model
+	UserID         uint            `gorm:"index"`
+	CategoryID     uint            `gorm:"index"`
+	Period         BudgetPeriod    `gorm:"type:varchar(16)"`
+	Amount         decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency       string          `gorm:"type:varchar(10)"`
+	AlertedFrom    *time.Time      `gorm:"type:timestamp"`
+	AlertedPercent int
+	User           *User
+	Category       *Category
+}
+
+// Schedule runs the job of Kind for the User at times of Cron, a cron expression in the timezone of the User. NextRunAt is when it's due
+type Schedule struct {
+	gorm.Model
+	UserID    uint         `gorm:"index"`
+	Kind      ScheduleKind `gorm:"type:varchar(32)"`
+	Cron      string       `gorm:"type:varchar(128)"`
+	NextRunAt time.Time    `gorm:"type:timestamp;index"`
+	LastRunAt *time.Time   `gorm:"type:timestamp"`
+	User      *User
+}
+
+// RecurringExpense is a charge of about Amount in Currency the User pays to Merchant every Cadence, like a subscription, found in receipts.
+// NextExpectedAt is when the charge after LastReceipt is due. MissedAlertedAt is the NextExpectedAt the User was alerted about as missed
+type RecurringExpense struct {
+	gorm.Model
+	UserID          uint             `gorm:"index"`
+	MerchantID      uint             `gorm:"index"`
+	Cadence         RecurringCadence `gorm:"type:varchar(16)"`
+	Amount          decimal.Decimal  `gorm:"type:decimal(20,2)"`
+	Currency        string           `gorm:"type:varchar(10)"`
+	Occurrences     int
+	LastReceiptID   uint       `gorm:"index"`
+	LastChargedAt   time.Time  `gorm:"type:timestamp"`
+	NextExpectedAt  time.Time  `gorm:"type:timestamp;index"`
+	MissedAlertedAt *time.Time `gorm:"type:timestamp"`
+	User            *User
+	Merchant        *Merchant
+	LastReceipt     *Receipt
+}
+
+// Anomaly is unusual spending of the User: Receipt far above Baseline, the typical amount for Merchant or Category,
+// spending in Category over the week from PeriodFrom far above a typical week, or a charge in a foreign Currency.
+// Expected is set when the User marks it as fine, then similar spending isn't alerted
+type Anomaly struct {
+	gorm.Model
+	UserID     uint            `gorm:"index"`
+	Kind       AnomalyKind     `gorm:"type:varchar(32)"`
+	ReceiptID  *uint           `gorm:"index"`
+	MerchantID *uint           `gorm:"index"`
+	Category   string          `gorm:"type:text"`
+	PeriodFrom *time.Time      `gorm:"type:timestamp"`
+	Amount     decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Baseline   decimal.Decimal `gorm:"type:decimal(20,2)"`
+	Currency   string          `gorm:"type:varchar(10)"`
+	Expected   bool
+	User       *User
+	Receipt    *Receipt
+	Merchant   *Merchant
+}
+
+// LedgerAccount is the account of plain-text accounting exports which products of Category are posted to,
+// or, without a Category, the account receipts are paid from or tax is posted to, as Role tells
+type LedgerAccount struct {
+	gorm.Model
+	UserID     uint              `gorm:"index"`
+	Role       LedgerAccountRole `gorm:"type:varchar(16)"`
+	CategoryID *uint             `gorm:"index"`
+	Account    string            `gorm:"type:varchar(256)"`
+	User       *User
+	Category   *Category
+}
+
+// Job tracks asynchronous processing of a Message requested through the API
+type Job struct {
+	gorm.Model
+	Key       string    `gorm:"type:varchar(64);uniqueIndex"`
+	UserID    uint      `gorm:"index"`
+	MessageID uint      `gorm:"index"`
+	Status    JobStatus `gorm:"type:varchar(16)"`
+	Result    string    `gorm:"type:text"`
+	Error     string    `gorm:"type:text"`
+	User      *User
+	Message   *Message
+}
+
+// ExchangeRate is the amount of Currency for 1 EUR on Date, as published by ECB
+type ExchangeRate struct {
+	gorm.Model
+	Date     time.Time       `gorm:"type:date;uniqueIndex:idx_exchange_rates_date_currency"`
+	Currency string          `gorm:"type:varchar(10);uniqueIndex:idx_exchange_rates_date_currency"`
+	Rate     decimal.Decimal `gorm:"type:decimal(20,8)"`
 }
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/config"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Generates a new API token. Only the hash of the token is stored in User.ApiTokenHash
+func NewApiToken() (token string, hash string, _ error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	token = hex.EncodeToString(raw)
+	return token, HashApiToken(token), nil
+}
+
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Finds the user by the API token, see NewApiToken. gorm.ErrRecordNotFound if no user has it
+func FindUserByApiToken(ctx context.Context, db *gorm.DB, token string) (User, error) {
+	var user User
+	if err := db.WithContext(ctx).Where("api_token_hash = ?", HashApiToken(token)).First(&user).Error; err != nil {
+		return user, errors.WithStack(err)
+	}
+	return user, nil
+}
+
+// Location of the user by IANA Timezone, like Europe/Berlin. UTC if not set or unknown
+func (user User) Location() *time.Location {
+	if user.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Currency to report spendings in
+func (user User) EffectiveBaseCurrency() string {
+	if user.BaseCurrency == "" {
+		return config.DefaultCurrency()
+	}
+	return user.BaseCurrency
+}
@@ -0,0 +1,157 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/merchants"
+	"github.com/pkg/errors"
+	"gocloud.dev/blob"
+	"gorm.io/gorm"
+)
+
+const (
+	// How far apart the same purchase may be dated, e.g. when the time on a photo is misread
+	MAX_OCCURED_AT_DRIFT = 36 * time.Hour
+	// Share of common words in origins of the same purchase
+	MIN_ORIGIN_SIMILARITY = 0.5
+)
+
+// Fills ContentHash of the file from its blob, if it's not known yet
+func HashFile(ctx context.Context, files *blob.Bucket, file *db.File) error {
+	if file.ContentHash != "" {
+		return nil
+	}
+	content, err := files.ReadAll(ctx, file.BlobKey)
+	if err != nil {
+		return fmt.Errorf("reading blob: %w", errors.WithStack(err))
+	}
+	sum := sha256.Sum256(content)
+	file.ContentHash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// Finds the earliest other file of the user with the same content. Returns nil if the file is new
+func SameFile(ctx context.Context, dbc *gorm.DB, userID uint, file db.File) (*db.File, error) {
+	if file.ContentHash == "" {
+		return nil, nil
+	}
+	var same db.File
+	err := dbc.WithContext(ctx).
+		Joins("JOIN messages ON messages.id = files.message_id").
+		Where("messages.user_id = ? AND files.content_hash = ? AND files.id <> ?", userID, file.ContentHash, file.ID).
+		Order("files.id asc").
+		First(&same).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching files with the same content: %w", errors.WithStack(err))
+	}
+	return &same, nil
+}
+
+// Finds an earlier receipt of the same user for the same purchase: same total and currency, close date and similar origin.
+// Returns nil if the receipt looks new
+func FindDuplicate(ctx context.Context, dbc *gorm.DB, receipt db.Receipt) (*db.Receipt, error) {
+	var candidates []db.Receipt
+	err := dbc.WithContext(ctx).
+		Where("user_id = ? AND id < ? AND duplicate_of_id IS NULL", receipt.UserID, receipt.ID).
+		Where("occured_at >= ? AND occured_at <= ?", receipt.OccuredAt.Add(-MAX_OCCURED_AT_DRIFT), receipt.OccuredAt.Add(MAX_OCCURED_AT_DRIFT)).
+		Order("id asc").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching receipts around the date: %w", errors.WithStack(err))
+	}
+
+	var best *db.Receipt
+	for i, candidate := range candidates {
+		if !samePurchase(receipt, candidate) {
+			continue
+		}
+		if best == nil || drift(receipt, candidate) < drift(receipt, *best) {
+			best = &candidates[i]
+		}
+	}
+	return best, nil
+}
+
+// Finds an earlier receipt of the same user parsed from a copy of the file with the content hash, with the same total, currency and date.
+// Unlike FindDuplicate it doesn't match receipts by close values, so it suits receipts which values LLM was unsure of. Returns nil if there is none
+func FindInSameFile(ctx context.Context, dbc *gorm.DB, receipt db.Receipt, contentHash string) (*db.Receipt, error) {
+	if contentHash == "" {
+		return nil, nil
+	}
+	var candidates []db.Receipt
+	err := dbc.WithContext(ctx).
+		Joins("JOIN files ON files.id = receipts.file_id").
+		Where("receipts.user_id = ? AND receipts.id < ? AND receipts.duplicate_of_id IS NULL", receipt.UserID, receipt.ID).
+		Where("files.content_hash = ?", contentHash).
+		Order("receipts.id asc").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching receipts of the same file: %w", errors.WithStack(err))
+	}
+	for i, candidate := range candidates {
+		if candidate.TotalWithTax.Equal(receipt.TotalWithTax) && candidate.Currency == receipt.Currency && candidate.OccuredAt.Equal(receipt.OccuredAt) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Flags the receipt as a duplicate of an earlier one, if there is one. Returns the earlier receipt
+func Flag(ctx context.Context, dbc *gorm.DB, receipt *db.Receipt) (*db.Receipt, error) {
+	original, err := FindDuplicate(ctx, dbc, *receipt)
+	if err != nil || original == nil {
+		return nil, err
+	}
+	return flag(ctx, dbc, receipt, original)
+}
+
+// Flags the receipt as a duplicate of an earlier one parsed from a copy of the file with the content hash, see FindInSameFile
+func FlagInSameFile(ctx context.Context, dbc *gorm.DB, receipt *db.Receipt, contentHash string) (*db.Receipt, error) {
+	original, err := FindInSameFile(ctx, dbc, *receipt, contentHash)
+	if err != nil || original == nil {
+		return nil, err
+	}
+	return flag(ctx, dbc, receipt, original)
+}
+
+func flag(ctx context.Context, dbc *gorm.DB, receipt *db.Receipt, original *db.Receipt) (*db.Receipt, error) {
+	if err := dbc.WithContext(ctx).Model(receipt).Update("duplicate_of_id", original.ID).Error; err != nil {
+		return nil, fmt.Errorf("flagging receipt as duplicate: %w", errors.WithStack(err))
+	}
+	receipt.DuplicateOf = original
+	return original, nil
+}
+
+func samePurchase(a db.Receipt, b db.Receipt) bool {
+	if !a.TotalWithTax.Equal(b.TotalWithTax) {
+		return false
+	}
+	if a.Currency != "" && b.Currency != "" && a.Currency != b.Currency {
+		return false
+	}
+	if a.MerchantID != nil && b.MerchantID != nil && *a.MerchantID == *b.MerchantID {
+		return true
+	}
+	return originSimilarity(a.Origin, b.Origin) >= MIN_ORIGIN_SIMILARITY
+}
+
+func drift(a db.Receipt, b db.Receipt) time.Duration {
+	return a.OccuredAt.Sub(b.OccuredAt).Abs()
+}
+
+// Similarity of the merchant names in the origins. Unknown origin doesn't tell the receipts apart
+func originSimilarity(a string, b string) float64 {
+	nameA, nameB := merchants.FromOrigin(a).Name, merchants.FromOrigin(b).Name
+	if merchants.Normalize(nameA) == "" || merchants.Normalize(nameB) == "" {
+		return 1
+	}
+	return merchants.NameSimilarity(nameA, nameB)
+}
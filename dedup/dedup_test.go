@@ -0,0 +1,117 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dbc, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := dbc.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := db.Migrate(dbc); err != nil {
+		t.Fatal(err)
+	}
+	return dbc
+}
+
+func TestSamePurchase(t *testing.T) {
+	one, two := uint(1), uint(2)
+	receipt := func(total string, currency string, merchantID *uint, origin string) db.Receipt {
+		return db.Receipt{TotalWithTax: decimal.RequireFromString(total), Currency: currency, MerchantID: merchantID, Origin: origin}
+	}
+	tests := []struct {
+		name string
+		a, b db.Receipt
+		same bool
+	}{
+		{"same origin", receipt("12.30", "EUR", nil, "REWE Markt GmbH; Hauptstr. 1"), receipt("12.3", "EUR", nil, "REWE Markt; Hauptstrasse 1"), true},
+		{"other total", receipt("12.30", "EUR", nil, "REWE"), receipt("12.31", "EUR", nil, "REWE"), false},
+		{"other currency", receipt("12.30", "EUR", nil, "REWE"), receipt("12.30", "USD", nil, "REWE"), false},
+		{"unknown currency", receipt("12.30", "", nil, "REWE"), receipt("12.30", "USD", nil, "REWE"), true},
+		{"same merchant", receipt("12.30", "EUR", &one, "REWE City"), receipt("12.30", "EUR", &one, "Rewe Markt Berlin Mitte"), true},
+		{"other merchant with the same origin", receipt("12.30", "EUR", &one, "REWE"), receipt("12.30", "EUR", &two, "REWE"), true},
+		{"other origin", receipt("12.30", "EUR", nil, "REWE"), receipt("12.30", "EUR", nil, "Lidl"), false},
+		{"barely similar origin", receipt("12.30", "EUR", nil, "REWE Markt Berlin"), receipt("12.30", "EUR", nil, "Edeka Markt Berlin"), true},
+		{"dissimilar origin", receipt("12.30", "EUR", nil, "REWE Markt Berlin"), receipt("12.30", "EUR", nil, "Edeka Center Berlin"), false},
+		{"unknown origin", receipt("12.30", "EUR", nil, ""), receipt("12.30", "EUR", nil, "Lidl"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := samePurchase(test.a, test.b); same != test.same {
+				t.Errorf("samePurchase = %v, expected %v", same, test.same)
+			}
+		})
+	}
+}
+
+func TestFindDuplicate(t *testing.T) {
+	ctx := context.Background()
+	dbc := testDB(t)
+	user := db.User{}
+	if err := dbc.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	otherUser := db.User{}
+	if err := dbc.Create(&otherUser).Error; err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2025, 3, 14, 18, 30, 0, 0, time.UTC)
+	create := func(userID uint, total string, occuredAt time.Time, origin string) db.Receipt {
+		t.Helper()
+		receipt := db.Receipt{UserID: userID, TotalWithTax: decimal.RequireFromString(total), Currency: "EUR", OccuredAt: occuredAt, Origin: origin}
+		if err := dbc.Create(&receipt).Error; err != nil {
+			t.Fatal(err)
+		}
+		return receipt
+	}
+	far := create(user.ID, "12.30", at.Add(-3*MAX_OCCURED_AT_DRIFT), "REWE")
+	otherUsers := create(otherUser.ID, "12.30", at, "REWE")
+	drifted := create(user.ID, "12.30", at.Add(-24*time.Hour), "REWE")
+	closest := create(user.ID, "12.30", at.Add(time.Hour), "REWE")
+	otherShop := create(user.ID, "12.30", at, "Lidl")
+	receipt := create(user.ID, "12.30", at, "REWE")
+	later := create(user.ID, "12.30", at, "REWE")
+
+	original, err := FindDuplicate(ctx, dbc, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original == nil || original.ID != closest.ID {
+		t.Errorf("expected the closest receipt %d, got %+v", closest.ID, original)
+	}
+
+	if _, err := Flag(ctx, dbc, &later); err != nil {
+		t.Fatal(err)
+	}
+	var flagged db.Receipt
+	if err := dbc.First(&flagged, later.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if flagged.DuplicateOfID == nil || *flagged.DuplicateOfID != receipt.ID {
+		t.Errorf("expected the later receipt to be flagged as a duplicate of %d, got %v", receipt.ID, flagged.DuplicateOfID)
+	}
+	if original, err = FindDuplicate(ctx, dbc, drifted); err != nil || original != nil {
+		t.Errorf("expected no duplicate of the first receipt in range, got %+v, %v", original, err)
+	}
+	for _, receipt := range []db.Receipt{far, otherUsers, otherShop} {
+		if original, err = FindDuplicate(ctx, dbc, receipt); err != nil || original != nil {
+			t.Errorf("expected no duplicate of receipt %d, got %+v, %v", receipt.ID, original, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package deps
 
 import (
+	"context"
 	"log/slog"
 
 	"gocloud.dev/blob"
@@ -8,7 +9,13 @@ import (
 )
 
 type Deps struct {
-	Logger *slog.Logger
-	DBC    *gorm.DB
-	Files  *blob.Bucket
+	Logger   *slog.Logger
+	DBC      *gorm.DB
+	Files    *blob.Bucket
+	Notifier Notifier
+}
+
+// Messages a user on the initiative of the bot, e.g. to alert about a budget
+type Notifier interface {
+	Notify(ctx context.Context, userID uint, text string) error
 }
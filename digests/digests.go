@@ -0,0 +1,144 @@
+package digests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/scheduler"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	TOP_CATEGORIES = 5
+	TOP_MERCHANTS  = 3
+	TOP_RECEIPTS   = 3
+	DATE_FORMAT    = "Jan 2"
+)
+
+// Default cron expressions of digests: weekly on Monday and monthly on the 1st, at 9:00 of the user
+var DefaultCron = map[db.ScheduleKind]string{
+	db.ScheduleKindWeeklyDigest:  "0 9 * * 1",
+	db.ScheduleKindMonthlyDigest: "0 9 1 * *",
+}
+
+// Jobs sending digests, for scheduler.NewScheduler
+func Jobs() map[db.ScheduleKind]scheduler.Job {
+	return map[db.ScheduleKind]scheduler.Job{
+		db.ScheduleKindWeeklyDigest:  digestJob(db.BudgetPeriodWeek),
+		db.ScheduleKindMonthlyDigest: digestJob(db.BudgetPeriodMonth),
+	}
+}
+
+func digestJob(period db.BudgetPeriod) scheduler.Job {
+	return func(ctx context.Context, deps deps.Deps, user db.User, at time.Time) error {
+		text, err := Digest(ctx, deps.DBC, user, period, at)
+		if err != nil {
+			return err
+		}
+		if text == "" {
+			return nil
+		}
+		if err := deps.Notifier.Notify(ctx, user.ID, text); err != nil {
+			return fmt.Errorf("sending digest: %w", err)
+		}
+		return nil
+	}
+}
+
+// Digest of spending of the user over the last period which ends by the end of the day of the time, compared with the period before it.
+// So a digest on Sunday evening covers the week ending that day, and one on Monday morning covers the previous week.
+// Returns empty string if the user spent nothing in the period
+func Digest(ctx context.Context, dbc *gorm.DB, user db.User, period db.BudgetPeriod, at time.Time) (string, error) {
+	from, to := Period(period, at, user.Location())
+	previousFrom, previousTo := budgets.PeriodBounds(period, from.Add(-time.Nanosecond), user.Location())
+
+	spending, err := stats.SpendingBetween(ctx, dbc, user, from, to)
+	if err != nil {
+		return "", err
+	}
+	if spending.Receipts == 0 {
+		return "", nil
+	}
+	previous, err := stats.SpendingBetween(ctx, dbc, user, previousFrom, previousTo)
+	if err != nil {
+		return "", err
+	}
+	largest, err := stats.LargestReceipts(ctx, dbc, user, from, to, TOP_RECEIPTS)
+	if err != nil {
+		return "", err
+	}
+
+	cur := spending.Currency
+	lines := []string{
+		fmt.Sprintf("Your %sly digest, %s - %s", period, from.Format(DATE_FORMAT), to.Add(-time.Nanosecond).Format(DATE_FORMAT)),
+		fmt.Sprintf("Spent %s %s in %d receipts%s", spending.Total.StringFixed(2), cur, spending.Receipts, change(spending.Total, previous.Total, "last "+string(period))),
+	}
+	if spending.Unconverted > 0 {
+		lines = append(lines, fmt.Sprintf("%d receipts aren't counted, they have no exchange rate to %s", spending.Unconverted, cur))
+	}
+
+	if len(spending.ByCategory) > 0 {
+		previousByCategory := map[string]decimal.Decimal{}
+		for _, total := range previous.ByCategory {
+			previousByCategory[total.Category] = total.Total
+		}
+		lines = append(lines, "", "By category:")
+		for _, total := range spending.ByCategory[:min(TOP_CATEGORIES, len(spending.ByCategory))] {
+			lines = append(lines, fmt.Sprintf("- %s: %s%s", total.Category, total.Total.StringFixed(2), change(total.Total, previousByCategory[total.Category], "")))
+		}
+	}
+
+	if len(spending.ByMerchant) > 0 {
+		lines = append(lines, "", "Top merchants:")
+		for _, total := range spending.ByMerchant[:min(TOP_MERCHANTS, len(spending.ByMerchant))] {
+			lines = append(lines, fmt.Sprintf("- %s: %s in %d receipts", total.Merchant, total.Total.StringFixed(2), total.Receipts))
+		}
+	}
+
+	if len(largest) > 0 {
+		lines = append(lines, "", "Largest receipts:")
+		for _, receipt := range largest {
+			merchant := receipt.Origin
+			if receipt.Merchant != nil {
+				merchant = receipt.Merchant.Name
+			}
+			if merchant == "" {
+				merchant = stats.UNKNOWN_MERCHANT
+			}
+			lines = append(lines, fmt.Sprintf("- %s, %s: %s", receipt.OccuredAt.In(user.Location()).Format(DATE_FORMAT), merchant, receipt.BaseTotalWithTax.StringFixed(2)))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Bounds of the period a digest at the time covers, see Digest
+func Period(period db.BudgetPeriod, at time.Time, loc *time.Location) (from time.Time, to time.Time) {
+	from, to = budgets.PeriodBounds(period, at, loc)
+	year, month, day := at.In(loc).Date()
+	if !to.After(time.Date(year, month, day+1, 0, 0, 0, 0, loc)) {
+		return from, to
+	}
+	return budgets.PeriodBounds(period, from.Add(-time.Nanosecond), loc)
+}
+
+// Change of the total vs the previous one, e.g. " (+12% vs last week)"
+func change(total decimal.Decimal, previous decimal.Decimal, vs string) string {
+	if vs != "" {
+		vs = " vs " + vs
+	}
+	if !previous.IsPositive() {
+		if vs == "" {
+			return " (new)"
+		}
+		return ""
+	}
+	percent := total.Sub(previous).Mul(decimal.NewFromInt(100)).Div(previous).Round(0).IntPart()
+	return fmt.Sprintf(" (%+d%%%s)", percent, vs)
+}
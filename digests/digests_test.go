@@ -0,0 +1,40 @@
+package digests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+)
+
+func TestPeriod(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, berlin)
+	}
+	tests := []struct {
+		name   string
+		period db.BudgetPeriod
+		at     time.Time
+		from   time.Time
+		to     time.Time
+	}{
+		{"week on Sunday covers the ending week", db.BudgetPeriodWeek, time.Date(2025, 3, 16, 20, 0, 0, 0, berlin), date(2025, 3, 10), date(2025, 3, 17)},
+		{"week on Monday covers the previous week", db.BudgetPeriodWeek, time.Date(2025, 3, 17, 9, 0, 0, 0, berlin), date(2025, 3, 10), date(2025, 3, 17)},
+		{"Sunday in UTC is Monday in the location", db.BudgetPeriodWeek, time.Date(2025, 3, 16, 23, 30, 0, 0, time.UTC), date(2025, 3, 10), date(2025, 3, 17)},
+		{"month on its last day", db.BudgetPeriodMonth, time.Date(2025, 3, 31, 20, 0, 0, 0, berlin), date(2025, 3, 1), date(2025, 4, 1)},
+		{"month on the first", db.BudgetPeriodMonth, time.Date(2025, 4, 1, 9, 0, 0, 0, berlin), date(2025, 3, 1), date(2025, 4, 1)},
+		{"year on the first", db.BudgetPeriodYear, time.Date(2026, 1, 1, 9, 0, 0, 0, berlin), date(2025, 1, 1), date(2026, 1, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, to := Period(test.period, test.at, berlin)
+			if !from.Equal(test.from) || !to.Equal(test.to) {
+				t.Errorf("Period(%s, %s) = [%s, %s), expected [%s, %s)", test.period, test.at, from, to, test.from, test.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	DEFAULT_PAYMENT_ACCOUNT = "Assets:Cash"
+	DEFAULT_TAX_ACCOUNT     = "Expenses:Taxes"
+	// Categories without an account are posted under it
+	EXPENSES_ACCOUNT = "Expenses"
+)
+
+// Account names every format accepts, as beancount is the strictest: a root type and capitalized components
+var accountPattern = regexp.MustCompile(`^(Assets|Liabilities|Equity|Income|Expenses)(:[\p{Lu}\p{Nd}][\p{L}\p{Nd}-]*)+$`)
+
+// Accounts of plain-text accounting exports of a user, see db.LedgerAccount
+type Accounts struct {
+	Payment    string
+	Tax        string
+	byCategory map[uint]string
+}
+
+func ValidateAccount(account string) error {
+	if !accountPattern.MatchString(account) {
+		return fmt.Errorf("account %q should look like Expenses:Food:Groceries: Assets, Liabilities, Equity, Income or Expenses, then names starting with a capital letter or a digit, separated by \":\", without spaces", account)
+	}
+	return nil
+}
+
+// Accounts set by the user, with defaults for the others
+func LoadAccounts(ctx context.Context, dbc *gorm.DB, userID uint) (Accounts, error) {
+	accounts := Accounts{Payment: DEFAULT_PAYMENT_ACCOUNT, Tax: DEFAULT_TAX_ACCOUNT, byCategory: map[uint]string{}}
+	rows, err := ListAccounts(ctx, dbc, userID)
+	if err != nil {
+		return accounts, err
+	}
+	for _, row := range rows {
+		switch row.Role {
+		case db.LedgerAccountRolePayment:
+			accounts.Payment = row.Account
+		case db.LedgerAccountRoleTax:
+			accounts.Tax = row.Account
+		case db.LedgerAccountRoleCategory:
+			if row.CategoryID != nil {
+				accounts.byCategory[*row.CategoryID] = row.Account
+			}
+		}
+	}
+	return accounts, nil
+}
+
+// Accounts set by the user, with categories and their parents
+func ListAccounts(ctx context.Context, dbc *gorm.DB, userID uint) ([]db.LedgerAccount, error) {
+	var rows []db.LedgerAccount
+	if err := dbc.WithContext(ctx).Where("user_id = ?", userID).Preload("Category.Parent").Order("role, id").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("fetching ledger accounts: %w", errors.WithStack(err))
+	}
+	return rows, nil
+}
+
+// Sets the account of the role, of the category for LedgerAccountRoleCategory. An empty account goes back to the default
+func SetAccount(ctx context.Context, dbc *gorm.DB, userID uint, role db.LedgerAccountRole, categoryID *uint, account string) error {
+	if account != "" {
+		if err := ValidateAccount(account); err != nil {
+			return err
+		}
+	}
+	return dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		scope := tx.Where("user_id = ? AND role = ?", userID, role)
+		if categoryID != nil {
+			scope = scope.Where("category_id = ?", *categoryID)
+		}
+		if err := scope.Delete(&db.LedgerAccount{}).Error; err != nil {
+			return fmt.Errorf("deleting ledger account: %w", errors.WithStack(err))
+		}
+		if account == "" {
+			return nil
+		}
+		if err := tx.Create(&db.LedgerAccount{UserID: userID, Role: role, CategoryID: categoryID, Account: account}).Error; err != nil {
+			return fmt.Errorf("creating ledger account: %w", errors.WithStack(err))
+		}
+		return nil
+	})
+}
+
+// The account of the category: its own, the account of its parent with the title of the category, or one under EXPENSES_ACCOUNT.
+// The parent has to be loaded
+func (accounts Accounts) ForCategory(category db.Category) string {
+	if account, ok := accounts.byCategory[category.ID]; ok {
+		return account
+	}
+	if category.Parent != nil {
+		if account, ok := accounts.byCategory[category.Parent.ID]; ok {
+			return account + ":" + accountName(category.Title)
+		}
+		return EXPENSES_ACCOUNT + ":" + accountName(category.Parent.Title) + ":" + accountName(category.Title)
+	}
+	return EXPENSES_ACCOUNT + ":" + accountName(category.Title)
+}
+
+// The account of lines without a category, by their type as spending summaries total them
+func (accounts Accounts) ForLineType(lineType db.ProductLineType) string {
+	return EXPENSES_ACCOUNT + ":" + accountName(stats.LineTypeCategory(lineType))
+}
+
+// The title as a component of an account: words capitalized and joined, e.g. "Eating out" as "EatingOut"
+func accountName(title string) string {
+	var name strings.Builder
+	for _, word := range strings.FieldsFunc(title, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		runes := []rune(word)
+		name.WriteRune(unicode.ToUpper(runes[0]))
+		name.WriteString(string(runes[1:]))
+	}
+	if name.Len() == 0 {
+		return "Other"
+	}
+	return name.String()
+}
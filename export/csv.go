@@ -0,0 +1,66 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CSV_MIME_TYPE = "text/csv"
+	// Makes Excel read the file as UTF-8
+	UTF8_BOM = "\ufeff"
+	// Spreadsheets read text starting with these as a formula
+	FORMULA_PREFIXES = "=+-@\t\r"
+)
+
+func writeCsv(sheet Sheet) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(UTF8_BOM)
+	writer := csv.NewWriter(&out)
+	if err := writer.Write(sheet.Header); err != nil {
+		return nil, fmt.Errorf("writing csv header: %w", errors.WithStack(err))
+	}
+	for _, row := range sheet.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = csvCell(cell)
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, fmt.Errorf("writing csv row: %w", errors.WithStack(err))
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("writing csv: %w", errors.WithStack(err))
+	}
+	return out.Bytes(), nil
+}
+
+func csvCell(cell any) string {
+	switch value := cell.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.Format("2006-01-02 15:04")
+	case decimal.Decimal:
+		return value.String()
+	case string:
+		return escapeFormula(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Makes spreadsheets show text from receipts, like a product named "=1+2", as text instead of running it as a formula
+func escapeFormula(text string) string {
+	if text != "" && strings.ContainsRune(FORMULA_PREFIXES, rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
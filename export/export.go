@@ -0,0 +1,168 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type Format string
+
+const (
+	FormatCsv       Format = "csv"
+	FormatXlsx      Format = "xlsx"
+	FormatLedger    Format = "ledger"
+	FormatHledger   Format = "hledger"
+	FormatBeancount Format = "beancount"
+)
+
+// What to export: receipts of the user over [From, To), the current month by default.
+// Category limits the export to receipts with products in the category or its subcategories, and to those products
+type Spec struct {
+	Format   Format
+	From     time.Time
+	To       time.Time
+	Category string
+}
+
+// An exported file
+type File struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+func ParseFormat(format string) (Format, bool) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(format))); f {
+	case FormatCsv, FormatXlsx, FormatLedger, FormatHledger, FormatBeancount:
+		return f, true
+	case "":
+		return FormatXlsx, true
+	}
+	return "", false
+}
+
+// Whether the format is a plain-text accounting journal rather than spreadsheets
+func (format Format) IsJournal() bool {
+	return format == FormatLedger || format == FormatHledger || format == FormatBeancount
+}
+
+// Reads the spec from query parameters: format, xlsx by default, from and to as YYYY-MM-DD in the location, to inclusive, and category
+func ParseSpec(query url.Values, loc *time.Location) (Spec, error) {
+	format, ok := ParseFormat(query.Get("format"))
+	if !ok {
+		return Spec{}, fmt.Errorf("unknown export format %q, use csv, xlsx, ledger, hledger or beancount", query.Get("format"))
+	}
+	spec := Spec{Format: format, Category: strings.TrimSpace(query.Get("category"))}
+	if from := query.Get("from"); from != "" {
+		t, err := time.ParseInLocation(time.DateOnly, from, loc)
+		if err != nil {
+			return Spec{}, fmt.Errorf("from %q isn't YYYY-MM-DD", from)
+		}
+		spec.From = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.ParseInLocation(time.DateOnly, to, loc)
+		if err != nil {
+			return Spec{}, fmt.Errorf("to %q isn't YYYY-MM-DD", to)
+		}
+		spec.To = t.AddDate(0, 0, 1)
+	}
+	if !spec.From.IsZero() && !spec.To.IsZero() && !spec.From.Before(spec.To) {
+		return Spec{}, errors.New("from should be before to")
+	}
+	return spec, nil
+}
+
+// The period of the spec, with the current month in place of the missing bounds
+func (spec Spec) Period(now time.Time, loc *time.Location) (from time.Time, to time.Time) {
+	from, to = budgets.PeriodBounds(db.BudgetPeriodMonth, now, loc)
+	if !spec.From.IsZero() {
+		from = spec.From
+	}
+	if !spec.To.IsZero() {
+		to = spec.To
+	}
+	return from, to
+}
+
+// Exports receipts of the user as the spec tells: a journal, or spreadsheets
+func Files(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, now time.Time) ([]File, error) {
+	if spec.Format.IsJournal() {
+		journal, err := Journal(ctx, dbc, user, spec, now)
+		if err != nil {
+			return nil, err
+		}
+		return []File{journal}, nil
+	}
+	workbook, err := Build(ctx, dbc, user, spec, now)
+	if err != nil {
+		return nil, err
+	}
+	return workbook.Files(spec.Format)
+}
+
+// Receipts of the user over the period of the spec, oldest first, with merchants, files with their links, tax lines and products with categories.
+// Duplicates are left out. With a category, only receipts with products in it are loaded, with all their products
+func Receipts(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, now time.Time) ([]db.Receipt, error) {
+	from, to := spec.Period(now, user.Location())
+	var receipts []db.Receipt
+	err := dbc.WithContext(ctx).
+		Where("user_id = ? AND occured_at >= ? AND occured_at < ? AND duplicate_of_id IS NULL", user.ID, from.UTC(), to.UTC()).
+		Preload("Merchant").Preload("File.ExposedFile").Preload("TaxLines").
+		Preload("Products", func(tx *gorm.DB) *gorm.DB { return tx.Order("line, id") }).
+		Preload("Products.Categories.Parent").
+		Order("occured_at, id").
+		Find(&receipts).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	if spec.Category == "" {
+		return receipts, nil
+	}
+	var inCategory []db.Receipt
+	for _, receipt := range receipts {
+		for _, product := range receipt.Products {
+			if ProductWithin(product, spec.Category) {
+				inCategory = append(inCategory, receipt)
+				break
+			}
+		}
+	}
+	return inCategory, nil
+}
+
+// Paths of categories of the product, e.g. "Food > Groceries". Categories need their parents loaded
+func ProductCategories(product db.Product) []string {
+	paths := make([]string, 0, len(product.Categories))
+	for _, category := range product.Categories {
+		paths = append(paths, categories.Path(category))
+	}
+	return paths
+}
+
+// Whether any category of the product is within the category
+func ProductWithin(product db.Product, category string) bool {
+	for _, path := range ProductCategories(product) {
+		if categories.Within(path, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// The merchant of the receipt, or its origin if the merchant isn't known
+func MerchantName(receipt db.Receipt) string {
+	if receipt.Merchant != nil {
+		return receipt.Merchant.Name
+	}
+	return receipt.Origin
+}
@@ -0,0 +1,264 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	JOURNAL_MIME_TYPE = "text/plain"
+	// Width of account names in postings, to align amounts
+	ACCOUNT_WIDTH = 44
+	// Decimal places of the price of the currency of a receipt in the base currency
+	PRICE_PLACES = 6
+)
+
+// Postings of a receipt in its currency: expenses per account, tax per rate and the payment balancing them
+type transaction struct {
+	date      time.Time
+	payee     string
+	narration string
+	meta      [][2]string
+	postings  []posting
+	currency  string
+	// Set when the currency is other than the base one: a unit of it in the base currency, written as a price directive
+	price         *decimal.Decimal
+	priceCurrency string
+}
+
+type posting struct {
+	account  string
+	amount   decimal.Decimal
+	currency string
+	meta     [][2]string
+}
+
+// Writes receipts of the user as transactions of a plain-text accounting journal in the format: ledger, hledger or beancount.
+// Products are posted to accounts of their categories, tax to the tax account and the total is paid from the payment account, see Accounts
+func Journal(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, now time.Time) (File, error) {
+	loc := user.Location()
+	from, to := spec.Period(now, loc)
+	receipts, err := Receipts(ctx, dbc, user, spec, now)
+	if err != nil {
+		return File{}, err
+	}
+	accounts, err := LoadAccounts(ctx, dbc, user.ID)
+	if err != nil {
+		return File{}, err
+	}
+
+	var transactions []transaction
+	for _, receipt := range receipts {
+		if t, ok := toTransaction(receipt, accounts, user, loc); ok {
+			transactions = append(transactions, t)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "; Receipts from %s to %s\n", from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly))
+	extension := ""
+	switch spec.Format {
+	case FormatLedger, FormatHledger:
+		extension = map[Format]string{FormatLedger: "ledger", FormatHledger: "journal"}[spec.Format]
+		for _, t := range transactions {
+			writeLedger(&out, t, spec.Format == FormatHledger)
+		}
+	case FormatBeancount:
+		extension = "beancount"
+		writeBeancount(&out, transactions, user.EffectiveBaseCurrency(), from)
+	default:
+		return File{}, fmt.Errorf("%q isn't a journal format", spec.Format)
+	}
+	name := fmt.Sprintf("export_%s_%s.%s", from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly), extension)
+	return File{Name: name, MimeType: JOURNAL_MIME_TYPE, Data: []byte(out.String())}, nil
+}
+
+// The receipt as a transaction. Its total without tax is split between accounts of products in proportion to their totals,
+// so discounts without a category lower every account. Returns false for receipts without a total
+func toTransaction(receipt db.Receipt, accounts Accounts, user db.User, loc *time.Location) (transaction, bool) {
+	total := receipt.TotalWithTax
+	if total.IsZero() {
+		return transaction{}, false
+	}
+	currency := commodity(receipt.Currency)
+	if currency == "" {
+		currency = user.EffectiveBaseCurrency()
+	}
+	t := transaction{date: receipt.OccuredAt.In(loc), payee: MerchantName(receipt), narration: receipt.Summary, currency: currency}
+	if strings.TrimSpace(t.payee) == "" {
+		t.payee = stats.UNKNOWN_MERCHANT
+	}
+	t.meta = append(t.meta, [2]string{"receipt", fmt.Sprint(receipt.ID)})
+	if receipt.File != nil {
+		if receipt.File.ExposedFile != nil && receipt.File.ExposedFile.Key != "" {
+			t.meta = append(t.meta, [2]string{"file", fmt.Sprintf("%s/api/file/%s", config.Host(), receipt.File.ExposedFile.Key)})
+		} else {
+			t.meta = append(t.meta, [2]string{"file", receipt.File.OriginalName})
+		}
+	}
+
+	var taxes []posting
+	tax := decimal.Zero
+	for _, line := range receipt.TaxLines {
+		if !line.Amount.IsZero() {
+			taxes = append(taxes, posting{account: accounts.Tax, amount: line.Amount, currency: currency, meta: [][2]string{{"rate", line.Rate.String() + "%"}}})
+			tax = tax.Add(line.Amount)
+		}
+	}
+	if len(taxes) == 0 && !receipt.Tax.IsZero() {
+		taxes = []posting{{account: accounts.Tax, amount: receipt.Tax, currency: currency}}
+		tax = receipt.Tax
+	}
+	// tax which isn't smaller than the total is wrong, so it's left in the expenses
+	if tax.Abs().GreaterThanOrEqual(total.Abs()) {
+		taxes, tax = nil, decimal.Zero
+	}
+
+	t.postings = append(expensePostings(receipt, accounts, total.Sub(tax), currency), taxes...)
+	t.postings = append(t.postings, posting{account: accounts.Payment, amount: total.Neg(), currency: currency})
+	if currency != user.EffectiveBaseCurrency() && receipt.BaseCurrency == user.EffectiveBaseCurrency() && !receipt.BaseTotalWithTax.IsZero() {
+		rate := receipt.BaseTotalWithTax.Div(total).Abs().Round(PRICE_PLACES)
+		t.price, t.priceCurrency = &rate, commodity(receipt.BaseCurrency)
+	}
+	return t, true
+}
+
+// Splits the amount between accounts of products, biggest first, so the postings add up to the amount exactly
+func expensePostings(receipt db.Receipt, accounts Accounts, amount decimal.Decimal, currency string) []posting {
+	weights := map[string]decimal.Decimal{}
+	sum := decimal.Zero
+	for _, product := range receipt.Products {
+		if len(product.Categories) == 0 {
+			if product.LineType == db.ProductLineTypeDiscount {
+				continue
+			}
+			weights[accounts.ForLineType(product.LineType)] = weights[accounts.ForLineType(product.LineType)].Add(product.TotalWithTax)
+			sum = sum.Add(product.TotalWithTax)
+			continue
+		}
+		share := product.TotalWithTax.Div(decimal.NewFromInt(int64(len(product.Categories))))
+		for _, category := range product.Categories {
+			weights[accounts.ForCategory(category)] = weights[accounts.ForCategory(category)].Add(share)
+		}
+		sum = sum.Add(product.TotalWithTax)
+	}
+	if !sum.IsPositive() {
+		return []posting{{account: accounts.ForLineType(""), amount: amount, currency: currency}}
+	}
+
+	var postings []posting
+	allocated := decimal.Zero
+	for account, weight := range weights {
+		if !weight.IsPositive() {
+			continue
+		}
+		share := amount.Mul(weight).Div(sum).Round(2)
+		postings = append(postings, posting{account: account, amount: share, currency: currency})
+		allocated = allocated.Add(share)
+	}
+	sort.Slice(postings, func(i, j int) bool {
+		if !postings[i].amount.Abs().Equal(postings[j].amount.Abs()) {
+			return postings[i].amount.Abs().GreaterThan(postings[j].amount.Abs())
+		}
+		return postings[i].account < postings[j].account
+	})
+	// cents lost to rounding go to the biggest posting
+	postings[0].amount = postings[0].amount.Add(amount.Sub(allocated))
+	return postings
+}
+
+// The currency as a commodity every format accepts, e.g. "eur" as "EUR". Empty if nothing is left
+func commodity(currency string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, currency)
+}
+
+func (p posting) formatAmount() string {
+	return fmt.Sprintf("%s %s", p.amount.StringFixed(2), p.currency)
+}
+
+// Text on a single line, as every format reads a line at a time
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Writes the transaction for ledger, or for hledger, which reads the narration after "|" in the description.
+// Metadata are tags in comments, which both read
+func writeLedger(out *strings.Builder, t transaction, hledger bool) {
+	description := oneLine(t.payee)
+	narration := oneLine(t.narration)
+	if hledger && narration != "" {
+		description += " | " + narration
+	}
+	if t.price != nil {
+		fmt.Fprintf(out, "\nP %s %s %s %s\n", t.date.Format(time.DateOnly), t.currency, t.price.String(), t.priceCurrency)
+	}
+	fmt.Fprintf(out, "\n%s * %s\n", t.date.Format(time.DateOnly), strings.ReplaceAll(description, ";", ","))
+	if !hledger && narration != "" {
+		fmt.Fprintf(out, "    ; summary: %s\n", narration)
+	}
+	for _, m := range t.meta {
+		fmt.Fprintf(out, "    ; %s: %s\n", m[0], oneLine(m[1]))
+	}
+	for _, p := range t.postings {
+		fmt.Fprintf(out, "    %-*s  %s", ACCOUNT_WIDTH, p.account, p.formatAmount())
+		for _, m := range p.meta {
+			fmt.Fprintf(out, "  ; %s: %s", m[0], oneLine(m[1]))
+		}
+		out.WriteString("\n")
+	}
+}
+
+// Writes the transactions for beancount, which needs accounts opened before they are used and metadata as quoted strings
+func writeBeancount(out *strings.Builder, transactions []transaction, baseCurrency string, from time.Time) {
+	fmt.Fprintf(out, "option \"operating_currency\" \"%s\"\n\n", commodity(baseCurrency))
+	opened := map[string]bool{}
+	for _, t := range transactions {
+		for _, p := range t.postings {
+			opened[p.account] = true
+		}
+	}
+	accountNames := make([]string, 0, len(opened))
+	for account := range opened {
+		accountNames = append(accountNames, account)
+	}
+	sort.Strings(accountNames)
+	for _, account := range accountNames {
+		fmt.Fprintf(out, "%s open %s\n", from.Format(time.DateOnly), account)
+	}
+
+	for _, t := range transactions {
+		if t.price != nil {
+			fmt.Fprintf(out, "\n%s price %s %s %s\n", t.date.Format(time.DateOnly), t.currency, t.price.String(), t.priceCurrency)
+		}
+		fmt.Fprintf(out, "\n%s * %s %s\n", t.date.Format(time.DateOnly), quote(t.payee), quote(t.narration))
+		for _, m := range t.meta {
+			fmt.Fprintf(out, "  %s: %s\n", m[0], quote(m[1]))
+		}
+		for _, p := range t.postings {
+			fmt.Fprintf(out, "  %-*s  %s\n", ACCOUNT_WIDTH, p.account, p.formatAmount())
+			for _, m := range p.meta {
+				fmt.Fprintf(out, "    %s: %s\n", m[0], quote(m[1]))
+			}
+		}
+	}
+}
+
+func quote(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(oneLine(text)) + `"`
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/shopspring/decimal"
+)
+
+func journalReceipts() []db.Receipt {
+	groceries := db.Category{Title: "Groceries", Parent: &db.Category{Title: "Food"}}
+	groceries.ID = 2
+	household := db.Category{Title: "Household"}
+	household.ID = 3
+	receipts := []db.Receipt{
+		{
+			Currency:         "usd",
+			TotalWithTax:     decimal.RequireFromString("23.47"),
+			BaseCurrency:     "EUR",
+			BaseTotalWithTax: decimal.RequireFromString("21.59"),
+			OccuredAt:        time.Date(2025, 3, 14, 18, 30, 0, 0, time.UTC),
+			Summary:          `Weekly "shop"; milk and soap`,
+			TaxLines: []db.ReceiptTaxLine{
+				{Rate: decimal.RequireFromString("7"), Amount: decimal.RequireFromString("0.91")},
+				{Rate: decimal.RequireFromString("19"), Amount: decimal.RequireFromString("1.35")},
+			},
+			Products: []db.Product{
+				{TotalWithTax: decimal.RequireFromString("13.99"), Categories: []db.Category{groceries}},
+				{TotalWithTax: decimal.RequireFromString("8.48"), Categories: []db.Category{household, groceries}},
+				{TotalWithTax: decimal.RequireFromString("1.00"), LineType: db.ProductLineTypeDeposit},
+				{TotalWithTax: decimal.RequireFromString("-0.50"), LineType: db.ProductLineTypeDiscount},
+			},
+		},
+		{
+			Currency:     "EUR",
+			TotalWithTax: decimal.RequireFromString("9.99"),
+			BaseCurrency: "EUR",
+			OccuredAt:    time.Date(2025, 3, 15, 9, 0, 0, 0, time.UTC),
+			Products: []db.Product{
+				{TotalWithTax: decimal.RequireFromString("9.99"), Categories: []db.Category{household}},
+			},
+		},
+	}
+	for i := range receipts {
+		receipts[i].ID = uint(i + 1)
+	}
+	return receipts
+}
+
+func writeJournal(t *testing.T, format Format) string {
+	t.Helper()
+	user := db.User{BaseCurrency: "EUR"}
+	accounts := Accounts{Payment: DEFAULT_PAYMENT_ACCOUNT, Tax: DEFAULT_TAX_ACCOUNT, byCategory: map[uint]string{}}
+	var transactions []transaction
+	for _, receipt := range journalReceipts() {
+		if tr, ok := toTransaction(receipt, accounts, user, time.UTC); ok {
+			transactions = append(transactions, tr)
+		}
+	}
+	var out strings.Builder
+	if format == FormatBeancount {
+		writeBeancount(&out, transactions, user.EffectiveBaseCurrency(), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	} else {
+		for _, tr := range transactions {
+			writeLedger(&out, tr, format == FormatHledger)
+		}
+	}
+	return out.String()
+}
+
+// Sums of the postings of every transaction in the journal per currency, read back from the text
+func postingSums(t *testing.T, journal string) []map[string]decimal.Decimal {
+	t.Helper()
+	var sums []map[string]decimal.Decimal
+	for _, line := range strings.Split(journal, "\n") {
+		if line == "" || line[0] >= '0' && line[0] <= '9' && strings.Contains(line, " * ") {
+			if line != "" {
+				sums = append(sums, map[string]decimal.Decimal{})
+			}
+			continue
+		}
+		fields := strings.Fields(strings.SplitN(line, ";", 2)[0])
+		if len(fields) != 3 || line[0] != ' ' || len(sums) == 0 {
+			continue
+		}
+		amount, err := decimal.NewFromString(fields[1])
+		if err != nil {
+			t.Fatalf("posting %q has no amount: %v", line, err)
+		}
+		sums[len(sums)-1][fields[2]] = sums[len(sums)-1][fields[2]].Add(amount)
+	}
+	return sums
+}
+
+func TestJournalBalances(t *testing.T) {
+	for _, format := range []Format{FormatLedger, FormatHledger, FormatBeancount} {
+		t.Run(string(format), func(t *testing.T) {
+			journal := writeJournal(t, format)
+			sums := postingSums(t, journal)
+			if len(sums) != 2 {
+				t.Fatalf("expected 2 transactions, got %d in\n%s", len(sums), journal)
+			}
+			for i, sum := range sums {
+				if len(sum) != 1 {
+					t.Errorf("transaction %d posts %d currencies, expected its own only:\n%s", i+1, len(sum), journal)
+				}
+				for currency, total := range sum {
+					if !total.IsZero() {
+						t.Errorf("transaction %d is off by %s %s:\n%s", i+1, total, currency, journal)
+					}
+				}
+			}
+			if !strings.Contains(journal, "USD 0.919898 EUR") {
+				t.Errorf("expected a price of USD in EUR:\n%s", journal)
+			}
+		})
+	}
+}
+
+// Checks the journals with the tools of the formats, when they are installed
+func TestJournalChecks(t *testing.T) {
+	checks := []struct {
+		format Format
+		tool   string
+		args   []string
+	}{
+		{FormatLedger, "ledger", []string{"balance", "-f"}},
+		{FormatHledger, "hledger", []string{"check", "-f"}},
+		{FormatBeancount, "bean-check", nil},
+	}
+	for _, check := range checks {
+		t.Run(check.tool, func(t *testing.T) {
+			if _, err := exec.LookPath(check.tool); err != nil {
+				t.Skipf("%s isn't installed", check.tool)
+			}
+			path := filepath.Join(t.TempDir(), "export."+string(check.format))
+			if err := os.WriteFile(path, []byte(writeJournal(t, check.format)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			output, err := exec.Command(check.tool, append(check.args, path)...).CombinedOutput()
+			if err != nil {
+				t.Errorf("%s failed: %v\n%s", check.tool, err, output)
+			}
+		})
+	}
+}
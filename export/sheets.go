@@ -0,0 +1,135 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// A table of the export. Cells are strings, numbers, decimals or times, nil for empty ones
+type Sheet struct {
+	Name   string
+	Header []string
+	Rows   [][]any
+}
+
+// Sheets of receipts, products and totals per category. Name is the file name without an extension
+type Workbook struct {
+	Name   string
+	Sheets []Sheet
+}
+
+// Builds sheets of receipts of the user as the spec tells. Times are in the location of the user
+func Build(ctx context.Context, dbc *gorm.DB, user db.User, spec Spec, now time.Time) (Workbook, error) {
+	loc := user.Location()
+	from, to := spec.Period(now, loc)
+	receipts, err := Receipts(ctx, dbc, user, spec, now)
+	if err != nil {
+		return Workbook{}, err
+	}
+
+	receiptSheet := Sheet{Name: "Receipts", Header: []string{"ID", "Date", "Merchant", "Summary", "Categories", "Currency", "Total before tax", "Tax", "Total", "Base currency", "Base total", "Source", "File"}}
+	productSheet := Sheet{Name: "Products", Header: []string{"Receipt ID", "Date", "Merchant", "Line", "Type", "Title", "Categories", "Quantity", "Unit", "Unit price", "Discount", "Total before tax", "Tax rate, %", "Tax", "Total", "Currency", "Base total", "Base currency"}}
+	for _, receipt := range receipts {
+		occuredAt := receipt.OccuredAt.In(loc)
+		var receiptCategories []string
+		for _, product := range receipt.Products {
+			productCategories := ProductCategories(product)
+			receiptCategories = append(receiptCategories, productCategories...)
+			if spec.Category != "" && !ProductWithin(product, spec.Category) {
+				continue
+			}
+			productSheet.Rows = append(productSheet.Rows, []any{
+				receipt.ID, occuredAt, MerchantName(receipt), product.Line, string(product.LineType), product.Title, strings.Join(productCategories, "; "),
+				product.Quantity, product.Unit, product.UnitPrice, product.Discount, product.TotalBeforeTax, product.TaxRate, product.Tax, product.TotalWithTax,
+				receipt.Currency, product.BaseTotalWithTax, receipt.BaseCurrency,
+			})
+		}
+		fileName := ""
+		if receipt.File != nil {
+			fileName = receipt.File.OriginalName
+		}
+		receiptSheet.Rows = append(receiptSheet.Rows, []any{
+			receipt.ID, occuredAt, MerchantName(receipt), receipt.Summary, strings.Join(lo.Uniq(receiptCategories), "; "),
+			receipt.Currency, receipt.TotalBeforeTax, receipt.Tax, receipt.TotalWithTax, receipt.BaseCurrency, receipt.BaseTotalWithTax,
+			string(receipt.Source), fileName,
+		})
+	}
+
+	categorySheet, err := categorySummary(ctx, dbc, user, receipts, spec.Category, from, to)
+	if err != nil {
+		return Workbook{}, err
+	}
+
+	name := fmt.Sprintf("export_%s_%s", from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly))
+	return Workbook{Name: name, Sheets: []Sheet{receiptSheet, productSheet, categorySheet}}, nil
+}
+
+// Totals per category of the receipts in the base currency of the user, split as spending summaries split them.
+// Receipts which aren't converted to the base currency yet are left out
+func categorySummary(ctx context.Context, dbc *gorm.DB, user db.User, receipts []db.Receipt, category string, from time.Time, to time.Time) (Sheet, error) {
+	sheet := Sheet{Name: "Categories", Header: []string{"Category", "Total", "Currency", "Share, %"}}
+	perReceipt, err := stats.ReceiptCategoryTotalsBetween(ctx, dbc, user, from, to)
+	if err != nil {
+		return sheet, err
+	}
+	totals := map[string]decimal.Decimal{}
+	sum := decimal.Zero
+	for _, receipt := range receipts {
+		for path, total := range perReceipt[receipt.ID] {
+			if category != "" && !categories.Within(path, category) {
+				continue
+			}
+			totals[path] = totals[path].Add(total)
+			sum = sum.Add(total)
+		}
+	}
+	paths := lo.Keys(totals)
+	sort.Slice(paths, func(i, j int) bool {
+		if !totals[paths[i]].Equal(totals[paths[j]]) {
+			return totals[paths[i]].GreaterThan(totals[paths[j]])
+		}
+		return paths[i] < paths[j]
+	})
+	for _, path := range paths {
+		share := decimal.Zero
+		if sum.IsPositive() {
+			share = totals[path].Div(sum).Mul(decimal.NewFromInt(100)).Round(1)
+		}
+		sheet.Rows = append(sheet.Rows, []any{path, totals[path].Round(2), user.EffectiveBaseCurrency(), share})
+	}
+	sheet.Rows = append(sheet.Rows, []any{"Total", sum.Round(2), user.EffectiveBaseCurrency(), nil})
+	return sheet, nil
+}
+
+// Files of the workbook: a single xlsx file, or a csv file per sheet
+func (workbook Workbook) Files(format Format) ([]File, error) {
+	switch format {
+	case FormatXlsx:
+		data, err := writeXlsx(workbook.Sheets)
+		if err != nil {
+			return nil, err
+		}
+		return []File{{Name: workbook.Name + ".xlsx", MimeType: XLSX_MIME_TYPE, Data: data}}, nil
+	case FormatCsv:
+		var files []File
+		for _, sheet := range workbook.Sheets {
+			data, err := writeCsv(sheet)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, File{Name: fmt.Sprintf("%s_%s.csv", workbook.Name, strings.ToLower(sheet.Name)), MimeType: CSV_MIME_TYPE, Data: data})
+		}
+		return files, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Receipts before this date are considered to have no date
+var noDateBefore = time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type BackfillResult struct {
+	Updated int
+	// Receipts parsed before LLM responses were stored, which keep an empty currency and get the time of the message as the date
+	WithoutResponse int
+}
+
+// Fills Currency and OccuredAt of receipts from stored LLM responses, falling back to the time of the message
+func BackfillReceipts(dbc *gorm.DB, logger *slog.Logger) (BackfillResult, error) {
+	var result BackfillResult
+	var receipts []db.Receipt
+	if err := dbc.Preload("User").Preload("File.Message").
+		Where("currency IS NULL OR currency = '' OR occured_at IS NULL OR occured_at < ?", noDateBefore).
+		Order("id asc").Find(&receipts).Error; err != nil {
+		return result, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	logger.With("receipts", len(receipts)).Info("backfilling receipts")
+
+	parsedPerFile := map[uint][]Receipt4Llm{}
+	for _, receipt := range receipts {
+		lgr := logger.With(log.RECEIPT_ID, receipt.ID)
+		loc := time.UTC
+		if receipt.User != nil {
+			loc = receipt.User.Location()
+		}
+		fallback := receipt.CreatedAt
+		var parsed Receipt4Llm
+		stored := false
+		if receipt.FileID != nil {
+			if receipt.File != nil && receipt.File.Message != nil {
+				fallback = receipt.File.Message.CreatedAt
+			}
+			parsedReceipts, ok := parsedPerFile[*receipt.FileID]
+			if !ok {
+				var err error
+				parsedReceipts, err = parsedReceiptsOf(dbc, *receipt.FileID)
+				if err != nil {
+					lgr.With(log.ERROR, err).Warn("failed to load stored llm response")
+				}
+				parsedPerFile[*receipt.FileID] = parsedReceipts
+			}
+			index, err := receiptIndex(dbc, receipt)
+			if err != nil {
+				return result, err
+			}
+			if index < len(parsedReceipts) {
+				parsed, stored = parsedReceipts[index], true
+			}
+		}
+		if !stored {
+			result.WithoutResponse++
+		}
+
+		changes := map[string]any{}
+		if receipt.Currency == "" && NormalizeCurrency(parsed.Currency) != "" {
+			changes["currency"] = NormalizeCurrency(parsed.Currency)
+		}
+		if receipt.OccuredAt.Before(noDateBefore) {
+			changes["occured_at"] = ReceiptOccuredAt(parsed.OccuredAt, loc, fallback)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if err := dbc.Model(&receipt).Updates(changes).Error; err != nil {
+			return result, fmt.Errorf("updating receipt %d: %w", receipt.ID, errors.WithStack(err))
+		}
+		lgr.With("changes", changes).Debug("receipt backfilled")
+		result.Updated++
+	}
+	logger.With("updated", result.Updated).With("without_response", result.WithoutResponse).Info("receipts backfilled")
+	return result, nil
+}
+
+// Receipts from the latest stored LLM response for the file
+func parsedReceiptsOf(dbc *gorm.DB, fileID uint) ([]Receipt4Llm, error) {
+	var response db.Message
+	if err := dbc.Where("parsed_file_id = ? AND direction = ?", fileID, db.MessageDirectionLlmToSystem).Order("id desc").First(&response).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	var parsed File4Llm
+	if err := json.Unmarshal([]byte(response.Text), &parsed); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return parsed.Receipts, nil
+}
+
+// Position of the receipt among receipts of its file, which matches the position in LLM response
+func receiptIndex(dbc *gorm.DB, receipt db.Receipt) (int, error) {
+	var ids []uint
+	if err := dbc.Model(&db.Receipt{}).Where("file_id = ?", *receipt.FileID).Order("id asc").Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("fetching receipts of file: %w", errors.WithStack(err))
+	}
+	index := sort.Search(len(ids), func(i int) bool { return ids[i] >= receipt.ID })
+	return index, nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/EPecherkin/catty-counting/charts"
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/prompts"
+	"github.com/EPecherkin/catty-counting/reply"
 	"github.com/EPecherkin/catty-counting/texts"
 	"github.com/openai/openai-go/v2"
 	"github.com/pkg/errors"
@@ -23,6 +25,8 @@ type Chat struct {
 	oClient *openai.Client
 	deps    deps.Deps
 	history []openai.ChatCompletionMessageParamUnion
+	// Charts requested with tools, sent after the reply
+	charts []charts.Chart
 }
 
 func newChat(userID uint, oClient *openai.Client, deps deps.Deps) *Chat {
@@ -31,7 +35,7 @@ func newChat(userID uint, oClient *openai.Client, deps deps.Deps) *Chat {
 	return &Chat{userID: userID, oClient: oClient, deps: deps}
 }
 
-func (chat *Chat) Talk(ctx context.Context, message db.Message, responseChan chan<- string) {
+func (chat *Chat) Talk(ctx context.Context, message db.Message, responseChan chan<- reply.Chunk) {
 	chat.deps.Logger = chat.deps.Logger.With(log.MESSAGE_ID, message.ID)
 	chat.deps.Logger.Debug("starting talking")
 	defer func() {
@@ -53,17 +57,21 @@ func (chat *Chat) Talk(ctx context.Context, message db.Message, responseChan cha
 
 	if err := chat.handleFiles(ctx, &message); err != nil {
 		chat.deps.Logger.With(log.ERROR, err).Error("failed to process provided files")
-		responseChan <- texts.FAILED_TRY_AGAIN
+		responseChan <- reply.Text(texts.FAILED_TRY_AGAIN)
 		return
 	}
 
 	response, err := chat.handleResponse(ctx, &message)
 	if err != nil {
 		chat.deps.Logger.With(log.ERROR, err).Error("failed to handle response")
-		responseChan <- texts.FAILED_TRY_AGAIN
+		responseChan <- reply.Text(texts.FAILED_TRY_AGAIN)
 		return
 	}
-	responseChan <- response
+	responseChan <- reply.Text(response)
+	for _, chart := range chat.charts {
+		responseChan <- reply.Chart(chart)
+	}
+	chat.charts = nil
 }
 
 func (chat *Chat) loadHistory() error {
@@ -0,0 +1,88 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/rules"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	RECATEGORIZE_PRODUCT_TOOL = "recategorize_product"
+)
+
+type recategorizeProductArgs struct {
+	ProductID uint   `json:"product_id"`
+	Category  string `json:"category"`
+}
+
+type recategorizeProductResult struct {
+	ProductID uint   `json:"product_id"`
+	Title     string `json:"title"`
+	Category  string `json:"category"`
+	Rule      string `json:"rule,omitempty"`
+}
+
+func recategorizeProductDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	userCategories, err := categories.ForUser(ctx, chat.deps.DBC, chat.userID)
+	if err != nil {
+		return shared.FunctionDefinitionParam{}, fmt.Errorf("fetching categories: %w", err)
+	}
+	categoryTitles := lo.Map(userCategories, func(category db.Category, _ int) string { return categories.Path(category) })
+
+	return shared.FunctionDefinitionParam{
+		Name:        RECATEGORIZE_PRODUCT_TOOL,
+		Description: openai.String(`Moves a product of a receipt to another category, when the user says it's categorized wrong. Products with the same title from the same store get this category from now on.`),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"product_id": map[string]any{"type": "integer", "description": "id of the product in the receipt"},
+				"category":   map[string]any{"type": "string", "enum": categoryTitles},
+			},
+			"required": []string{"product_id", "category"},
+		},
+	}, nil
+}
+
+// Moves the product to the category and learns a rule from the correction
+func recategorizeProduct(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	var args recategorizeProductArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("unmarshal arguments: %w", errors.WithStack(err))
+	}
+	category, err := categories.Find(ctx, chat.deps.DBC, chat.userID, args.Category)
+	if err != nil {
+		return "", err
+	}
+	if category == nil {
+		return "", fmt.Errorf("no category %q", args.Category)
+	}
+
+	product, rule, err := rules.Recategorize(ctx, chat.deps.DBC, chat.userID, args.ProductID, *category)
+	if err != nil {
+		return "", err
+	}
+	if product == nil {
+		return "", fmt.Errorf("no product %d", args.ProductID)
+	}
+	logger := chat.deps.Logger.With(log.PRODUCT_ID, product.ID).With(log.CATEGORY_ID, category.ID)
+	logger.Debug("Product recategorized")
+
+	result := recategorizeProductResult{ProductID: product.ID, Title: product.Title, Category: categories.Path(*category)}
+	if rule != nil {
+		result.Rule = rule.Pattern
+	}
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("marshal result: %w", errors.WithStack(err))
+	}
+	return string(marshaled), nil
+}
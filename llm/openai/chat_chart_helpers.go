@@ -0,0 +1,69 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/charts"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+)
+
+const (
+	SPENDING_CHART_TOOL = "spending_chart"
+)
+
+type chartArgs struct {
+	Kind     string `json:"kind"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Category string `json:"category"`
+}
+
+func spendingChartDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	description := fmt.Sprintf(`Shows the user a chart of their spending in the base currency after your reply: "pie" by category for a period, the current month by default, "bar" per month, the last %d months by default, or "line" per week, the last %d weeks by default. Returns the charted values. Call it when the user asks to see, chart or plot spending. Today is %s.`, charts.DEFAULT_MONTHS, charts.DEFAULT_WEEKS, time.Now().Format("Monday, 2006-01-02"))
+	return shared.FunctionDefinitionParam{
+		Name:        SPENDING_CHART_TOOL,
+		Description: openai.String(description),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"kind":     map[string]any{"type": "string", "enum": []string{string(charts.KindPie), string(charts.KindBar), string(charts.KindLine)}},
+				"from":     map[string]any{"type": "string", "format": "date", "description": "first day of the period, YYYY-MM-DD, empty for the default"},
+				"to":       map[string]any{"type": "string", "format": "date", "description": "last day of the period, inclusive, YYYY-MM-DD, empty for the default"},
+				"category": map[string]any{"type": "string", "description": "category path to chart with its subcategories, e.g. Food > Groceries, empty for all spending"},
+			},
+			"required": []string{"kind"},
+		},
+	}, nil
+}
+
+func spendingChart(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	var args chartArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("unmarshal chart: %w", errors.WithStack(err))
+	}
+	var user db.User
+	if err := chat.deps.DBC.WithContext(ctx).First(&user, chat.userID).Error; err != nil {
+		return "", fmt.Errorf("find user: %w", errors.WithStack(err))
+	}
+	spec, err := charts.ParseSpec(url.Values{"kind": {args.Kind}, "from": {args.From}, "to": {args.To}, "category": {args.Category}}, user.Location())
+	if err != nil {
+		return "", err
+	}
+	chart, err := charts.Build(ctx, chat.deps.DBC, user, spec, time.Now())
+	if err != nil {
+		return "", err
+	}
+	chat.charts = append(chat.charts, chart)
+	result, err := json.Marshal(chart)
+	if err != nil {
+		return "", fmt.Errorf("marshal chart: %w", errors.WithStack(err))
+	}
+	return string(result), nil
+}
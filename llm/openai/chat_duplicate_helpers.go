@@ -0,0 +1,88 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	RESOLVE_DUPLICATE_TOOL = "resolve_duplicate"
+)
+
+type resolveDuplicateArgs struct {
+	ReceiptID uint `json:"receipt_id"`
+	KeepBoth  bool `json:"keep_both"`
+}
+
+func resolveDuplicateDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	return shared.FunctionDefinitionParam{
+		Name:        RESOLVE_DUPLICATE_TOOL,
+		Description: openai.String(`Applies the decision of the user about a receipt with "duplicate_of": keeps both receipts, or removes the new one and keeps the earlier. Call it only after the user answered.`),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"receipt_id": map[string]any{"type": "integer", "description": "id of the receipt with duplicate_of"},
+				"keep_both":  map[string]any{"type": "boolean", "description": "true if these are different purchases and both receipts should be kept"},
+			},
+			"required": []string{"receipt_id", "keep_both"},
+		},
+	}, nil
+}
+
+// Unflags the receipt, or removes it with its products, tax lines and anomalies in favor of the earlier one
+func resolveDuplicate(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	var args resolveDuplicateArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("unmarshal arguments: %w", errors.WithStack(err))
+	}
+
+	var receipt db.Receipt
+	if err := chat.deps.DBC.WithContext(ctx).Where("user_id = ?", chat.userID).First(&receipt, args.ReceiptID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("no receipt %d", args.ReceiptID)
+		}
+		return "", fmt.Errorf("find receipt: %w", errors.WithStack(err))
+	}
+	if receipt.DuplicateOfID == nil {
+		return "", fmt.Errorf("receipt %d isn't flagged as a duplicate", receipt.ID)
+	}
+	logger := chat.deps.Logger.With(log.RECEIPT_ID, receipt.ID).With("duplicate_of", *receipt.DuplicateOfID)
+
+	if args.KeepBoth {
+		if err := chat.deps.DBC.WithContext(ctx).Model(&receipt).Update("duplicate_of_id", nil).Error; err != nil {
+			return "", fmt.Errorf("unflag receipt: %w", errors.WithStack(err))
+		}
+		logger.Debug("Duplicate kept")
+		return fmt.Sprintf(`{"kept": %d}`, receipt.ID), nil
+	}
+
+	err := chat.deps.DBC.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		products := tx.Model(&db.Product{}).Select("id").Where("receipt_id = ?", receipt.ID)
+		if err := tx.Where("product_id IN (?)", products).Delete(&db.ProductCategory{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&db.Product{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&db.ReceiptTaxLine{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		if err := tx.Where("receipt_id = ?", receipt.ID).Delete(&db.Anomaly{}).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(tx.Delete(&receipt).Error)
+	})
+	if err != nil {
+		return "", fmt.Errorf("remove receipt: %w", err)
+	}
+	logger.Debug("Duplicate removed")
+	return fmt.Sprintf(`{"removed": %d, "kept": %d}`, receipt.ID, *receipt.DuplicateOfID), nil
+}
@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/llm"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/merchants"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	RECORD_EXPENSE_TOOL = "record_expense"
+)
+
+func recordExpenseDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	userCategories, err := categories.ForUser(ctx, chat.deps.DBC, chat.userID)
+	if err != nil {
+		return shared.FunctionDefinitionParam{}, fmt.Errorf("fetching categories: %w", err)
+	}
+	categoryTitles := lo.Map(userCategories, func(category db.Category, _ int) string { return categories.Path(category) })
+
+	description := fmt.Sprintf(`Records an expense the user describes in plain text without a receipt, e.g. "taxi 23.50 yesterday". Today is %s. Call it only when the user clearly reports a payment they made.`, time.Now().Format("Monday, 2006-01-02"))
+	return shared.FunctionDefinitionParam{
+		Name:        RECORD_EXPENSE_TOOL,
+		Description: openai.String(description),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"title":          map[string]any{"type": "string", "description": "what was paid for, e.g. Taxi"},
+				"details":        map[string]any{"type": "string", "description": "any other details from the user's message"},
+				"total_with_tax": map[string]any{"type": "number", "description": "amount paid"},
+				"currency":       map[string]any{"type": "string", "description": "3 letter currency code. Ask the user if unsure"},
+				"origin":         map[string]any{"type": "string", "description": "where it was paid, if mentioned"},
+				"occured_at":     map[string]any{"type": "string", "format": "date-time", "description": "when it was paid, RFC3339 local time. Omit if not mentioned"},
+				"categories": map[string]any{
+					"type":        "array",
+					"description": "1-4 categories of the expense",
+					"items":       map[string]any{"type": "string", "enum": categoryTitles},
+				},
+			},
+			"required": []string{"title", "total_with_tax", "categories"},
+		},
+	}, nil
+}
+
+// Creates a manual receipt with a single product from the expense described by the user
+func recordExpense(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	var expense llm.Expense4Llm
+	if err := json.Unmarshal([]byte(arguments), &expense); err != nil {
+		return "", fmt.Errorf("unmarshal expense: %w", errors.WithStack(err))
+	}
+	if strings.TrimSpace(expense.Title) == "" {
+		return "", errors.New("expense title is empty")
+	}
+	if !expense.TotalWithTax.IsPositive() {
+		return "", errors.New("expense amount must be positive")
+	}
+
+	var user db.User
+	if err := chat.deps.DBC.WithContext(ctx).First(&user, chat.userID).Error; err != nil {
+		return "", fmt.Errorf("find user: %w", errors.WithStack(err))
+	}
+
+	merchant := chat.resolveMerchant(ctx, merchants.FromOrigin(expense.Origin), chat.deps.Logger)
+	receipt := db.Receipt{
+		UserID:         chat.userID,
+		Source:         db.ReceiptSourceManual,
+		TotalBeforeTax: expense.TotalWithTax,
+		TotalWithTax:   expense.TotalWithTax,
+		Currency:       llm.NormalizeCurrency(expense.Currency),
+		Origin:         expense.Origin,
+		Details:        expense.Details,
+		Summary:        expense.Title,
+		OccuredAt:      llm.ReceiptOccuredAt(expense.OccuredAt, user.Location(), message.CreatedAt),
+	}
+	if merchant != nil {
+		receipt.MerchantID = &merchant.ID
+	}
+	if err := chat.deps.DBC.WithContext(ctx).Create(&receipt).Error; err != nil {
+		return "", fmt.Errorf("create receipt: %w", errors.WithStack(err))
+	}
+	logger := chat.deps.Logger.With(log.RECEIPT_ID, receipt.ID)
+	logger.Debug("Manual receipt created")
+	receipt.Merchant = merchant
+
+	product := db.Product{
+		ReceiptID:      receipt.ID,
+		LineType:       db.ProductLineTypeItem,
+		Title:          expense.Title,
+		Details:        expense.Details,
+		Quantity:       decimal.NewFromInt(1),
+		UnitPrice:      expense.TotalWithTax,
+		TotalBeforeTax: expense.TotalWithTax,
+		TotalWithTax:   expense.TotalWithTax,
+	}
+	if err := chat.deps.DBC.WithContext(ctx).Create(&product).Error; err != nil {
+		return "", fmt.Errorf("create product: %w", errors.WithStack(err))
+	}
+	logger = logger.With(log.PRODUCT_ID, product.ID)
+	logger.Debug("Product created")
+
+	if !chat.applyRule(ctx, &product, receipt.MerchantID, logger) {
+		model := chat.trainClassifier(ctx, logger)
+		for _, title := range expense.Categories {
+			chat.attachCategory(ctx, &product, title, db.ProductCategorySourceLlm, model, logger)
+		}
+		if len(product.Categories) == 0 {
+			chat.classifyProduct(ctx, &product, model, logger)
+		}
+	}
+	receipt.Products = append(receipt.Products, product)
+	chat.convertReceipt(ctx, &receipt, user, logger)
+	chat.checkBudgets(ctx, user, logger)
+	chat.checkRecurring(ctx, user, logger)
+	chat.checkAnomalies(ctx, user, []db.Receipt{receipt}, logger)
+
+	result, err := json.Marshal(llm.DbReceiptToLlm(receipt))
+	if err != nil {
+		return "", fmt.Errorf("marshal receipt: %w", errors.WithStack(err))
+	}
+	return string(result), nil
+}
@@ -5,15 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/EPecherkin/catty-counting/anomalies"
+	"github.com/EPecherkin/catty-counting/budgets"
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/classifier"
 	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/currency"
 	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/dedup"
 	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/merchants"
 	"github.com/EPecherkin/catty-counting/prompts"
+	"github.com/EPecherkin/catty-counting/recurring"
+	"github.com/EPecherkin/catty-counting/rules"
 	"github.com/google/uuid"
 	"github.com/openai/openai-go/v2"
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -25,22 +38,8 @@ func (chat *Chat) handleFiles(ctx context.Context, message *db.Message) error {
 	}
 	chat.deps.Logger.With("files", len(message.Files)).Debug("handling files")
 	for i, file := range message.Files {
-		logger := chat.deps.Logger.With(log.FILE_ID, file.ID)
-
-		fileURL, err := chat.exposeFile(&file)
-		if err != nil {
-			return fmt.Errorf("exposing file: %w", err)
-		}
-		logger.Debug("file exposed", "url", fileURL)
-
-		// TODO: retry
-		parsedData, err := chat.parseFile(ctx, fileURL, logger)
-		if err != nil {
-			return fmt.Errorf("parsing file: %w", err)
-		}
-
-		if err := chat.updateFileWithParsed(&file, parsedData, logger); err != nil {
-			return fmt.Errorf("saving parsed data on file: %w", err)
+		if err := chat.handleFile(ctx, &file); err != nil {
+			return err
 		}
 		message.Files[i] = file
 	}
@@ -65,6 +64,31 @@ func (chat *Chat) handleFiles(ctx context.Context, message *db.Message) error {
 	return nil
 }
 
+// Exposes, extracts and persists a single file
+func (chat *Chat) handleFile(ctx context.Context, file *db.File) error {
+	logger := chat.deps.Logger.With(log.FILE_ID, file.ID)
+
+	fileURL, err := chat.exposeFile(file)
+	if err != nil {
+		return fmt.Errorf("exposing file: %w", err)
+	}
+	logger.Debug("file exposed", "url", fileURL)
+
+	parsedData, ok := chat.reuseParsedSameFile(ctx, file, logger)
+	if !ok {
+		// TODO: retry
+		parsedData, err = chat.parseFile(ctx, file, fileURL, logger)
+		if err != nil {
+			return fmt.Errorf("parsing file: %w", err)
+		}
+	}
+
+	if err := chat.updateFileWithParsed(ctx, file, parsedData, logger); err != nil {
+		return fmt.Errorf("saving parsed data on file: %w", err)
+	}
+	return nil
+}
+
 // Make file available for API retrieval. Returns API URL for downloading the file
 func (chat *Chat) exposeFile(file *db.File) (string, error) {
 	var key string
@@ -81,25 +105,79 @@ func (chat *Chat) exposeFile(file *db.File) (string, error) {
 	return fmt.Sprintf("%s/api/file/%s", config.Host(), key), nil
 }
 
-// Use OpenAI to extract structured JSON from the file URL.
-func (chat *Chat) parseFile(ctx context.Context, fileURL string, logger *slog.Logger) (llm.File4Llm, error) {
+// Takes the parsed data of the same file sent before, so the copy isn't sent to LLM again.
+// Returns false if the file is new or wasn't parsed before
+func (chat *Chat) reuseParsedSameFile(ctx context.Context, file *db.File, logger *slog.Logger) (llm.File4Llm, bool) {
+	var parsedFile llm.File4Llm
+	if err := dedup.HashFile(ctx, chat.deps.Files, file); err != nil {
+		logger.With(log.ERROR, err).Warn("failed to hash file")
+		return parsedFile, false
+	}
+	if err := chat.deps.DBC.Model(file).Update("content_hash", file.ContentHash).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to save file hash")
+	}
+	same, err := dedup.SameFile(ctx, chat.deps.DBC, chat.userID, *file)
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to look for the same file")
+		return parsedFile, false
+	}
+	if same == nil {
+		return parsedFile, false
+	}
+	logger = logger.With("same_file_id", same.ID)
+
+	var response db.Message
+	if err := chat.deps.DBC.Where("parsed_file_id = ? AND direction = ?", same.ID, db.MessageDirectionLlmToSystem).Order("id desc").First(&response).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to load parsing response of the same file")
+		}
+		return parsedFile, false
+	}
+	if err := json.Unmarshal([]byte(response.Text), &parsedFile); err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to unmarshal parsing response of the same file")
+		return parsedFile, false
+	}
+
+	responseMessage := db.Message{UserID: chat.userID, Text: response.Text, Direction: db.MessageDirectionLlmToSystem, ParsedFileID: &file.ID}
+	if err := chat.deps.DBC.Create(&responseMessage).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to save parsing response")
+	}
+	logger.Debug("File was sent before, reusing parsed data")
+	return parsedFile, true
+}
+
+// Use OpenAI to extract structured JSON from the file URL. Text files, like e-receipts from emails, are sent as text
+func (chat *Chat) parseFile(ctx context.Context, file *db.File, fileURL string, logger *slog.Logger) (llm.File4Llm, error) {
 	logger.Debug("sending file for parsing")
 	var parsedFile llm.File4Llm
 
+	filePart := openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: fileURL, Detail: "auto"})
+	if strings.HasPrefix(file.MimeType, "text/") {
+		content, err := chat.deps.Files.ReadAll(ctx, file.BlobKey)
+		if err != nil {
+			return parsedFile, fmt.Errorf("reading text file: %w", errors.WithStack(err))
+		}
+		filePart = openai.TextContentPart(string(content))
+	}
+
+	prompt, err := prompts.ParseFile(ctx, chat.deps.DBC, chat.userID)
+	if err != nil {
+		return parsedFile, fmt.Errorf("building parse prompt: %w", err)
+	}
+
 	params := openai.ChatCompletionNewParams{
 		Model: VISION_MODEL,
 		Messages: []openai.ChatCompletionMessageParamUnion{
 			openai.UserMessage(
 				[]openai.ChatCompletionContentPartUnionParam{
-					openai.TextContentPart(prompts.PROMPT_PARSE_FILE),
-					openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: fileURL, Detail: "auto"}),
+					openai.TextContentPart(prompt),
+					filePart,
 				},
 			),
 		},
 	}
 
-	// TODO: preserve request/response from OpenAI as db.Message
-	// Use MessageDirectionSystemToLlm / MessageDirectionLlmToSystem
+	// TODO: preserve request to OpenAI as db.Message with MessageDirectionSystemToLlm
 	resp, err := chat.oClient.Chat.Completions.New(ctx, params)
 	if err != nil {
 		return parsedFile, fmt.Errorf("extraction call failed: %w", errors.WithStack(err))
@@ -112,6 +190,12 @@ func (chat *Chat) parseFile(ctx context.Context, fileURL string, logger *slog.Lo
 	}
 	logger.With("response", assistantText).Debug("Parsing request complete")
 
+	// kept to re-derive receipts later, e.g. when new fields are persisted
+	responseMessage := db.Message{UserID: chat.userID, Text: assistantText, Direction: db.MessageDirectionLlmToSystem, ParsedFileID: &file.ID}
+	if err := chat.deps.DBC.Create(&responseMessage).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to save parsing response")
+	}
+
 	if err := json.Unmarshal([]byte(assistantText), &parsedFile); err != nil {
 		return parsedFile, fmt.Errorf("unmarshal parsed data: %w", errors.WithStack(err))
 	}
@@ -121,38 +205,82 @@ func (chat *Chat) parseFile(ctx context.Context, fileURL string, logger *slog.Lo
 }
 
 // Writes receipts/products/categories to DB and returns created receipts count
-func (chat *Chat) updateFileWithParsed(file *db.File, parsedFile llm.File4Llm, logger *slog.Logger) error {
+func (chat *Chat) updateFileWithParsed(ctx context.Context, file *db.File, parsedFile llm.File4Llm, logger *slog.Logger) error {
 	file.Summary = parsedFile.Summary
 	if err := chat.deps.DBC.Save(file).Error; err != nil {
 		// TODO: handle
 		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to update file's summary")
 	}
 
+	user, fallbackOccuredAt := chat.receiptDefaults(file, logger)
+	model := chat.trainClassifier(ctx, logger)
 	for _, r := range parsedFile.Receipts {
+		var merchant *db.Merchant
+		if !llm.IsUnsure(r.Confidence, "merchant", "origin") {
+			merchant = chat.resolveMerchant(ctx, merchantDetails(r), logger)
+		}
 		receipt := db.Receipt{
-			FileID:         file.ID,
-			TotalBeforeTax: r.TotalBeforeTax,
-			Tax:            r.Tax,
-			TotalWithTax:   r.TotalWithTax,
-			Origin:         r.Origin,
-			Recipient:      r.Recipient,
-			Details:        r.Details,
-			Summary:        r.Summary,
+			UserID:          chat.userID,
+			FileID:          &file.ID,
+			Source:          db.ReceiptSourceFile,
+			TotalBeforeTax:  r.TotalBeforeTax,
+			Tax:             r.Tax,
+			TotalWithTax:    r.TotalWithTax,
+			Currency:        llm.NormalizeCurrency(r.Currency),
+			Origin:          r.Origin,
+			Recipient:       r.Recipient,
+			Details:         r.Details,
+			Summary:         r.Summary,
+			OccuredAt:       llm.ReceiptOccuredAt(r.OccuredAt, user.Location(), fallbackOccuredAt),
+			FieldConfidence: llm.FieldConfidence(r.Confidence),
+			Box:             llm.ParsedBox(r.Box),
+			Unsure:          llm.IsReceiptUnsure(r.Confidence),
+		}
+		if merchant != nil {
+			receipt.MerchantID = &merchant.ID
 		}
 		if err := chat.deps.DBC.Create(&receipt).Error; err != nil {
 			return fmt.Errorf("create receipt: %w", errors.WithStack(err))
 		}
 		iterLogger := logger.With(log.RECEIPT_ID, receipt.ID)
 		iterLogger.Debug("Receipt created")
+		receipt.Merchant = merchant
+
+		for _, line := range llm.ReceiptTaxLines(r) {
+			taxLine := db.ReceiptTaxLine{ReceiptID: receipt.ID, Rate: line.Rate, Base: line.Base, Amount: line.Amount}
+			if err := chat.deps.DBC.Create(&taxLine).Error; err != nil {
+				iterLogger.With(log.ERROR, errors.WithStack(err)).Error("failed to create tax line")
+				// TODO: handle
+				continue
+			}
+			receipt.TaxLines = append(receipt.TaxLines, taxLine)
+		}
 
 		for _, p := range r.Products {
+			quantity, unitPrice := llm.ProductQuantity(p)
 			product := db.Product{
-				ReceiptID:      receipt.ID,
-				Title:          p.Title,
-				Details:        p.Details,
-				TotalBeforeTax: p.TotalBeforeTax,
-				Tax:            p.Tax,
-				TotalWithTax:   p.TotalWithTax,
+				ReceiptID:       receipt.ID,
+				LineType:        llm.ProductLineType(p.LineType),
+				Title:           p.Title,
+				Details:         p.Details,
+				Quantity:        quantity,
+				Unit:            productUnit(p.Unit),
+				UnitPrice:       unitPrice,
+				Discount:        p.Discount,
+				TotalBeforeTax:  p.TotalBeforeTax,
+				TaxRate:         p.TaxRate,
+				Tax:             p.Tax,
+				TotalWithTax:    p.TotalWithTax,
+				Line:            p.Line,
+				FieldConfidence: llm.FieldConfidence(p.Confidence),
+				Box:             llm.ParsedBox(p.Box),
+			}
+			var catalogProduct *db.CatalogProduct
+			if product.LineType == db.ProductLineTypeItem && !llm.IsUnsure(p.Confidence, "title", "catalog") {
+				catalogProduct = chat.resolveCatalogProduct(ctx, p, iterLogger)
+			}
+			if catalogProduct != nil {
+				product.CatalogProductID = &catalogProduct.ID
 			}
 			if err := chat.deps.DBC.Create(&product).Error; err != nil {
 				iterLogger.With(log.ERROR, errors.WithStack(err)).Error("failed to create product")
@@ -161,30 +289,246 @@ func (chat *Chat) updateFileWithParsed(file *db.File, parsedFile llm.File4Llm, l
 			}
 			iterLogger = iterLogger.With(log.RECEIPT_ID, receipt.ID).With(log.PRODUCT_ID, product.ID)
 			iterLogger.Debug("Product created")
+			product.CatalogProduct = catalogProduct
 
-			for _, c := range p.Categories {
-				var category db.Category
-				if err := chat.deps.DBC.Where("title = ?", c.Title).First(&category).Error; err != nil {
-					if errors.Is(err, gorm.ErrRecordNotFound) {
-						iterLogger.With("category", c.Title).Warn("no such category")
-					} else {
-						iterLogger.With(log.ERROR, errors.WithStack(err)).Error("failed to find category")
-					}
-					// TODO: handle
-					continue
+			if !chat.applyRule(ctx, &product, receipt.MerchantID, iterLogger) {
+				for _, c := range p.Categories {
+					chat.attachCategory(ctx, &product, c.Title, db.ProductCategorySourceLlm, model, iterLogger)
 				}
-				lgr := iterLogger.With(log.CATEGORY_ID, category.ID)
-				lgr.Debug("Category found")
-
-				if err := chat.deps.DBC.Model(&product).Association("Categories").Append(&category); err != nil {
-					// TODO: handle
-					lgr.With(log.ERROR, errors.WithStack(err)).Error("failed to append category to product")
+				if len(product.Categories) == 0 && merchant != nil && merchant.DefaultCategory != nil {
+					chat.attachCategory(ctx, &product, categories.Path(*merchant.DefaultCategory), db.ProductCategorySourceMerchant, model, iterLogger)
+				}
+				if len(product.Categories) == 0 {
+					chat.classifyProduct(ctx, &product, model, iterLogger)
 				}
-				lgr.Debug("Category attached")
 			}
 			receipt.Products = append(receipt.Products, product)
 		}
+		checkProductsTotal(receipt, iterLogger)
+		chat.convertReceipt(ctx, &receipt, user, iterLogger)
+		if receipt.Unsure {
+			iterLogger.With("unsure", llm.UnsureFields(r.Confidence)).Info("Receipt is unsure, leaving it out of alerts")
+		}
+		chat.flagDuplicate(ctx, &receipt, file.ContentHash, iterLogger)
 		file.Receipts = append(file.Receipts, receipt)
 	}
+	chat.checkBudgets(ctx, user, logger)
+	chat.checkRecurring(ctx, user, logger)
+	chat.checkAnomalies(ctx, user, file.Receipts, logger)
 	return nil
 }
+
+// The user, for their location and base currency, and time of the message with the file, to complete receipts
+func (chat *Chat) receiptDefaults(file *db.File, logger *slog.Logger) (db.User, time.Time) {
+	var user db.User
+	if err := chat.deps.DBC.First(&user, chat.userID).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to find user, using defaults")
+	}
+	var message db.Message
+	if err := chat.deps.DBC.First(&message, file.MessageID).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to find message, using current time")
+		return user, time.Now()
+	}
+	return user, message.CreatedAt
+}
+
+// Merchant details parsed by LLM, or read from the origin when LLM didn't provide them
+func merchantDetails(r llm.Receipt4Llm) merchants.Details {
+	details := merchants.FromOrigin(r.Origin)
+	if r.Merchant != nil && strings.TrimSpace(r.Merchant.Name) != "" {
+		details.Name = strings.TrimSpace(r.Merchant.Name)
+		if r.Merchant.Address != "" {
+			details.Address = r.Merchant.Address
+		}
+		details.TaxID = r.Merchant.TaxID
+	}
+	return details
+}
+
+// Finds or creates the merchant of a receipt. Failures are logged, as a receipt without a merchant is still valuable
+func (chat *Chat) resolveMerchant(ctx context.Context, details merchants.Details, logger *slog.Logger) *db.Merchant {
+	merchant, err := merchants.Resolve(ctx, chat.deps.DBC, chat.userID, details)
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to resolve merchant")
+		return nil
+	}
+	if merchant == nil {
+		return nil
+	}
+	if err := chat.deps.DBC.Preload("DefaultCategory.Parent").First(merchant, merchant.ID).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to load default category of merchant")
+	}
+	logger.With(log.MERCHANT_ID, merchant.ID).Debug("Merchant resolved")
+	return merchant
+}
+
+// Canonical unit of the quantity when it's known, as printed otherwise
+func productUnit(unit string) string {
+	if normalized := catalog.NormalizeUnit(unit); normalized != "" {
+		return normalized
+	}
+	return strings.ToLower(strings.TrimSpace(unit))
+}
+
+// Lines of a receipt, including discounts, deposits, fees and tips, should sum up to its total. A mismatch means some lines are lost or misread
+func checkProductsTotal(receipt db.Receipt, logger *slog.Logger) {
+	if len(receipt.Products) == 0 {
+		return
+	}
+	sum := decimal.Zero
+	for _, product := range receipt.Products {
+		sum = sum.Add(product.TotalWithTax)
+	}
+	if !sum.Equal(receipt.TotalWithTax) {
+		logger.With("products_total", sum).With("receipt_total", receipt.TotalWithTax).Warn("products don't sum up to receipt total")
+	}
+}
+
+// Finds or creates the catalog product of a line item. Failures are logged, as a product out of the catalog is still valuable
+func (chat *Chat) resolveCatalogProduct(ctx context.Context, p llm.Product4Llm, logger *slog.Logger) *db.CatalogProduct {
+	if p.Catalog == nil {
+		return nil
+	}
+	catalogProduct, err := catalog.Resolve(ctx, chat.deps.DBC, chat.userID, catalog.Item{Name: p.Catalog.Name, Unit: p.Catalog.Unit, Size: p.Catalog.Size})
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to resolve catalog product")
+		return nil
+	}
+	return catalogProduct
+}
+
+// Converts the receipt to the base currency of the user. Without exchange rates the receipt stays unconverted till rates are loaded
+func (chat *Chat) convertReceipt(ctx context.Context, receipt *db.Receipt, user db.User, logger *slog.Logger) {
+	if err := currency.ConvertReceipt(ctx, chat.deps.DBC, receipt, user.EffectiveBaseCurrency()); err != nil {
+		logger.With(log.ERROR, err).Warn("failed to convert receipt to base currency")
+	}
+}
+
+// Flags the receipt if it looks like one recorded before, so LLM asks the user whether to keep both
+func (chat *Chat) flagDuplicate(ctx context.Context, receipt *db.Receipt, contentHash string, logger *slog.Logger) {
+	var original *db.Receipt
+	var err error
+	// values LLM was unsure of may match another purchase by chance, so only a copy of the file tells it's a duplicate
+	if receipt.Unsure {
+		original, err = dedup.FlagInSameFile(ctx, chat.deps.DBC, receipt, contentHash)
+	} else {
+		original, err = dedup.Flag(ctx, chat.deps.DBC, receipt)
+	}
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to check receipt for duplicates")
+		return
+	}
+	if original != nil {
+		logger.With("duplicate_of", original.ID).Info("Receipt looks like a duplicate")
+	}
+}
+
+// Categorizes the product by a rule of the user, e.g. learned from their corrections. Returns false if no rule matches, to categorize it otherwise
+func (chat *Chat) applyRule(ctx context.Context, product *db.Product, merchantID *uint, logger *slog.Logger) bool {
+	rule, err := rules.Apply(ctx, chat.deps.DBC, chat.userID, merchantID, product)
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to apply category rule")
+		return false
+	}
+	if rule == nil {
+		return false
+	}
+	logger.With("rule_id", rule.ID).With(log.CATEGORY_ID, rule.CategoryID).Debug("Categorized by rule")
+	return true
+}
+
+// Alerts the user about budgets which new receipts brought to a threshold. Failures are logged, as the receipts are saved anyway
+func (chat *Chat) checkBudgets(ctx context.Context, user db.User, logger *slog.Logger) {
+	alerts, err := budgets.Check(ctx, chat.deps.DBC, user, time.Now())
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to check budgets")
+	}
+	for _, alert := range alerts {
+		if err := chat.deps.Notifier.Notify(ctx, chat.userID, alert); err != nil {
+			logger.With(log.ERROR, err).Warn("failed to send budget alert")
+		}
+	}
+}
+
+func (chat *Chat) checkRecurring(ctx context.Context, user db.User, logger *slog.Logger) {
+	alerts, err := recurring.Detect(ctx, chat.deps.DBC, user, time.Now())
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to detect recurring expenses")
+	}
+	for _, alert := range alerts {
+		if err := chat.deps.Notifier.Notify(ctx, chat.userID, alert); err != nil {
+			logger.With(log.ERROR, err).Warn("failed to send recurring expense alert")
+		}
+	}
+}
+
+func (chat *Chat) checkAnomalies(ctx context.Context, user db.User, receipts []db.Receipt, logger *slog.Logger) {
+	found, err := anomalies.Check(ctx, chat.deps.DBC, user, receipts, time.Now())
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to check anomalies")
+	}
+	for _, anomaly := range found {
+		if err := chat.deps.Notifier.Notify(ctx, chat.userID, anomalies.Alert(anomaly, user.Location())); err != nil {
+			logger.With(log.ERROR, err).Warn("failed to send anomaly alert")
+		}
+	}
+}
+
+// Learns how the user categorizes products, to check LLM and to categorize what it left uncategorized.
+// Returns nil when there is too little to learn from, or on failure, which is logged
+func (chat *Chat) trainClassifier(ctx context.Context, logger *slog.Logger) *classifier.Classifier {
+	model, err := classifier.Train(ctx, chat.deps.DBC, chat.userID)
+	if err != nil {
+		logger.With(log.ERROR, err).Warn("failed to train classifier")
+	}
+	return model
+}
+
+// Links the product to the category the classifier is sure about, if any
+func (chat *Chat) classifyProduct(ctx context.Context, product *db.Product, model *classifier.Classifier, logger *slog.Logger) {
+	prediction, ok := model.Best(product.Title)
+	if !ok {
+		return
+	}
+	var category db.Category
+	if err := chat.deps.DBC.WithContext(ctx).Preload("Parent").First(&category, prediction.CategoryID).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to find predicted category")
+		return
+	}
+	chat.linkCategory(ctx, product, category, db.ProductCategorySourceClassifier, &prediction.Confidence, logger)
+}
+
+// Finds the category of the user by path or title and links it to the product, with the confidence of the classifier in it.
+// Failures are logged, as product without a category is still valuable
+func (chat *Chat) attachCategory(ctx context.Context, product *db.Product, title string, source db.ProductCategorySource, model *classifier.Classifier, logger *slog.Logger) {
+	category, err := categories.Find(ctx, chat.deps.DBC, chat.userID, title)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("failed to find category")
+		// TODO: handle
+		return
+	}
+	if category == nil {
+		logger.With("category", title).Warn("no such category")
+		return
+	}
+	logger.With(log.CATEGORY_ID, category.ID).Debug("Category found")
+
+	confidence := model.Confidence(product.Title, category.ID)
+	if prediction, ok := model.Best(product.Title); ok && prediction.CategoryID != category.ID {
+		logger.With(log.CATEGORY_ID, category.ID).With("predicted_category_id", prediction.CategoryID).
+			With("confidence", prediction.Confidence).Info("classifier disagrees with category")
+	}
+	chat.linkCategory(ctx, product, *category, source, confidence, logger)
+}
+
+func (chat *Chat) linkCategory(ctx context.Context, product *db.Product, category db.Category, source db.ProductCategorySource, confidence *float64, logger *slog.Logger) {
+	lgr := logger.With(log.CATEGORY_ID, category.ID).With("source", source)
+	link := db.ProductCategory{ProductID: product.ID, CategoryID: category.ID, Source: source, Confidence: confidence}
+	if err := chat.deps.DBC.WithContext(ctx).Create(&link).Error; err != nil {
+		// TODO: handle
+		lgr.With(log.ERROR, errors.WithStack(err)).Error("failed to link category to product")
+		return
+	}
+	product.Categories = append(product.Categories, category)
+	lgr.Debug("Category attached")
+}
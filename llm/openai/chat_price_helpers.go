@@ -0,0 +1,96 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/merchants"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const (
+	PRICE_HISTORY_TOOL = "price_history"
+)
+
+type priceHistoryArgs struct {
+	Product  string `json:"product"`
+	Merchant string `json:"merchant"`
+}
+
+type priceHistoryResult struct {
+	Products  []string           `json:"products"`
+	Merchants []string           `json:"merchants,omitempty"`
+	Prices    []stats.PricePoint `json:"prices"`
+}
+
+func priceHistoryDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	description := fmt.Sprintf(`Returns prices the user paid for a product over a period, oldest first, e.g. to tell how the price of milk changed at Lidl. Today is %s.`, time.Now().Format("Monday, 2006-01-02"))
+	return shared.FunctionDefinitionParam{
+		Name:        PRICE_HISTORY_TOOL,
+		Description: openai.String(description),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"product":  map[string]any{"type": "string", "description": "generic product name in the language of the receipts, e.g. Milk"},
+				"merchant": map[string]any{"type": "string", "description": "store name, if the user asks about a particular store"},
+				"from":     map[string]any{"type": "string", "format": "date", "description": "first day of the period, YYYY-MM-DD"},
+				"to":       map[string]any{"type": "string", "format": "date", "description": "last day of the period, inclusive, YYYY-MM-DD"},
+			},
+			"required": []string{"product", "from", "to"},
+		},
+	}, nil
+}
+
+func priceHistory(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	var args priceHistoryArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("unmarshal arguments: %w", errors.WithStack(err))
+	}
+	user, from, to, err := chat.periodFromArguments(ctx, arguments)
+	if err != nil {
+		return "", err
+	}
+
+	catalogProducts, err := catalog.Search(ctx, chat.deps.DBC, user.ID, args.Product)
+	if err != nil {
+		return "", err
+	}
+	result := priceHistoryResult{
+		Products: lo.Map(catalogProducts, func(p db.CatalogProduct, _ int) string { return p.Name }),
+		Prices:   []stats.PricePoint{},
+	}
+	var merchantIDs []uint
+	if args.Merchant != "" {
+		found, err := merchants.Search(ctx, chat.deps.DBC, user.ID, args.Merchant)
+		if err != nil {
+			return "", err
+		}
+		if len(found) == 0 {
+			return fmt.Sprintf(`{"error": "no merchant %q"}`, args.Merchant), nil
+		}
+		result.Merchants = lo.Map(found, func(m db.Merchant, _ int) string { return m.Name })
+		merchantIDs = lo.Map(found, func(m db.Merchant, _ int) uint { return m.ID })
+	}
+
+	if len(catalogProducts) > 0 {
+		ids := lo.Map(catalogProducts, func(p db.CatalogProduct, _ int) uint { return p.ID })
+		result.Prices, err = stats.PriceHistory(ctx, chat.deps.DBC, user.ID, ids, merchantIDs, from, to)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	marshaled, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("marshal price history: %w", errors.WithStack(err))
+	}
+	return string(marshaled), nil
+}
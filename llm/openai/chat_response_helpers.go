@@ -35,20 +35,38 @@ func (chat *Chat) handleResponse(ctx context.Context, message *db.Message) (resp
 
 	chat.history = append(chat.history, openai.UserMessage(userMessageParts))
 
-	params := openai.ChatCompletionNewParams{
-		Model:    ASSISTANT_MODEL,
-		Messages: chat.history,
-	}
-	resp, err := chat.oClient.Chat.Completions.New(ctx, params)
-	if err != nil {
-		return "", fmt.Errorf("getting to user response: %w", errors.WithStack(err))
-	}
-	chat.deps.Logger.Debug("request response to user complete")
 	assistantText := ""
-	if len(resp.Choices) > 0 && resp.Choices[0].Message.Content != "" {
-		assistantText = resp.Choices[0].Message.Content
-	} else {
-		return "", errors.New("empty response to user")
+	for round := 0; assistantText == ""; round++ {
+		if round >= MAX_TOOL_ROUNDS {
+			return "", errors.New("too many tool calls in a row")
+		}
+		params := openai.ChatCompletionNewParams{
+			Model:    ASSISTANT_MODEL,
+			Messages: chat.history,
+			Tools:    chat.toolParams(ctx),
+		}
+		resp, err := chat.oClient.Chat.Completions.New(ctx, params)
+		if err != nil {
+			return "", fmt.Errorf("getting to user response: %w", errors.WithStack(err))
+		}
+		chat.deps.Logger.Debug("request response to user complete")
+		if len(resp.Choices) == 0 {
+			return "", errors.New("empty response to user")
+		}
+		responseMessage := resp.Choices[0].Message
+		if len(responseMessage.ToolCalls) == 0 {
+			if responseMessage.Content == "" {
+				return "", errors.New("empty response to user")
+			}
+			assistantText = responseMessage.Content
+			continue
+		}
+
+		chat.history = append(chat.history, responseMessage.ToParam())
+		for _, toolCall := range responseMessage.ToolCalls {
+			result := chat.callTool(ctx, message, toolCall)
+			chat.history = append(chat.history, openai.ToolMessage(result, toolCall.ID))
+		}
 	}
 
 	responseMessage := db.Message{
@@ -0,0 +1,108 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/stats"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+)
+
+const (
+	SPENDING_SUMMARY_TOOL = "spending_summary"
+	TAX_SUMMARY_TOOL      = "tax_summary"
+)
+
+type periodArgs struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func spendingSummaryDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	description := fmt.Sprintf(`Returns total spending of the user and totals by category and by merchant for a period, converted to the user's base currency. Today is %s.`, time.Now().Format("Monday, 2006-01-02"))
+	return shared.FunctionDefinitionParam{
+		Name:        SPENDING_SUMMARY_TOOL,
+		Description: openai.String(description),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"from": map[string]any{"type": "string", "format": "date", "description": "first day of the period, YYYY-MM-DD"},
+				"to":   map[string]any{"type": "string", "format": "date", "description": "last day of the period, inclusive, YYYY-MM-DD"},
+			},
+			"required": []string{"from", "to"},
+		},
+	}, nil
+}
+
+func spendingSummary(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	user, from, to, err := chat.periodFromArguments(ctx, arguments)
+	if err != nil {
+		return "", err
+	}
+	spending, err := stats.SpendingBetween(ctx, chat.deps.DBC, user, from, to)
+	if err != nil {
+		return "", err
+	}
+	result, err := json.Marshal(spending)
+	if err != nil {
+		return "", fmt.Errorf("marshal spending: %w", errors.WithStack(err))
+	}
+	return string(result), nil
+}
+
+func taxSummaryDefinition(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error) {
+	description := fmt.Sprintf(`Returns tax, like VAT, the user paid over a period by tax rate, with the amounts it applies to, for bookkeeping. Today is %s.`, time.Now().Format("Monday, 2006-01-02"))
+	return shared.FunctionDefinitionParam{
+		Name:        TAX_SUMMARY_TOOL,
+		Description: openai.String(description),
+		Parameters: shared.FunctionParameters{
+			"type": "object",
+			"properties": map[string]any{
+				"from": map[string]any{"type": "string", "format": "date", "description": "first day of the period, YYYY-MM-DD"},
+				"to":   map[string]any{"type": "string", "format": "date", "description": "last day of the period, inclusive, YYYY-MM-DD"},
+			},
+			"required": []string{"from", "to"},
+		},
+	}, nil
+}
+
+func taxSummary(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error) {
+	user, from, to, err := chat.periodFromArguments(ctx, arguments)
+	if err != nil {
+		return "", err
+	}
+	taxes, err := stats.TaxesBetween(ctx, chat.deps.DBC, user.ID, from, to)
+	if err != nil {
+		return "", err
+	}
+	result, err := json.Marshal(taxes)
+	if err != nil {
+		return "", fmt.Errorf("marshal taxes: %w", errors.WithStack(err))
+	}
+	return string(result), nil
+}
+
+// Reads {"from": "YYYY-MM-DD", "to": "YYYY-MM-DD"} as [from, to+1 day) in the location of the user
+func (chat *Chat) periodFromArguments(ctx context.Context, arguments string) (user db.User, from time.Time, to time.Time, _ error) {
+	var args periodArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return user, from, to, fmt.Errorf("unmarshal period: %w", errors.WithStack(err))
+	}
+	if err := chat.deps.DBC.WithContext(ctx).First(&user, chat.userID).Error; err != nil {
+		return user, from, to, fmt.Errorf("find user: %w", errors.WithStack(err))
+	}
+	from, err := time.ParseInLocation(time.DateOnly, args.From, user.Location())
+	if err != nil {
+		return user, from, to, fmt.Errorf("parsing from: %w", errors.WithStack(err))
+	}
+	to, err = time.ParseInLocation(time.DateOnly, args.To, user.Location())
+	if err != nil {
+		return user, from, to, fmt.Errorf("parsing to: %w", errors.WithStack(err))
+	}
+	return user, from, to.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,68 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/pkg/errors"
+)
+
+const (
+	// How many times in a row LLM may call tools before it has to answer the user
+	MAX_TOOL_ROUNDS = 5
+)
+
+// A function LLM can call while preparing a response to the user
+type tool struct {
+	// Builds the definition on every request, so it may include fresh data, like current date or categories
+	definition func(ctx context.Context, chat *Chat) (shared.FunctionDefinitionParam, error)
+	// Executes the call with JSON arguments from LLM and returns a result for LLM
+	call func(ctx context.Context, chat *Chat, message *db.Message, arguments string) (string, error)
+}
+
+var tools = map[string]tool{
+	RECORD_EXPENSE_TOOL:       {definition: recordExpenseDefinition, call: recordExpense},
+	SPENDING_SUMMARY_TOOL:     {definition: spendingSummaryDefinition, call: spendingSummary},
+	SPENDING_CHART_TOOL:       {definition: spendingChartDefinition, call: spendingChart},
+	RESOLVE_DUPLICATE_TOOL:    {definition: resolveDuplicateDefinition, call: resolveDuplicate},
+	PRICE_HISTORY_TOOL:        {definition: priceHistoryDefinition, call: priceHistory},
+	TAX_SUMMARY_TOOL:          {definition: taxSummaryDefinition, call: taxSummary},
+	RECATEGORIZE_PRODUCT_TOOL: {definition: recategorizeProductDefinition, call: recategorizeProduct},
+}
+
+func (chat *Chat) toolParams(ctx context.Context) []openai.ChatCompletionToolUnionParam {
+	var params []openai.ChatCompletionToolUnionParam
+	for name, t := range tools {
+		definition, err := t.definition(ctx, chat)
+		if err != nil {
+			chat.deps.Logger.With(log.ERROR, err).With(log.TOOL, name).Error("failed to build tool definition, skipping")
+			continue
+		}
+		params = append(params, openai.ChatCompletionFunctionTool(definition))
+	}
+	return params
+}
+
+// Executes a tool call requested by LLM. Errors are reported back to LLM as the result, so it can explain them to the user
+func (chat *Chat) callTool(ctx context.Context, message *db.Message, toolCall openai.ChatCompletionMessageToolCallUnion) string {
+	logger := chat.deps.Logger.With(log.TOOL, toolCall.Function.Name)
+	logger.With("arguments", toolCall.Function.Arguments).Debug("calling tool")
+
+	t, ok := tools[toolCall.Function.Name]
+	if !ok {
+		logger.Warn("llm called unknown tool")
+		return fmt.Sprintf(`{"error": "unknown tool %q"}`, toolCall.Function.Name)
+	}
+
+	result, err := t.call(ctx, chat, message, toolCall.Function.Arguments)
+	if err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("tool call failed")
+		return fmt.Sprintf(`{"error": %q}`, err.Error())
+	}
+	logger.With("result", result).Debug("tool call complete")
+	return result
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/EPecherkin/catty-counting/deps"
 	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/reply"
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
 )
@@ -30,15 +31,24 @@ func CreateClient(deps deps.Deps) (llm.Client, error) {
 	return &Client{oClient: &oClient, deps: deps, chatPerUser: make(map[uint]*Chat)}, nil
 }
 
-func (client *Client) HandleMessage(ctx context.Context, message db.Message, response chan<- string) {
+func (client *Client) HandleMessage(ctx context.Context, message db.Message, response chan<- reply.Chunk) {
+	client.chatFor(message.UserID).Talk(ctx, message, response)
+}
+
+func (client *Client) ParseFile(ctx context.Context, userID uint, file db.File) (llm.File4Llm, error) {
+	if err := client.chatFor(userID).handleFile(ctx, &file); err != nil {
+		return llm.File4Llm{}, err
+	}
+	return llm.DbFileToLlm(file), nil
+}
+
+func (client *Client) chatFor(userID uint) *Chat {
 	client.mu.Lock()
-	userID := message.UserID
+	defer client.mu.Unlock()
 	chat, ok := client.chatPerUser[userID]
 	if !ok {
 		chat = newChat(userID, client.oClient, client.deps)
 		client.chatPerUser[userID] = chat
 	}
-	client.mu.Unlock()
-
-	chat.Talk(ctx, message, response)
+	return chat
 }
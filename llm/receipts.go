@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Values parsed with lower confidence are unsure: the user is asked to check them, and they aren't used to match merchants and catalog products,
+	// nor to match receipts to duplicates by close values and to alert, see IsReceiptUnsure
+	LOW_CONFIDENCE = 0.7
+)
+
+// Fields of a receipt which duplicate checks and alerts rely on, see IsReceiptUnsure
+var KEY_RECEIPT_FIELDS = []string{"total_with_tax", "occured_at", "currency"}
+
+// Normalizes the date of a receipt parsed by LLM. Receipts show local wall-clock time without a zone,
+// so a date in UTC is read in the user's location. Falls back when the receipt has no date
+func ReceiptOccuredAt(parsed time.Time, loc *time.Location, fallback time.Time) time.Time {
+	if parsed.IsZero() {
+		return fallback.UTC()
+	}
+	if _, offset := parsed.Zone(); offset != 0 {
+		return parsed.UTC()
+	}
+	year, month, day := parsed.Date()
+	hour, min, sec := parsed.Clock()
+	return time.Date(year, month, day, hour, min, sec, 0, loc).UTC()
+}
+
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// Quantity and unit price, before discount, of a parsed line item. A line without quantity is a single item
+func ProductQuantity(product Product4Llm) (quantity decimal.Decimal, unitPrice decimal.Decimal) {
+	quantity = product.Quantity
+	if !quantity.IsPositive() {
+		quantity = decimal.NewFromInt(1)
+	}
+	unitPrice = product.UnitPrice
+	if !unitPrice.IsPositive() {
+		unitPrice = product.TotalWithTax.Add(product.Discount).Div(quantity).Round(2)
+	}
+	return quantity, unitPrice
+}
+
+// Line type of a parsed line item. Unknown types are items
+func ProductLineType(lineType string) db.ProductLineType {
+	switch t := db.ProductLineType(strings.ToLower(strings.TrimSpace(lineType))); t {
+	case db.ProductLineTypeDiscount, db.ProductLineTypeDeposit, db.ProductLineTypeFee, db.ProductLineTypeTip:
+		return t
+	default:
+		return db.ProductLineTypeItem
+	}
+}
+
+// Tax lines of a parsed receipt. When the receipt has no tax summary, they are summed up from tax rates of its products
+func ReceiptTaxLines(receipt Receipt4Llm) []TaxLine4Llm {
+	if len(receipt.TaxLines) > 0 {
+		return receipt.TaxLines
+	}
+	var lines []TaxLine4Llm
+	indexPerRate := map[string]int{}
+	for _, product := range receipt.Products {
+		if product.TaxRate.IsZero() && product.Tax.IsZero() {
+			continue
+		}
+		key := product.TaxRate.String()
+		i, ok := indexPerRate[key]
+		if !ok {
+			i = len(lines)
+			indexPerRate[key] = i
+			lines = append(lines, TaxLine4Llm{Rate: product.TaxRate})
+		}
+		base, tax := product.TotalBeforeTax, product.Tax
+		if base.IsZero() && tax.IsZero() {
+			// only the total and the rate are printed
+			base = product.TotalWithTax.Div(decimal.NewFromInt(100).Add(product.TaxRate)).Mul(decimal.NewFromInt(100)).Round(2)
+			tax = product.TotalWithTax.Sub(base)
+		}
+		lines[i].Base = lines[i].Base.Add(base)
+		lines[i].Amount = lines[i].Amount.Add(tax)
+	}
+	return lines
+}
+
+// Confidence of LLM to store, limited to 0..1. Fields without a known confidence are left out
+func FieldConfidence(confidence Confidence4Llm) db.FieldConfidence {
+	stored := db.FieldConfidence{}
+	for field, value := range confidence {
+		if field = strings.TrimSpace(field); field == "" || value < 0 {
+			continue
+		}
+		stored[field] = min(value, 1)
+	}
+	return stored
+}
+
+// Fields parsed with low confidence, sorted by name
+func UnsureFields(confidence Confidence4Llm) []string {
+	var fields []string
+	for field, value := range confidence {
+		if value < LOW_CONFIDENCE {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Whether LLM is unsure about any of the fields
+func IsUnsure(confidence Confidence4Llm, fields ...string) bool {
+	for _, field := range fields {
+		if value, ok := confidence[field]; ok && value < LOW_CONFIDENCE {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether LLM is unsure about the total, the date or the currency of the receipt. Such receipts are saved as db.Receipt.Unsure,
+// only copies of their file tell they are duplicates, and they are left out of alerts, as the values are likely wrong
+func IsReceiptUnsure(confidence Confidence4Llm) bool {
+	return IsUnsure(confidence, KEY_RECEIPT_FIELDS...)
+}
+
+// Box of a receipt or a product to store, empty when LLM gave none or gave an invalid one
+func ParsedBox(box *Box4Llm) db.Box {
+	if box == nil || box.Page < 1 || box.Width <= 0 || box.Height <= 0 {
+		return db.Box{}
+	}
+	return db.Box(*box)
+}
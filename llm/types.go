@@ -5,12 +5,15 @@ import (
 	"time"
 
 	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/reply"
 	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 )
 
 type Client interface {
-	HandleMessage(ctx context.Context, message db.Message, response chan<- string)
+	HandleMessage(ctx context.Context, message db.Message, response chan<- reply.Chunk)
+	// Extracts and persists receipts from the file of the user, without responding to the user
+	ParseFile(ctx context.Context, userID uint, file db.File) (File4Llm, error)
 }
 
 type File4Llm struct {
@@ -21,26 +24,86 @@ type File4Llm struct {
 
 type Receipt4Llm struct {
 	ID             uint            `json:"id,omitempty"`
+	Source         string          `json:"source,omitempty"`
 	TotalBeforeTax decimal.Decimal `json:"total_before_tax"`
 	Tax            decimal.Decimal `json:"tax"`
 	TotalWithTax   decimal.Decimal `json:"total_with_tax"`
 	Currency       string          `json:"currency"`
 	Origin         string          `json:"origin"`
+	Merchant       *Merchant4Llm   `json:"merchant,omitempty"`
 	Recipient      string          `json:"recipient"`
 	Details        string          `json:"details"`
 	Summary        string          `json:"summary"`
 	OccuredAt      time.Time       `json:"occured_at"`
+	DuplicateOf    *Duplicate4Llm  `json:"duplicate_of,omitempty"`
+	TaxLines       []TaxLine4Llm   `json:"tax_lines,omitempty"`
 	Products       []Product4Llm   `json:"products"`
+	Box            *Box4Llm        `json:"box,omitempty"`
+	Confidence     Confidence4Llm  `json:"confidence,omitempty"`
+	Unsure         []string        `json:"unsure,omitempty"`
+}
+
+// How sure LLM is about the values it parsed, from 0 to 1, by JSON names of the fields
+type Confidence4Llm map[string]float64
+
+// Where a receipt or a product is in the file: the page, counting from 1, and the rectangle as fractions of the page size
+type Box4Llm struct {
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Tax of a receipt at one rate. Rate is in percent
+type TaxLine4Llm struct {
+	Rate   decimal.Decimal `json:"rate"`
+	Base   decimal.Decimal `json:"base"`
+	Amount decimal.Decimal `json:"amount"`
+}
+
+type Merchant4Llm struct {
+	ID      uint   `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxID   string `json:"tax_id,omitempty"`
+}
+
+// An earlier receipt which looks like the same purchase
+type Duplicate4Llm struct {
+	ID        uint      `json:"id"`
+	SentAt    time.Time `json:"sent_at"`
+	OccuredAt time.Time `json:"occured_at"`
+	Origin    string    `json:"origin"`
 }
 
 type Product4Llm struct {
-	ID             uint            `json:"id,omitempty"`
-	Title          string          `json:"title"`
-	Details        string          `json:"details"`
-	TotalBeforeTax decimal.Decimal `json:"total_before_tax"`
-	Tax            decimal.Decimal `json:"tax"`
-	TotalWithTax   decimal.Decimal `json:"total_with_tax"`
-	Categories     []Category4Llm  `json:"categories"`
+	ID             uint                `json:"id,omitempty"`
+	Title          string              `json:"title"`
+	Details        string              `json:"details"`
+	LineType       string              `json:"line_type,omitempty"`
+	Catalog        *CatalogProduct4Llm `json:"catalog,omitempty"`
+	Quantity       decimal.Decimal     `json:"quantity"`
+	Unit           string              `json:"unit,omitempty"`
+	UnitPrice      decimal.Decimal     `json:"unit_price"`
+	Discount       decimal.Decimal     `json:"discount"`
+	TotalBeforeTax decimal.Decimal     `json:"total_before_tax"`
+	TaxRate        decimal.Decimal     `json:"tax_rate"`
+	Tax            decimal.Decimal     `json:"tax"`
+	TotalWithTax   decimal.Decimal     `json:"total_with_tax"`
+	Categories     []Category4Llm      `json:"categories"`
+	Line           int                 `json:"line,omitempty"`
+	Box            *Box4Llm            `json:"box,omitempty"`
+	Confidence     Confidence4Llm      `json:"confidence,omitempty"`
+	Unsure         []string            `json:"unsure,omitempty"`
+}
+
+// The product regardless of the receipt, to follow its price
+type CatalogProduct4Llm struct {
+	ID   uint            `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Unit string          `json:"unit,omitempty"`
+	Size decimal.Decimal `json:"size,omitempty"`
 }
 
 type Category4Llm struct {
@@ -49,6 +112,17 @@ type Category4Llm struct {
 	Details string `json:"details,omitempty"`
 }
 
+// An expense described by the user in plain text, without a receipt
+type Expense4Llm struct {
+	Title        string          `json:"title"`
+	Details      string          `json:"details"`
+	TotalWithTax decimal.Decimal `json:"total_with_tax"`
+	Currency     string          `json:"currency"`
+	Origin       string          `json:"origin"`
+	OccuredAt    time.Time       `json:"occured_at"`
+	Categories   []string        `json:"categories"`
+}
+
 func DbFileToLlm(file db.File) File4Llm {
 	f4l := File4Llm{
 		ID:       file.ID,
@@ -61,6 +135,7 @@ func DbFileToLlm(file db.File) File4Llm {
 func DbReceiptToLlm(receipt db.Receipt) Receipt4Llm {
 	r4l := Receipt4Llm{
 		ID:             receipt.ID,
+		Source:         string(receipt.Source),
 		TotalBeforeTax: receipt.TotalBeforeTax,
 		Tax:            receipt.Tax,
 		TotalWithTax:   receipt.TotalWithTax,
@@ -70,7 +145,24 @@ func DbReceiptToLlm(receipt db.Receipt) Receipt4Llm {
 		Details:        receipt.Details,
 		Summary:        receipt.Summary,
 		OccuredAt:      receipt.OccuredAt,
-		Products:       lo.Map(receipt.Products, func(product db.Product, _ int) Product4Llm { return DbProductToLlm(product) }),
+		TaxLines: lo.Map(receipt.TaxLines, func(line db.ReceiptTaxLine, _ int) TaxLine4Llm {
+			return TaxLine4Llm{Rate: line.Rate, Base: line.Base, Amount: line.Amount}
+		}),
+		Products:   lo.Map(receipt.Products, func(product db.Product, _ int) Product4Llm { return DbProductToLlm(product) }),
+		Box:        DbBoxToLlm(receipt.Box),
+		Confidence: Confidence4Llm(receipt.FieldConfidence),
+		Unsure:     UnsureFields(Confidence4Llm(receipt.FieldConfidence)),
+	}
+	if receipt.Merchant != nil {
+		r4l.Merchant = &Merchant4Llm{ID: receipt.Merchant.ID, Name: receipt.Merchant.Name, Address: receipt.Merchant.Address, TaxID: receipt.Merchant.TaxID}
+	}
+	if receipt.DuplicateOf != nil {
+		r4l.DuplicateOf = &Duplicate4Llm{
+			ID:        receipt.DuplicateOf.ID,
+			SentAt:    receipt.DuplicateOf.CreatedAt,
+			OccuredAt: receipt.DuplicateOf.OccuredAt,
+			Origin:    receipt.DuplicateOf.Origin,
+		}
 	}
 	return r4l
 }
@@ -80,10 +172,23 @@ func DbProductToLlm(product db.Product) Product4Llm {
 		ID:             product.ID,
 		Title:          product.Title,
 		Details:        product.Details,
+		LineType:       string(product.LineType),
+		Quantity:       product.Quantity,
+		Unit:           product.Unit,
+		UnitPrice:      product.UnitPrice,
+		Discount:       product.Discount,
 		TotalBeforeTax: product.TotalBeforeTax,
+		TaxRate:        product.TaxRate,
 		Tax:            product.Tax,
 		TotalWithTax:   product.TotalWithTax,
 		Categories:     lo.Map(product.Categories, func(category db.Category, _ int) Category4Llm { return DbCategoryToLlm(category) }),
+		Line:           product.Line,
+		Box:            DbBoxToLlm(product.Box),
+		Confidence:     Confidence4Llm(product.FieldConfidence),
+		Unsure:         UnsureFields(Confidence4Llm(product.FieldConfidence)),
+	}
+	if product.CatalogProduct != nil {
+		p4l.Catalog = &CatalogProduct4Llm{ID: product.CatalogProduct.ID, Name: product.CatalogProduct.Name, Unit: product.CatalogProduct.Unit, Size: product.CatalogProduct.Size}
 	}
 	return p4l
 }
@@ -96,3 +201,11 @@ func DbCategoryToLlm(category db.Category) Category4Llm {
 	}
 	return c4l
 }
+
+func DbBoxToLlm(box db.Box) *Box4Llm {
+	if box == (db.Box{}) {
+		return nil
+	}
+	b4l := Box4Llm(box)
+	return &b4l
+}
@@ -23,6 +23,8 @@ const (
 	RECEIPT_ID           = "receipt_id"
 	PRODUCT_ID           = "product_id"
 	CATEGORY_ID          = "category_id"
+	MERCHANT_ID          = "merchant_id"
+	TOOL                 = "tool"
 	TELEGRAM_USER_ID     = "telegram_user_id"
 	TELEGRAM_UPDATE_ID   = "telegram_update_id"
 	TELEGRAM_CHAT_ID     = "telegram_chat_id"
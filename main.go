@@ -2,25 +2,29 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log/slog"
 	"os"
 	"sync"
+	_ "time/tzdata"
 
+	"github.com/EPecherkin/catty-counting/anomalies"
 	"github.com/EPecherkin/catty-counting/api"
 	"github.com/EPecherkin/catty-counting/chatter"
 	"github.com/EPecherkin/catty-counting/config"
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/digests"
 	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/EPecherkin/catty-counting/llm/openai"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/messenger"
 	"github.com/EPecherkin/catty-counting/prompts"
+	"github.com/EPecherkin/catty-counting/recurring"
+	"github.com/EPecherkin/catty-counting/scheduler"
 	"github.com/pkg/errors"
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob"
-	"gorm.io/gorm"
 )
 
 func main() {
@@ -30,57 +34,107 @@ func main() {
 		}
 	}()
 
+	cliMode := flag.Bool("cli", false, "chat in the terminal instead of Telegram")
+	flag.Parse()
+	switch flag.Arg(0) {
+	case "migrate":
+		os.Exit(runMigrate(flag.Args()[1:]))
+	case "backfill-receipts":
+		os.Exit(runBackfillReceipts())
+	case "rates":
+		os.Exit(runRates(flag.Args()[1:]))
+	}
+
 	ctx := context.Background()
-	logger, dbc, files, llmc, msgc, err := initialize(ctx)
+	d, llmc, msgcs, routes, err := initialize(ctx, *cliMode)
 	if err != nil {
-		logger.With(log.ERROR, err).Error("Initialization failed")
+		d.Logger.With(log.ERROR, err).Error("Initialization failed")
 		os.Exit(1)
 	}
 
 	var wg sync.WaitGroup
-	d := deps.Deps{Logger: logger, DBC: dbc, Files: files}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		chatter.NewChatter(msgc, llmc, d).Run(ctx)
+		chatter.NewChatter(msgcs, llmc, d).Run(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		api.NewApi(llmc, d, routes...).Run(ctx)
 	}()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		api.NewApi(d).Run(ctx)
+		jobs := digests.Jobs()
+		jobs[db.ScheduleKindRecurringCheck] = recurring.Job
+		jobs[db.ScheduleKindAnomalyCheck] = anomalies.Job
+		scheduler.NewScheduler(d, jobs).Run(ctx)
 	}()
 
 	wg.Wait()
 }
 
-func initialize(ctx context.Context) (logger *slog.Logger, databaseConnection *gorm.DB, filesBucket *blob.Bucket, _ llm.Client, _ messenger.Client, _ error) {
+func initialize(ctx context.Context, cliMode bool) (d deps.Deps, _ llm.Client, _ []messenger.Client, _ []api.Routes, _ error) {
 	if err := config.Init(); err != nil {
-		return log.NewLogger(), nil, nil, nil, nil, fmt.Errorf("initializing config: %w", err) // LOG_LEVEL for logger is available only after config.Init
+		return deps.Deps{Logger: log.NewLogger()}, nil, nil, nil, fmt.Errorf("initializing config: %w", err) // LOG_LEVEL for logger is available only after config.Init
 	}
 
-	logger = log.NewLogger() // LOG_LEVEL for logger is available only after config.Init
+	d.Logger = log.NewLogger() // LOG_LEVEL for logger is available only after config.Init
 	dbc, err := db.NewConnection()
 	if err != nil {
-		return logger, nil, nil, nil, nil, fmt.Errorf("initializing database connection: %w", err)
+		return d, nil, nil, nil, fmt.Errorf("initializing database connection: %w", err)
 	}
+	d.DBC = dbc
 
 	files, err := blob.OpenBucket(ctx, config.FileBucket())
 	if err != nil {
-		return logger, nil, nil, nil, nil, fmt.Errorf("initializing file blob: %w", errors.WithStack(err))
+		return d, nil, nil, nil, fmt.Errorf("initializing file blob: %w", errors.WithStack(err))
 	}
+	d.Files = files
 
-	if err := prompts.Init(dbc); err != nil {
-		return logger, nil, nil, nil, nil, fmt.Errorf("initializing prompts: %w", errors.WithStack(err))
+	if err := prompts.Init(); err != nil {
+		return d, nil, nil, nil, fmt.Errorf("initializing prompts: %w", errors.WithStack(err))
 	}
 
-	llmc, err := openai.CreateClient(deps.Deps{Logger: logger, DBC: dbc, Files: files})
+	// messenger clients are added to the notifier as they are created
+	notifier := messenger.NewNotifier(d)
+	d.Notifier = notifier
+
+	llmc, err := openai.CreateClient(d)
 	if err != nil {
-		return logger, nil, nil, nil, nil, fmt.Errorf("initializing llm client: %w", err)
+		return d, nil, nil, nil, fmt.Errorf("initializing llm client: %w", err)
 	}
 
-	msgc, err := messenger.CreateTelegramClient(deps.Deps{Logger: logger, DBC: dbc, Files: files})
+	createMessengerClient := messenger.CreateTelegramClient
+	if cliMode {
+		createMessengerClient = messenger.CreateCliClient
+	}
+	msgc, err := createMessengerClient(d)
 	if err != nil {
-		return logger, nil, nil, nil, nil, fmt.Errorf("initializing messenger client: %w", err)
+		return d, nil, nil, nil, fmt.Errorf("initializing messenger client: %w", err)
+	}
+	msgcs := []messenger.Client{msgc}
+	var routes []api.Routes
+
+	if config.WebChat() {
+		webc, err := messenger.CreateWebClient(d)
+		if err != nil {
+			return d, nil, nil, nil, fmt.Errorf("initializing web messenger client: %w", err)
+		}
+		msgcs = append(msgcs, webc)
+		routes = append(routes, webc)
+	}
+
+	if config.Maildir() != "" {
+		emailc, err := messenger.CreateEmailClient(d)
+		if err != nil {
+			return d, nil, nil, nil, fmt.Errorf("initializing email messenger client: %w", err)
+		}
+		msgcs = append(msgcs, emailc)
+	}
+	for _, msgc := range msgcs {
+		notifier.Add(msgc)
 	}
-	return logger, dbc, files, llmc, msgc, nil
+	return d, llmc, msgcs, routes, nil
 }
@@ -0,0 +1,290 @@
+package merchants
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// Share of common words in names of the same merchant
+	MIN_NAME_SIMILARITY = 0.6
+)
+
+// Words of legal forms, which are written inconsistently on receipts and don't tell merchants apart
+var legalForms = map[string]bool{
+	"ab": true, "ag": true, "as": true, "bv": true, "co": true, "corp": true, "gmbh": true, "inc": true, "kg": true,
+	"llc": true, "ltd": true, "mbh": true, "nv": true, "ohg": true, "oy": true, "plc": true, "sa": true, "sarl": true,
+	"sas": true, "spa": true, "srl": true, "ug": true,
+}
+
+// What is known about the merchant from a receipt
+type Details struct {
+	Name    string
+	Address string
+	TaxID   string
+}
+
+// Reads details from Receipt.Origin, which is "store name; address; phone; email; other info"
+func FromOrigin(origin string) Details {
+	parts := strings.Split(origin, ";")
+	details := Details{Name: strings.TrimSpace(parts[0])}
+	if len(parts) > 1 {
+		details.Address = strings.TrimSpace(parts[1])
+	}
+	return details
+}
+
+// Lowercase words of the name without punctuation and legal forms, e.g. "REWE Markt GmbH" -> "rewe markt"
+func Normalize(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	var meaningful []string
+	for _, word := range words {
+		if !legalForms[word] {
+			meaningful = append(meaningful, word)
+		}
+	}
+	if len(meaningful) == 0 {
+		meaningful = words
+	}
+	return strings.Join(meaningful, " ")
+}
+
+// Jaccard similarity of words in the normalized names
+func NameSimilarity(a string, b string) float64 {
+	wordsA, wordsB := strings.Fields(Normalize(a)), strings.Fields(Normalize(b))
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	inA := map[string]bool{}
+	for _, word := range wordsA {
+		inA[word] = true
+	}
+	inB := map[string]bool{}
+	for _, word := range wordsB {
+		inB[word] = true
+	}
+	common := 0
+	for word := range inA {
+		if inB[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(inA)+len(inB)-common)
+}
+
+func normalizeTaxID(taxID string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(taxID), ""))
+}
+
+// Finds the merchant of the user by tax ID, name or alias, or creates a new one. Returns nil if the details have no name
+func Resolve(ctx context.Context, dbc *gorm.DB, userID uint, details Details) (*db.Merchant, error) {
+	dbc = dbc.WithContext(ctx)
+	normalized := Normalize(details.Name)
+	if normalized == "" {
+		return nil, nil
+	}
+	taxID := normalizeTaxID(details.TaxID)
+
+	merchant, err := find(dbc, userID, normalized, taxID)
+	if err != nil {
+		return nil, err
+	}
+	if merchant == nil {
+		merchant = &db.Merchant{UserID: userID, Name: details.Name, NormalizedName: normalized, Address: details.Address, TaxID: taxID}
+		if err := dbc.Create(merchant).Error; err != nil {
+			return nil, fmt.Errorf("creating merchant: %w", errors.WithStack(err))
+		}
+		return merchant, nil
+	}
+
+	changes := map[string]any{}
+	if merchant.Address == "" && details.Address != "" {
+		changes["address"] = details.Address
+	}
+	if merchant.TaxID == "" && taxID != "" {
+		changes["tax_id"] = taxID
+	}
+	if len(changes) > 0 {
+		if err := dbc.Model(merchant).Updates(changes).Error; err != nil {
+			return nil, fmt.Errorf("completing merchant: %w", errors.WithStack(err))
+		}
+	}
+	return merchant, nil
+}
+
+func find(dbc *gorm.DB, userID uint, normalized string, taxID string) (*db.Merchant, error) {
+	var merchants []db.Merchant
+	if err := dbc.Preload("Aliases").Where("user_id = ?", userID).Order("id asc").Find(&merchants).Error; err != nil {
+		return nil, fmt.Errorf("fetching merchants: %w", errors.WithStack(err))
+	}
+
+	if taxID != "" {
+		for i, merchant := range merchants {
+			if merchant.TaxID == taxID {
+				return &merchants[i], nil
+			}
+		}
+	}
+	for i, merchant := range merchants {
+		if merchant.NormalizedName == normalized {
+			return &merchants[i], nil
+		}
+		for _, alias := range merchant.Aliases {
+			if alias.NormalizedAlias == normalized {
+				return &merchants[i], nil
+			}
+		}
+	}
+
+	var best *db.Merchant
+	bestSimilarity := MIN_NAME_SIMILARITY
+	for i, merchant := range merchants {
+		names := []string{merchant.NormalizedName}
+		for _, alias := range merchant.Aliases {
+			names = append(names, alias.NormalizedAlias)
+		}
+		for _, name := range names {
+			if similarity := NameSimilarity(name, normalized); similarity >= bestSimilarity {
+				best, bestSimilarity = &merchants[i], similarity
+			}
+		}
+	}
+	return best, nil
+}
+
+// Adds an alias to the merchant. Other merchants of the user with this name are merged into the merchant.
+// Returns the number of merged merchants
+func AddAlias(ctx context.Context, dbc *gorm.DB, merchant db.Merchant, alias string) (int, error) {
+	normalized := Normalize(alias)
+	if normalized == "" {
+		return 0, errors.New("alias is empty")
+	}
+	merged := 0
+	err := dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&db.MerchantAlias{MerchantID: merchant.ID, Alias: alias, NormalizedAlias: normalized}).Error; err != nil {
+			return fmt.Errorf("creating alias: %w", errors.WithStack(err))
+		}
+
+		var same []db.Merchant
+		if err := tx.Where("user_id = ? AND id <> ? AND normalized_name = ?", merchant.UserID, merchant.ID, normalized).Find(&same).Error; err != nil {
+			return fmt.Errorf("fetching merchants with the alias name: %w", errors.WithStack(err))
+		}
+		for _, other := range same {
+			if err := tx.Model(&db.Receipt{}).Where("merchant_id = ?", other.ID).Update("merchant_id", merchant.ID).Error; err != nil {
+				return fmt.Errorf("moving receipts of merchant %d: %w", other.ID, errors.WithStack(err))
+			}
+			if err := tx.Model(&db.MerchantAlias{}).Where("merchant_id = ?", other.ID).Update("merchant_id", merchant.ID).Error; err != nil {
+				return fmt.Errorf("moving aliases of merchant %d: %w", other.ID, errors.WithStack(err))
+			}
+			if err := tx.Model(&db.CategoryRule{}).Where("merchant_id = ?", other.ID).Update("merchant_id", merchant.ID).Error; err != nil {
+				return fmt.Errorf("moving rules of merchant %d: %w", other.ID, errors.WithStack(err))
+			}
+			if err := tx.Model(&db.Anomaly{}).Where("merchant_id = ?", other.ID).Update("merchant_id", merchant.ID).Error; err != nil {
+				return fmt.Errorf("moving anomalies of merchant %d: %w", other.ID, errors.WithStack(err))
+			}
+			if err := mergeRecurring(tx, other.ID, merchant.ID); err != nil {
+				return fmt.Errorf("merging recurring expenses of merchant %d: %w", other.ID, err)
+			}
+			if err := tx.Delete(&other).Error; err != nil {
+				return fmt.Errorf("deleting merchant %d: %w", other.ID, errors.WithStack(err))
+			}
+			merged++
+		}
+		return nil
+	})
+	return merged, err
+}
+
+// Moves recurring expenses of the merchant to the kept one. Of expenses in the same currency, the one charged last is kept
+func mergeRecurring(tx *gorm.DB, merchantID uint, keptID uint) error {
+	var expenses []db.RecurringExpense
+	if err := tx.Where("merchant_id IN ?", []uint{merchantID, keptID}).Order("last_charged_at desc, id desc").Find(&expenses).Error; err != nil {
+		return fmt.Errorf("fetching recurring expenses: %w", errors.WithStack(err))
+	}
+	kept := map[string]bool{}
+	for _, expense := range expenses {
+		if kept[expense.Currency] {
+			if err := tx.Delete(&expense).Error; err != nil {
+				return fmt.Errorf("deleting recurring expense %d: %w", expense.ID, errors.WithStack(err))
+			}
+			continue
+		}
+		kept[expense.Currency] = true
+		if expense.MerchantID != keptID {
+			if err := tx.Model(&expense).Update("merchant_id", keptID).Error; err != nil {
+				return fmt.Errorf("moving recurring expense %d: %w", expense.ID, errors.WithStack(err))
+			}
+		}
+	}
+	return nil
+}
+
+// Links receipts without a merchant to merchants resolved from their origin. Returns the number of linked receipts
+func LinkReceipts(ctx context.Context, dbc *gorm.DB, logger *slog.Logger) (int, error) {
+	var receipts []db.Receipt
+	if err := dbc.WithContext(ctx).Where("merchant_id IS NULL").Order("id asc").Find(&receipts).Error; err != nil {
+		return 0, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	logger.With("receipts", len(receipts)).Info("linking receipts to merchants")
+
+	linked := 0
+	for _, receipt := range receipts {
+		merchant, err := Resolve(ctx, dbc, receipt.UserID, FromOrigin(receipt.Origin))
+		if err != nil {
+			return linked, fmt.Errorf("resolving merchant of receipt %d: %w", receipt.ID, err)
+		}
+		if merchant == nil {
+			continue
+		}
+		if err := dbc.WithContext(ctx).Model(&receipt).Update("merchant_id", merchant.ID).Error; err != nil {
+			return linked, fmt.Errorf("linking receipt %d: %w", receipt.ID, errors.WithStack(err))
+		}
+		logger.With(log.RECEIPT_ID, receipt.ID).With(log.MERCHANT_ID, merchant.ID).Debug("receipt linked to merchant")
+		linked++
+	}
+	return linked, nil
+}
+
+// Merchants of the user, which name or alias has all the words of the query, e.g. "lidl" finds "Lidl Dienstleistung GmbH"
+func Search(ctx context.Context, dbc *gorm.DB, userID uint, query string) ([]db.Merchant, error) {
+	queryWords := strings.Fields(Normalize(query))
+	if len(queryWords) == 0 {
+		return nil, nil
+	}
+	var merchants []db.Merchant
+	if err := dbc.WithContext(ctx).Preload("Aliases").Where("user_id = ?", userID).Order("name asc").Find(&merchants).Error; err != nil {
+		return nil, fmt.Errorf("fetching merchants: %w", errors.WithStack(err))
+	}
+
+	hasAll := func(name string) bool {
+		nameWords := map[string]bool{}
+		for _, word := range strings.Fields(name) {
+			nameWords[word] = true
+		}
+		for _, word := range queryWords {
+			if !nameWords[word] {
+				return false
+			}
+		}
+		return true
+	}
+	var found []db.Merchant
+	for _, merchant := range merchants {
+		matches := hasAll(merchant.NormalizedName)
+		for _, alias := range merchant.Aliases {
+			matches = matches || hasAll(alias.NormalizedAlias)
+		}
+		if matches {
+			found = append(found, merchant)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,29 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"gocloud.dev/blob"
+)
+
+// Stores the content provided by the user in the files bucket. Returns the key of the blob and its size
+func SaveBlob(ctx context.Context, files *blob.Bucket, userID uint, content io.Reader) (blobKey string, size int64, _ error) {
+	blobKey = fmt.Sprintf("%d/%s", userID, uuid.New().String())
+	w, err := files.NewWriter(ctx, blobKey, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("creating new file writer: %w", errors.WithStack(err))
+	}
+	size, err = io.Copy(w, content)
+	if err != nil {
+		w.Close()
+		return "", 0, fmt.Errorf("copying file to blob: %w", errors.WithStack(err))
+	}
+	if err := w.Close(); err != nil {
+		return "", 0, fmt.Errorf("closing writer: %w", errors.WithStack(err))
+	}
+	return blobKey, size, nil
+}
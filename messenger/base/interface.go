@@ -4,11 +4,17 @@ import (
 	"context"
 
 	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/reply"
 )
 
-type OnMessageCallback func(ctx context.Context, msg db.Message, response chan<- string)
+type OnMessageCallback func(ctx context.Context, msg db.Message, response chan<- reply.Chunk)
 
 type Client interface {
 	Listen(ctx context.Context)
 	OnMessage(callback OnMessageCallback)
 }
+
+// A client which can message a user on its own, not as a response. Notify returns false if the user can't be reached through the client
+type Notifier interface {
+	Notify(ctx context.Context, user db.User, text string) (bool, error)
+}
@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/export"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/EPecherkin/catty-counting/texts"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	USER_NAME = "cli"
+	PROMPT    = "> "
+)
+
+// Chat in the terminal for local development. A line with a path to an existing file attaches the file,
+// any other line sends the message with attached files
+type Client struct {
+	in        io.Reader
+	out       io.Writer
+	onMessage base.OnMessageCallback
+
+	user db.User
+
+	deps deps.Deps
+}
+
+func CreateClient(deps deps.Deps) (base.Client, error) {
+	deps.Logger = deps.Logger.With(log.CALLER, "messenger.cli.client")
+	deps.Logger.Debug("Creating cli client")
+	return &Client{in: os.Stdin, out: os.Stdout, deps: deps}, nil
+}
+
+func (client *Client) OnMessage(callback base.OnMessageCallback) {
+	client.onMessage = callback
+}
+
+func (client *Client) Listen(ctx context.Context) {
+	client.deps.Logger.Debug("Running cli client")
+	defer client.deps.Logger.Debug("Cli client finished")
+
+	user, err := client.findOrCreateUser(ctx)
+	if err != nil {
+		client.deps.Logger.With(log.ERROR, err).Error("Failed to find user")
+		return
+	}
+	client.user = user
+	client.deps.Logger = client.deps.Logger.With(log.USER_ID, user.ID)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(client.in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	var files []db.File
+	fmt.Fprint(client.out, PROMPT)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if file, ok := client.attachFile(ctx, line); ok {
+				files = append(files, file)
+				fmt.Fprintf(client.out, "attached %s, enter a message or an empty line to send\n%s", file.OriginalName, PROMPT)
+				continue
+			}
+			if line == "" && len(files) == 0 {
+				fmt.Fprint(client.out, PROMPT)
+				continue
+			}
+
+			message := db.Message{UserID: client.user.ID, Text: line, Direction: db.MessageDirectionFromUser}
+			if err := client.deps.DBC.WithContext(ctx).Create(&message).Error; err != nil {
+				client.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to create message")
+				fmt.Fprintf(client.out, "%s\n%s", texts.FAILED_TRY_AGAIN, PROMPT)
+				continue
+			}
+			for _, file := range files {
+				file.MessageID = message.ID
+				if err := client.deps.DBC.WithContext(ctx).Create(&file).Error; err != nil {
+					client.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to save file")
+					continue
+				}
+				message.Files = append(message.Files, file)
+			}
+			files = nil
+
+			client.respond(ctx, message)
+			fmt.Fprint(client.out, PROMPT)
+		}
+	}
+}
+
+// Stores the file from the path in the files bucket. Returns false if the line isn't a path to a file
+func (client *Client) attachFile(ctx context.Context, path string) (db.File, bool) {
+	if path == "" {
+		return db.File{}, false
+	}
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return db.File{}, false
+	}
+	logger := client.deps.Logger.With("path", path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("Failed to open file")
+		return db.File{}, false
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Warn("Failed to read file")
+		return db.File{}, false
+	}
+
+	blobKey, size, err := base.SaveBlob(ctx, client.deps.Files, client.user.ID, f)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Failed to save file")
+		return db.File{}, false
+	}
+	return db.File{
+		OriginalName: filepath.Base(path),
+		MimeType:     http.DetectContentType(head[:n]),
+		Size:         size,
+		BlobKey:      blobKey,
+	}, true
+}
+
+// Prints the response as it streams
+func (client *Client) respond(ctx context.Context, message db.Message) {
+	response := make(chan reply.Chunk)
+	go func() {
+		defer func() {
+			close(response)
+			if err := recover(); err != nil {
+				client.deps.Logger.With(log.ERROR, err).Error("failed to process onMessage")
+			}
+		}()
+		client.onMessage(ctx, message, response)
+	}()
+
+	responded := false
+	for chunk := range response {
+		if chunk.IsEmpty() {
+			continue
+		}
+		responded = true
+		text := chunk.Text
+		if chunk.Chart != nil {
+			text = "\n\n" + chunk.Chart.Text()
+		}
+		if len(chunk.Files) > 0 {
+			text = client.saveExport(chunk.Files)
+		}
+		fmt.Fprint(client.out, text)
+	}
+	if !responded {
+		fmt.Fprint(client.out, texts.FAILED_TRY_AGAIN)
+	}
+	fmt.Fprintln(client.out)
+}
+
+// Saves exported files to the working directory. Returns what was saved
+func (client *Client) saveExport(files []export.File) string {
+	var saved strings.Builder
+	for _, file := range files {
+		if err := os.WriteFile(file.Name, file.Data, 0o644); err != nil {
+			client.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to save export")
+			return "\n\nFailed to save " + file.Name
+		}
+		fmt.Fprintf(&saved, "\nSaved %s", file.Name)
+	}
+	return saved.String()
+}
+
+func (client *Client) findOrCreateUser(ctx context.Context) (db.User, error) {
+	var user db.User
+	err := client.deps.DBC.WithContext(ctx).Where("cli_name = ?", USER_NAME).First(&user).Error
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, errors.WithStack(err)
+	}
+	client.deps.Logger.Debug("Creating new user from cli")
+	name := USER_NAME
+	user = db.User{CliName: &name}
+	if err := client.deps.DBC.WithContext(ctx).Create(&user).Error; err != nil {
+		return user, errors.WithStack(err)
+	}
+	return user, nil
+}
+
+// Prints the notification, if it's for the user of the terminal
+func (client *Client) Notify(ctx context.Context, user db.User, text string) (bool, error) {
+	if user.CliName == nil || *user.CliName != USER_NAME {
+		return false, nil
+	}
+	fmt.Fprintf(client.out, "\n%s\n%s", text, PROMPT)
+	return true, nil
+}
@@ -0,0 +1,266 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/export"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/EPecherkin/catty-counting/texts"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	POLL_INTERVAL = 10 * time.Second
+	// Notifications are titled by their first line, cut to the length
+	MAX_SUBJECT_LENGTH = 78
+	// Failed emails are retried on the next polls, then moved to FAILED_DIR
+	MAX_ATTEMPTS = 5
+	// Maildir++ folder for emails which failed every attempt. Move them back to new to retry
+	FAILED_DIR = ".Failed"
+	// Reply to emails with a command in the subject
+	COMMANDS_REFUSED = "Commands aren't accepted by email, as anyone can send an email in your name. Send them in another messenger."
+)
+
+// Ingests emails delivered to a maildir, e.g. by fetchmail or a local MTA, and replies by SMTP
+type Client struct {
+	maildir        string
+	allowedSenders []string
+	onMessage      base.OnMessageCallback
+	// Failed attempts per email in new
+	attempts map[string]int
+
+	deps deps.Deps
+}
+
+func CreateClient(deps deps.Deps) (base.Client, error) {
+	deps.Logger = deps.Logger.With(log.CALLER, "messenger.email.client")
+	deps.Logger.Debug("Creating email client")
+	maildir := config.Maildir()
+	if maildir == "" {
+		return nil, errors.New("MAILDIR is missing")
+	}
+	allowedSenders := config.EmailAllowedSenders()
+	if len(allowedSenders) == 0 {
+		return nil, errors.New("EMAIL_ALLOWED_SENDERS is missing")
+	}
+	for _, dir := range []string{"new", "cur", "tmp", FAILED_DIR + "/new", FAILED_DIR + "/cur", FAILED_DIR + "/tmp"} {
+		if err := os.MkdirAll(filepath.Join(maildir, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("creating maildir: %w", errors.WithStack(err))
+		}
+	}
+	return &Client{maildir: maildir, allowedSenders: allowedSenders, attempts: map[string]int{}, deps: deps}, nil
+}
+
+func (client *Client) OnMessage(callback base.OnMessageCallback) {
+	client.onMessage = callback
+}
+
+func (client *Client) Listen(ctx context.Context) {
+	client.deps.Logger.With("maildir", client.maildir).Info("polling maildir")
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		client.poll(ctx)
+		select {
+		case <-ctx.Done():
+			client.deps.Logger.Debug("Email client finished")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handles new emails and moves them to cur, so each is handled once. Emails which failed before their message was saved
+// stay in new to be retried, up to MAX_ATTEMPTS, then they are moved to FAILED_DIR
+func (client *Client) poll(ctx context.Context) {
+	entries, err := os.ReadDir(filepath.Join(client.maildir, "new"))
+	if err != nil {
+		client.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to read maildir")
+		return
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.IsDir() {
+			continue
+		}
+		newPath := filepath.Join(client.maildir, "new", entry.Name())
+		logger := client.deps.Logger.With("email", entry.Name())
+		movePath := filepath.Join(client.maildir, "cur", entry.Name()+":2,S")
+		if err := client.handleEmail(ctx, newPath); err != nil {
+			client.attempts[entry.Name()]++
+			logger = logger.With(log.ERROR, err).With("attempts", client.attempts[entry.Name()])
+			if client.attempts[entry.Name()] < MAX_ATTEMPTS {
+				logger.Warn("Failed to handle email, will retry")
+				continue
+			}
+			logger.Error("Failed to handle email")
+			movePath = filepath.Join(client.maildir, FAILED_DIR, "cur", entry.Name()+":2,")
+		}
+		delete(client.attempts, entry.Name())
+		if err := os.Rename(newPath, movePath); err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to move email")
+		}
+	}
+}
+
+func (client *Client) handleEmail(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening email: %w", errors.WithStack(err))
+	}
+	defer f.Close()
+
+	parsed, err := parseEmail(f)
+	if err != nil {
+		return fmt.Errorf("parsing email: %w", err)
+	}
+
+	if !client.allowed(parsed.From) {
+		client.deps.Logger.With("from", parsed.From).Warn("Ignoring email from a sender who isn't allowed")
+		return nil
+	}
+	// the sender of an email can be forged, so commands, which change settings and data, aren't run from email
+	if strings.HasPrefix(strings.TrimSpace(parsed.Subject), "/") {
+		client.deps.Logger.With("from", parsed.From).Warn("Ignoring command from email")
+		if err := client.reply(parsed, COMMANDS_REFUSED, nil); err != nil {
+			client.deps.Logger.With(log.ERROR, err).Error("Failed to reply")
+		}
+		return nil
+	}
+	user, err := client.findOrCreateUser(ctx, parsed.From)
+	if err != nil {
+		return fmt.Errorf("finding user: %w", err)
+	}
+	logger := client.deps.Logger.With(log.USER_ID, user.ID)
+
+	message := db.Message{UserID: user.ID, Text: strings.TrimSpace(parsed.Subject), Direction: db.MessageDirectionFromUser}
+	if err := client.deps.DBC.WithContext(ctx).Create(&message).Error; err != nil {
+		return fmt.Errorf("creating message: %w", errors.WithStack(err))
+	}
+	logger = logger.With(log.MESSAGE_ID, message.ID)
+
+	parts := parsed.Parts
+	if parsed.HtmlBody != "" {
+		parts = append([]attachment{{Name: "email.html", MimeType: "text/html", Data: []byte(parsed.HtmlBody)}}, parts...)
+	} else if strings.TrimSpace(parsed.PlainBody) != "" {
+		parts = append([]attachment{{Name: "email.txt", MimeType: "text/plain", Data: []byte(parsed.PlainBody)}}, parts...)
+	}
+	for _, part := range parts {
+		blobKey, size, err := base.SaveBlob(ctx, client.deps.Files, user.ID, bytes.NewReader(part.Data))
+		if err != nil {
+			logger.With(log.ERROR, err).Error("Failed to save file")
+			continue
+		}
+		file := db.File{MessageID: message.ID, OriginalName: part.Name, MimeType: part.MimeType, Size: size, BlobKey: blobKey}
+		if err := client.deps.DBC.WithContext(ctx).Create(&file).Error; err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to save file")
+			continue
+		}
+		message.Files = append(message.Files, file)
+	}
+	logger.With("files", len(message.Files)).Debug("Message built from email")
+
+	responseText, files := client.respond(ctx, message)
+	// the email isn't retried once its message is saved, as it would be parsed and alerted about again
+	if err := client.reply(parsed, responseText, files); err != nil {
+		logger.With(log.ERROR, err).Error("Failed to reply")
+	}
+	return nil
+}
+
+// Collects the whole response, as email can't be streamed, with exported files to attach
+func (client *Client) respond(ctx context.Context, message db.Message) (string, []export.File) {
+	response := make(chan reply.Chunk)
+	go func() {
+		defer func() {
+			close(response)
+			if err := recover(); err != nil {
+				client.deps.Logger.With(log.ERROR, err).Error("failed to process onMessage")
+			}
+		}()
+		client.onMessage(ctx, message, response)
+	}()
+
+	var responseText string
+	var files []export.File
+	for chunk := range response {
+		if chunk.Chart != nil {
+			responseText += "\n\n" + chunk.Chart.Text()
+			continue
+		}
+		if len(chunk.Files) > 0 {
+			files = append(files, chunk.Files...)
+			responseText += "\n\nThe export is attached."
+			continue
+		}
+		responseText += chunk.Text
+	}
+	responseText = strings.TrimSpace(responseText)
+	if responseText == "" {
+		client.deps.Logger.Error("Response from LLM is empty.")
+		responseText = texts.FAILED_TRY_AGAIN
+	}
+	return responseText, files
+}
+
+// Emails the notification to users who came by email, if SMTP is set up
+func (client *Client) Notify(ctx context.Context, user db.User, text string) (bool, error) {
+	if user.Email == nil || !client.allowed(*user.Email) || config.SmtpAddr() == "" {
+		return false, nil
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(subject); len(runes) > MAX_SUBJECT_LENGTH {
+		subject = string(runes[:MAX_SUBJECT_LENGTH-1]) + "…"
+	}
+	if err := send(*user.Email, subject, "", text, nil); err != nil {
+		return false, fmt.Errorf("sending notification: %w", err)
+	}
+	client.deps.Logger.With(log.USER_ID, user.ID).Debug("sent notification to user")
+	return true, nil
+}
+
+// Whether the address is in EMAIL_ALLOWED_SENDERS, or its domain is
+func (client *Client) allowed(address string) bool {
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return false
+	}
+	for _, sender := range client.allowedSenders {
+		if sender == address || sender == "@"+domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Users are mapped to allowed senders only, see allowed
+func (client *Client) findOrCreateUser(ctx context.Context, address string) (db.User, error) {
+	var user db.User
+	err := client.deps.DBC.WithContext(ctx).Where("email = ?", address).First(&user).Error
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, errors.WithStack(err)
+	}
+	client.deps.Logger.Debug("Creating new user from email")
+	user = db.User{Email: &address}
+	if err := client.deps.DBC.WithContext(ctx).Create(&user).Error; err != nil {
+		return user, errors.WithStack(err)
+	}
+	return user, nil
+}
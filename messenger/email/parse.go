@@ -0,0 +1,125 @@
+package email
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An inbound email reduced to what we need for the pipeline
+type parsedEmail struct {
+	From      string
+	Subject   string
+	MessageID string
+	PlainBody string
+	HtmlBody  string
+	Parts     []attachment
+}
+
+type attachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+var wordDecoder = mime.WordDecoder{}
+
+// Parses an RFC 822 message
+func parseEmail(r io.Reader) (parsedEmail, error) {
+	var parsed parsedEmail
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return parsed, fmt.Errorf("reading message: %w", errors.WithStack(err))
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return parsed, fmt.Errorf("parsing sender: %w", errors.WithStack(err))
+	}
+	parsed.From = strings.ToLower(from.Address)
+	parsed.MessageID = msg.Header.Get("Message-Id")
+	parsed.Subject = msg.Header.Get("Subject")
+	if subject, err := wordDecoder.DecodeHeader(parsed.Subject); err == nil {
+		parsed.Subject = subject
+	}
+
+	if err := parsed.readPart(msg.Header, msg.Body); err != nil {
+		return parsed, err
+	}
+	return parsed, nil
+}
+
+type header interface {
+	Get(key string) string
+}
+
+// Walks through the part recursively, collecting bodies and attachments
+func (parsed *parsedEmail) readPart(h header, body io.Reader) error {
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading multipart: %w", errors.WithStack(err))
+			}
+			if err := parsed.readPart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("reading part: %w", errors.WithStack(err))
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+
+	isAttachment := disposition == "attachment" || name != ""
+	switch {
+	case !isAttachment && mediaType == "text/plain" && parsed.PlainBody == "":
+		parsed.PlainBody = string(data)
+	case !isAttachment && mediaType == "text/html" && parsed.HtmlBody == "":
+		parsed.HtmlBody = string(data)
+	default:
+		parsed.Parts = append(parsed.Parts, attachment{Name: name, MimeType: mediaType, Data: data})
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
@@ -0,0 +1,101 @@
+package email
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/export"
+	"github.com/pkg/errors"
+)
+
+// Sends the response back to the sender of the email, with the files attached
+func (client *Client) reply(parsed parsedEmail, text string, files []export.File) error {
+	if config.SmtpAddr() == "" {
+		client.deps.Logger.With("response", text).Warn("SMTP_ADDR is missing, not replying by email")
+		return nil
+	}
+	subject := parsed.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	if err := send(parsed.From, subject, parsed.MessageID, text, files); err != nil {
+		return err
+	}
+	client.deps.Logger.Debug("replied by email")
+	return nil
+}
+
+// Sends an email with the files attached, as a reply to the email with inReplyTo Message-Id, if it's set
+func send(to string, subject string, inReplyTo string, text string, files []export.File) error {
+	addr := config.SmtpAddr()
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", config.SmtpFrom())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	if inReplyTo != "" {
+		fmt.Fprintf(&msg, "In-Reply-To: %s\r\n", inReplyTo)
+		fmt.Fprintf(&msg, "References: %s\r\n", inReplyTo)
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	body := strings.ReplaceAll(text, "\n", "\r\n")
+	if len(files) == 0 {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		msg.WriteString("\r\n")
+		msg.WriteString(body)
+	} else if err := writeMultipart(&msg, body, files); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if config.SmtpUsername() != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("parsing SMTP_ADDR: %w", errors.WithStack(err))
+		}
+		auth = smtp.PlainAuth("", config.SmtpUsername(), config.SmtpPassword(), host)
+	}
+	if err := smtp.SendMail(addr, auth, config.SmtpFrom(), []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("sending email: %w", errors.WithStack(err))
+	}
+	return nil
+}
+
+// Writes the body and the files as a multipart/mixed message, starting with its Content-Type header
+func writeMultipart(msg *strings.Builder, body string, files []export.File) error {
+	writer := multipart.NewWriter(msg)
+	fmt.Fprintf(msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return fmt.Errorf("creating body part: %w", errors.WithStack(err))
+	}
+	if _, err := part.Write([]byte(body)); err != nil {
+		return fmt.Errorf("writing body part: %w", errors.WithStack(err))
+	}
+	for _, file := range files {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {file.MimeType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": file.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return fmt.Errorf("creating attachment part: %w", errors.WithStack(err))
+		}
+		encoded := base64.StdEncoding.EncodeToString(file.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("closing multipart: %w", errors.WithStack(err))
+	}
+	return nil
+}
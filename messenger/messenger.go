@@ -2,7 +2,10 @@ package messenger
 
 import (
 	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/messenger/cli"
+	"github.com/EPecherkin/catty-counting/messenger/email"
 	"github.com/EPecherkin/catty-counting/messenger/telegram"
+	"github.com/EPecherkin/catty-counting/messenger/web"
 )
 
 type Client interface {
@@ -10,3 +13,9 @@ type Client interface {
 }
 
 var CreateTelegramClient = telegram.CreateClient
+
+var CreateWebClient = web.CreateClient
+
+var CreateCliClient = cli.CreateClient
+
+var CreateEmailClient = email.CreateClient
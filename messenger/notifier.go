@@ -0,0 +1,62 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/pkg/errors"
+)
+
+// Delivers notifications through the first client which can reach the user, and keeps them in the history for LLM.
+// Telegram and email reach users any time, web chat only while it's open
+type Notifier struct {
+	clients []base.Notifier
+
+	deps deps.Deps
+}
+
+func NewNotifier(deps deps.Deps) *Notifier {
+	deps.Logger = deps.Logger.With(log.CALLER, "messenger.Notifier")
+	return &Notifier{deps: deps}
+}
+
+// Adds the client, if it can send notifications
+func (notifier *Notifier) Add(client Client) {
+	if n, ok := client.(base.Notifier); ok {
+		notifier.clients = append(notifier.clients, n)
+	}
+}
+
+func (notifier *Notifier) Notify(ctx context.Context, userID uint, text string) error {
+	logger := notifier.deps.Logger.With(log.USER_ID, userID)
+	var user db.User
+	if err := notifier.deps.DBC.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return fmt.Errorf("finding user: %w", errors.WithStack(err))
+	}
+	var failed error
+	for _, client := range notifier.clients {
+		sent, err := client.Notify(ctx, user, text)
+		if err != nil {
+			logger.With(log.ERROR, err).Warn("failed to notify through a messenger, trying the next one")
+			failed = err
+			continue
+		}
+		if !sent {
+			continue
+		}
+		message := db.Message{UserID: userID, Text: text, Direction: db.MessageDirectionToUser}
+		if err := notifier.deps.DBC.WithContext(ctx).Create(&message).Error; err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Warn("failed to save notification")
+		}
+		return nil
+	}
+	if failed != nil {
+		return fmt.Errorf("no messenger could notify the user: %w", failed)
+	}
+	logger.Warn("no messenger can reach the user, notification dropped")
+	return nil
+}
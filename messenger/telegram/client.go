@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/messenger/base"
@@ -31,6 +32,9 @@ func CreateClient(deps deps.Deps) (base.Client, error) {
 	deps.Logger = deps.Logger.With(log.CALLER, "messenger.telegram.client")
 	deps.Logger.Debug("Creating telegram client")
 	token := config.TelegramToken()
+	if token == "" {
+		return nil, errors.New("TELEGRAM_TOKEN is missing")
+	}
 	tgbot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		return nil, fmt.Errorf("creating telegram bot client: %w", errors.WithStack(err))
@@ -88,3 +92,14 @@ func (client *Client) receiverFor(ctx context.Context, userID int64) *Receiver {
 	}
 	return handler
 }
+
+func (client *Client) Notify(ctx context.Context, user db.User, text string) (bool, error) {
+	if user.TelegramID == nil {
+		return false, nil
+	}
+	if _, err := client.tgbot.Send(tgbotapi.NewMessage(*user.TelegramID, text)); err != nil {
+		return false, fmt.Errorf("sending notification: %w", errors.WithStack(err))
+	}
+	client.deps.Logger.With(log.USER_ID, user.ID).Debug("sent notification to user")
+	return true, nil
+}
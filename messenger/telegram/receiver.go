@@ -3,7 +3,6 @@ package telegram
 import (
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -12,8 +11,8 @@ import (
 	"github.com/EPecherkin/catty-counting/deps"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/reply"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 )
@@ -53,13 +52,13 @@ func (receiver *Receiver) GoReceiveMessages(ctx context.Context) {
 	}()
 
 	var user db.User
-	if err := receiver.deps.DBC.First(&user, db.User{TelegramID: receiver.telegramUserID}).Error; err != nil {
+	if err := receiver.deps.DBC.Where("telegram_id = ?", receiver.telegramUserID).First(&user).Error; err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			receiver.deps.Logger.With(log.ERROR, err).Error("Failed to query user")
 			return
 		} else {
 			receiver.deps.Logger.Debug("Creating new user from telegram")
-			user = db.User{TelegramID: receiver.telegramUserID}
+			user = db.User{TelegramID: &receiver.telegramUserID}
 			if err := receiver.deps.DBC.Create(&user).Error; err != nil {
 				receiver.deps.Logger.With(log.ERROR, err).Error("Failed to create user")
 				return
@@ -97,7 +96,7 @@ func (receiver *Receiver) GoReceiveMessages(ctx context.Context) {
 			}
 			receiver.deps.Logger = logger
 
-			if tMessage.Audio != nil || len(tMessage.Entities) > 0 || tMessage.Voice != nil || tMessage.Video != nil || tMessage.VideoNote != nil || tMessage.Sticker != nil || tMessage.Contact != nil || tMessage.Location != nil || tMessage.Venue != nil || tMessage.Poll != nil || tMessage.Dice != nil || tMessage.Invoice != nil {
+			if tMessage.Audio != nil || (len(tMessage.Entities) > 0 && !tMessage.IsCommand()) || tMessage.Voice != nil || tMessage.Video != nil || tMessage.VideoNote != nil || tMessage.Sticker != nil || tMessage.Contact != nil || tMessage.Location != nil || tMessage.Venue != nil || tMessage.Poll != nil || tMessage.Dice != nil || tMessage.Invoice != nil {
 				receiver.deps.Logger.With("update", update).Warn("received unusual content")
 				preResponse = "Sorry, I don't yet know how to work with that, but I'll do my best."
 			}
@@ -182,7 +181,7 @@ func (receiver *Receiver) GoReceiveMessages(ctx context.Context) {
 			responder := NewResponder(closeF, *message, receiver.onMessage, receiver, receiver.deps)
 			receiver.responder = responder
 			go responder.GoRespond(responseCtx)
-			responder.response <- preResponse
+			responder.response <- reply.Text(preResponse)
 			receiver.deps.Logger.Debug("responder started working")
 			message = nil
 		case <-ctx.Done():
@@ -208,16 +207,9 @@ func (receiver *Receiver) downloadFile(ctx context.Context, telegramID string) (
 	}
 	defer resp.Body.Close()
 
-	blobKey = fmt.Sprintf("%d/%s", receiver.user.ID, uuid.New().String())
-	w, err := receiver.deps.Files.NewWriter(ctx, blobKey, nil)
+	blobKey, _, err = base.SaveBlob(ctx, receiver.deps.Files, receiver.user.ID, resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("creating new file writer: %w", err)
-	}
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		return "", fmt.Errorf("copying file to blob: %w", err)
-	}
-	if err := w.Close(); err != nil {
-		return "", fmt.Errorf("closing writer: %w", err)
+		return "", fmt.Errorf("saving file: %w", err)
 	}
 
 	return blobKey, nil
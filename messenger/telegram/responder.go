@@ -6,10 +6,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/EPecherkin/catty-counting/charts"
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/export"
 	"github.com/EPecherkin/catty-counting/log"
 	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/reply"
 	"github.com/EPecherkin/catty-counting/texts"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
@@ -26,7 +29,7 @@ type Responder struct {
 
 	close func()
 
-	response chan string
+	response chan reply.Chunk
 
 	receiver *Receiver
 
@@ -36,7 +39,7 @@ type Responder struct {
 func NewResponder(close func(), message db.Message, onMessage base.OnMessageCallback, receiver *Receiver, deps deps.Deps) *Responder {
 	deps.Logger = deps.Logger.With(log.CALLER, "messenger.telegram.Responder")
 
-	return &Responder{close: close, message: message, onMessage: onMessage, receiver: receiver, deps: deps, response: make(chan string)}
+	return &Responder{close: close, message: message, onMessage: onMessage, receiver: receiver, deps: deps, response: make(chan reply.Chunk)}
 }
 
 func (resp *Responder) GoRespond(ctx context.Context) {
@@ -56,6 +59,7 @@ func (resp *Responder) GoRespond(ctx context.Context) {
 
 	var responseText string
 	var sentText string
+	attached := false
 
 	updater := time.NewTicker(EDIT_INTERVAL)
 	defer updater.Stop()
@@ -76,6 +80,12 @@ func (resp *Responder) GoRespond(ctx context.Context) {
 		case chunk, ok := <-resp.response:
 			if !ok {
 				resp.deps.Logger.Debug("response channel closed")
+				if responseText == "" && attached {
+					if err = resp.deleteMessage(responseMessage); err != nil {
+						resp.deps.Logger.With(log.ERROR, err).Error("Failed to delete thinking message")
+					}
+					return
+				}
 				if responseText == "" {
 					resp.deps.Logger.Error("Response from LLM is empty.")
 					responseText = texts.FAILED_TRY_AGAIN
@@ -85,8 +95,26 @@ func (resp *Responder) GoRespond(ctx context.Context) {
 				}
 				return
 			}
-			resp.deps.Logger.With("chunk", lo.Substring(chunk, 0, 10)).Debug("attaching chunk to response message")
-			responseText += chunk
+			if chunk.Chart != nil {
+				if err = resp.sendChart(*chunk.Chart); err != nil {
+					resp.deps.Logger.With(log.ERROR, err).Error("Failed to send chart")
+					responseText += "\n\n" + texts.FAILED_TRY_AGAIN
+					continue
+				}
+				attached = true
+				continue
+			}
+			if len(chunk.Files) > 0 {
+				if err = resp.sendExport(chunk.Files); err != nil {
+					resp.deps.Logger.With(log.ERROR, err).Error("Failed to send export")
+					responseText += "\n\n" + texts.FAILED_TRY_AGAIN
+					continue
+				}
+				attached = true
+				continue
+			}
+			resp.deps.Logger.With("chunk", lo.Substring(chunk.Text, 0, 10)).Debug("attaching chunk to response message")
+			responseText += chunk.Text
 		case <-updater.C:
 			if responseText != sentText {
 				lastUpdate = time.Now()
@@ -129,6 +157,40 @@ func (resp *Responder) sendMessage(text string) (*tgbotapi.Message, error) {
 	return &message, nil
 }
 
+// Sends the chart as a photo
+func (resp *Responder) sendChart(chart charts.Chart) error {
+	png, err := charts.RenderPNG(chart)
+	if err != nil {
+		return fmt.Errorf("rendering chart: %w", err)
+	}
+	photo := tgbotapi.NewPhoto(resp.receiver.telegramUserID, tgbotapi.FileBytes{Name: "chart.png", Bytes: png})
+	photo.Caption = chart.Title
+	if _, err := resp.receiver.client.tgbot.Send(photo); err != nil {
+		return fmt.Errorf("sending photo: %w", errors.WithStack(err))
+	}
+	resp.deps.Logger.Debug("sent chart to user")
+	return nil
+}
+
+// Sends exported files as documents
+func (resp *Responder) sendExport(files []export.File) error {
+	for _, file := range files {
+		document := tgbotapi.NewDocument(resp.receiver.telegramUserID, tgbotapi.FileBytes{Name: file.Name, Bytes: file.Data})
+		if _, err := resp.receiver.client.tgbot.Send(document); err != nil {
+			return fmt.Errorf("sending document: %w", errors.WithStack(err))
+		}
+	}
+	resp.deps.Logger.With("files", len(files)).Debug("sent export to user")
+	return nil
+}
+
+func (resp *Responder) deleteMessage(message *tgbotapi.Message) error {
+	if _, err := resp.receiver.client.tgbot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+		return fmt.Errorf("deleting message: %w", errors.WithStack(err))
+	}
+	return nil
+}
+
 func (resp *Responder) editMessage(message *tgbotapi.Message, text string) error {
 	if message.Text == text {
 		return nil
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// Time to send the token after connecting
+	AUTH_TIMEOUT = 30 * time.Second
+)
+
+//go:embed index.html
+var indexPage []byte
+
+// Chat in a browser over WebSocket. Connections are served by the API, see Routes
+type Client struct {
+	onMessage base.OnMessageCallback
+	// Closed when Listen finishes, so open sessions can stop
+	done chan struct{}
+
+	sessionsMu sync.Mutex
+	// Open sessions per user, to send notifications to
+	sessions map[uint][]*Session
+
+	deps deps.Deps
+}
+
+func CreateClient(deps deps.Deps) (*Client, error) {
+	deps.Logger = deps.Logger.With(log.CALLER, "messenger.web.client")
+	deps.Logger.Debug("Creating web client")
+	return &Client{deps: deps, done: make(chan struct{}), sessions: map[uint][]*Session{}}, nil
+}
+
+func (client *Client) OnMessage(callback base.OnMessageCallback) {
+	client.onMessage = callback
+}
+
+func (client *Client) Listen(ctx context.Context) {
+	client.deps.Logger.Debug("Running web client")
+	<-ctx.Done()
+	close(client.done)
+	client.deps.Logger.Debug("Web client finished")
+}
+
+// Mounts the chat page and the WebSocket endpoint
+func (client *Client) Routes(router gin.IRouter) {
+	router.GET("/chat", client.providePage)
+	router.GET("/api/chat/ws", client.connect)
+}
+
+func (client *Client) providePage(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", indexPage)
+}
+
+func (client *Client) connect(c *gin.Context) {
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		user, err := client.authenticate(c.Request.Context(), ws)
+		if err != nil {
+			client.deps.Logger.With(log.ERROR, err).Debug("Failed to authenticate web session")
+			websocket.JSON.Send(ws, outFrame{Type: FRAME_ERROR, Text: "Invalid token. Get one with /token command in another messenger."})
+			ws.Close()
+			return
+		}
+		session := NewSession(ws, user, client, client.deps)
+		client.addSession(session)
+		defer client.removeSession(session)
+		session.Serve(c.Request.Context())
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// Finds the user by the API token in the first frame. Browsers can't set headers of WebSocket requests,
+// and query strings end up in logs
+func (client *Client) authenticate(ctx context.Context, ws *websocket.Conn) (db.User, error) {
+	if err := ws.SetReadDeadline(time.Now().Add(AUTH_TIMEOUT)); err != nil {
+		return db.User{}, errors.WithStack(err)
+	}
+	var frame authFrame
+	if err := websocket.JSON.Receive(ws, &frame); err != nil {
+		return db.User{}, fmt.Errorf("receiving auth frame: %w", errors.WithStack(err))
+	}
+	if err := ws.SetReadDeadline(time.Time{}); err != nil {
+		return db.User{}, errors.WithStack(err)
+	}
+	token := strings.TrimSpace(frame.Token)
+	if token == "" {
+		return db.User{}, errors.New("missing token")
+	}
+	user, err := db.FindUserByApiToken(ctx, client.deps.DBC, token)
+	if err != nil {
+		return user, fmt.Errorf("finding user by token: %w", err)
+	}
+	return user, nil
+}
+
+func (client *Client) addSession(session *Session) {
+	client.sessionsMu.Lock()
+	defer client.sessionsMu.Unlock()
+	client.sessions[session.user.ID] = append(client.sessions[session.user.ID], session)
+}
+
+func (client *Client) removeSession(session *Session) {
+	client.sessionsMu.Lock()
+	defer client.sessionsMu.Unlock()
+	client.sessions[session.user.ID] = lo.Without(client.sessions[session.user.ID], session)
+	if len(client.sessions[session.user.ID]) == 0 {
+		delete(client.sessions, session.user.ID)
+	}
+}
+
+// Sends the notification to open sessions of the user. Users without one can't be reached
+func (client *Client) Notify(ctx context.Context, user db.User, text string) (bool, error) {
+	client.sessionsMu.Lock()
+	sessions := slices.Clone(client.sessions[user.ID])
+	client.sessionsMu.Unlock()
+	for _, session := range sessions {
+		session.send(outFrame{Type: FRAME_NOTIFICATION, Text: text})
+	}
+	if len(sessions) > 0 {
+		client.deps.Logger.With(log.USER_ID, user.ID).Debug("sent notification to user")
+	}
+	return len(sessions) > 0, nil
+}
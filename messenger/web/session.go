@@ -0,0 +1,203 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/EPecherkin/catty-counting/charts"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/EPecherkin/catty-counting/messenger/base"
+	"github.com/EPecherkin/catty-counting/reply"
+	"github.com/EPecherkin/catty-counting/texts"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	FRAME_CHUNK = "chunk"
+	// A chart as an SVG picture in the text
+	FRAME_CHART = "chart"
+	// A file to download, like an export
+	FRAME_FILE  = "file"
+	FRAME_DONE  = "done"
+	FRAME_ERROR = "error"
+	// A message on the initiative of the bot, like a budget alert
+	FRAME_NOTIFICATION = "notification"
+)
+
+// The first message from the browser, with the API token of the user
+type authFrame struct {
+	Token string `json:"token"`
+}
+
+// A message from the browser
+type inFrame struct {
+	Text  string   `json:"text"`
+	Files []inFile `json:"files"`
+}
+
+type inFile struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// A piece of the response to the browser
+type outFrame struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// Serves a single WebSocket connection of a user
+type Session struct {
+	ws     *websocket.Conn
+	user   db.User
+	client *Client
+
+	sendMu sync.Mutex
+	// Interrupts the response in progress
+	cancelResponse func()
+
+	deps deps.Deps
+}
+
+func NewSession(ws *websocket.Conn, user db.User, client *Client, deps deps.Deps) *Session {
+	deps.Logger = deps.Logger.With(log.CALLER, "messenger.web.Session").With(log.USER_ID, user.ID)
+	return &Session{ws: ws, user: user, client: client, deps: deps, cancelResponse: func() {}}
+}
+
+func (session *Session) Serve(ctx context.Context) {
+	session.deps.Logger.Debug("serving web session")
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if err := recover(); err != nil {
+			session.deps.Logger.With(log.ERROR, err).Error("panic in Serve")
+		}
+		session.cancelResponse()
+		cancel()
+		session.ws.Close()
+		session.deps.Logger.Debug("web session finished")
+	}()
+	go func() {
+		select {
+		case <-session.client.done:
+			cancel()
+			session.ws.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		var frame inFrame
+		if err := websocket.JSON.Receive(session.ws, &frame); err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				session.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to receive frame")
+			}
+			return
+		}
+
+		message, err := session.buildMessage(ctx, frame)
+		if err != nil {
+			session.deps.Logger.With(log.ERROR, err).Error("Failed to build message")
+			session.send(outFrame{Type: FRAME_ERROR, Text: texts.FAILED_TRY_AGAIN})
+			continue
+		}
+
+		session.cancelResponse()
+		responseCtx, cancelResponse := context.WithCancel(ctx)
+		session.cancelResponse = cancelResponse
+		go session.respond(responseCtx, message)
+	}
+}
+
+// Persists the message with its files
+func (session *Session) buildMessage(ctx context.Context, frame inFrame) (db.Message, error) {
+	message := db.Message{UserID: session.user.ID, Text: strings.TrimSpace(frame.Text), Direction: db.MessageDirectionFromUser}
+	if err := session.deps.DBC.WithContext(ctx).Create(&message).Error; err != nil {
+		return message, errors.WithStack(err)
+	}
+	logger := session.deps.Logger.With(log.MESSAGE_ID, message.ID)
+
+	for _, f := range frame.Files {
+		blobKey, size, err := base.SaveBlob(ctx, session.deps.Files, session.user.ID, bytes.NewReader(f.Data))
+		if err != nil {
+			logger.With(log.ERROR, err).Error("Failed to save file")
+			continue
+		}
+		file := db.File{
+			MessageID:    message.ID,
+			OriginalName: f.Name,
+			MimeType:     f.MimeType,
+			Size:         size,
+			BlobKey:      blobKey,
+		}
+		if err := session.deps.DBC.WithContext(ctx).Create(&file).Error; err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to save file")
+			continue
+		}
+		message.Files = append(message.Files, file)
+	}
+	logger.With("files", len(message.Files)).Debug("Message built")
+	return message, nil
+}
+
+// Streams the response to the message back to the browser
+func (session *Session) respond(ctx context.Context, message db.Message) {
+	logger := session.deps.Logger.With(log.MESSAGE_ID, message.ID)
+	response := make(chan reply.Chunk)
+	go func() {
+		defer func() {
+			close(response)
+			if err := recover(); err != nil {
+				logger.With(log.ERROR, err).Error("failed to process onMessage")
+			}
+		}()
+		session.client.onMessage(ctx, message, response)
+	}()
+
+	responded := false
+	for chunk := range response {
+		// keep draining after interruption, so onMessage doesn't block
+		if ctx.Err() != nil || chunk.IsEmpty() {
+			continue
+		}
+		responded = true
+		if chunk.Chart != nil {
+			session.send(outFrame{Type: FRAME_CHART, Text: string(charts.RenderSVG(*chunk.Chart))})
+			continue
+		}
+		if len(chunk.Files) > 0 {
+			for _, file := range chunk.Files {
+				session.send(outFrame{Type: FRAME_FILE, Name: file.Name, MimeType: file.MimeType, Data: file.Data})
+			}
+			continue
+		}
+		session.send(outFrame{Type: FRAME_CHUNK, Text: chunk.Text})
+	}
+	if ctx.Err() != nil {
+		logger.Debug("response interrupted")
+		return
+	}
+	if !responded {
+		logger.Error("Response from LLM is empty.")
+		session.send(outFrame{Type: FRAME_ERROR, Text: texts.FAILED_TRY_AGAIN})
+		return
+	}
+	session.send(outFrame{Type: FRAME_DONE})
+}
+
+func (session *Session) send(frame outFrame) {
+	session.sendMu.Lock()
+	defer session.sendMu.Unlock()
+	if err := websocket.JSON.Send(session.ws, frame); err != nil {
+		session.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("Failed to send frame")
+	}
+}
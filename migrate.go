@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/EPecherkin/catty-counting/config"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/log"
+	"gorm.io/gorm"
+)
+
+const MIGRATE_USAGE = "usage: migrate [up | down [steps] | status]"
+
+// Config, logger and database for subcommands. With migrated, fails if any migration is pending
+func initializeCommand(migrated bool) (*slog.Logger, *gorm.DB, error) {
+	if err := config.Init(); err != nil {
+		return log.NewLogger(), nil, fmt.Errorf("initializing config: %w", err)
+	}
+	logger := log.NewLogger()
+	open := db.Open
+	if migrated {
+		open = db.NewConnection
+	}
+	dbc, err := open()
+	if err != nil {
+		return logger, nil, fmt.Errorf("initializing database connection: %w", err)
+	}
+	return logger, dbc, nil
+}
+
+// Handles `migrate` subcommand. Returns exit code
+func runMigrate(args []string) int {
+	logger, dbc, err := initializeCommand(false)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Initialization failed")
+		return 1
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	var statuses []db.MigrationStatus
+	switch action {
+	case "up":
+		statuses, err = db.Migrate(dbc)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Println(MIGRATE_USAGE)
+				return 2
+			}
+		}
+		statuses, err = db.Rollback(dbc, steps)
+	case "status":
+		statuses, err = db.Status(dbc)
+	default:
+		fmt.Println(MIGRATE_USAGE)
+		return 2
+	}
+
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied"
+		}
+		fmt.Printf("%4d %-8s %s\n", s.Version, state, s.Name)
+	}
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Migration failed")
+		return 1
+	}
+	return 0
+}
@@ -1,10 +1,13 @@
 package prompts
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/EPecherkin/catty-counting/categories"
 	"github.com/EPecherkin/catty-counting/db"
 	"github.com/EPecherkin/catty-counting/llm"
 	"github.com/samber/lo"
@@ -13,21 +16,27 @@ import (
 )
 
 const (
-	ASSISTANT_INSTRUCTIONS = `You are an accounting helping assistant, which is capable of processing docs, receipts, building statistics and giving advices. Receiving a message from user, you should analyze if you have necessary details in your context to provide good answer. In case you need any more data about the user - ask user about it. You also have access to tools to retrieve stored information about the user and past interations. Keep your answers reasonably short. Don't propose to do something that you don't have tools to do.`
-	SUMMARIZE_FILE         = `Confirm with a short symmary what files and receipts you have received. 10 words per file max.`
+	ASSISTANT_INSTRUCTIONS = `You are an accounting helping assistant, which is capable of processing docs, receipts, building statistics and giving advices. Receiving a message from user, you should analyze if you have necessary details in your context to provide good answer. In case you need any more data about the user - ask user about it. You also have access to tools to retrieve stored information about the user and past interations. Keep your answers reasonably short. Don't propose to do something that you don't have tools to do. When the user reports a payment made without a receipt, record it with a tool and confirm back what was recorded: amount, currency, date and categories. When a receipt has "duplicate_of", tell the user it looks like they already sent it on the "sent_at" date, e.g. "looks like you already sent this on 3 May — keep both?", and apply the answer with a tool. When the user says a product is in a wrong category, move it with a tool and mention that similar products will get that category from now on.`
+	SUMMARIZE_FILE         = `Confirm with a short symmary what files and receipts you have received. 10 words per file max. If receipts or products have "unsure" fields, list these values and ask the user to check them.`
 )
 
-var PROMPT_PARSE_FILE = ""
+// The part of the parse prompt common for all users
+var parseFileStructure = ""
 
-func Init(dbc *gorm.DB) error {
+func Init() error {
 	preFileStructure := `You are an accounting helper tool that extracts financial data from documents, receipts, invoices and etc. Focus on data useful for accounting and financial analyzis.
 Parse data from the provided file to the exact JSON structure:`
 	file := llm.File4Llm{
 		Summary: "a short summary about the file. 50 words max",
 		Receipts: []llm.Receipt4Llm{
 			{
-				OccuredAt:      time.Now(),
-				Origin:         "store name; address; phone; email; other info about the store from the receipt",
+				OccuredAt: time.Now(),
+				Origin:    "store name; address; phone; email; other info about the store from the receipt",
+				Merchant: &llm.Merchant4Llm{
+					Name:    "store or company name, as printed",
+					Address: "address of the store",
+					TaxID:   "VAT or tax ID of the store",
+				},
 				Recipient:      "last name first name; address; phone; email; other info about the recepient of the receipt",
 				Currency:       "3 letter currency code of the receipt",
 				TotalBeforeTax: decimal.NewFromFloat(0.0),
@@ -35,18 +44,37 @@ Parse data from the provided file to the exact JSON structure:`
 				TotalWithTax:   decimal.NewFromFloat(0.0),
 				Details:        "any other details of what is this receipt about that didn't fit to the other fields",
 				Summary:        "a short summary about the receipt. 50 words max",
+				TaxLines: []llm.TaxLine4Llm{
+					{Rate: decimal.NewFromFloat(19), Base: decimal.NewFromFloat(0.0), Amount: decimal.NewFromFloat(0.0)},
+				},
 				Products: []llm.Product4Llm{
 					{
-						Title:          "product's title or name",
-						Details:        "additional details about a particular product, if any",
+						Title:    "product's title or name",
+						Details:  "additional details about a particular product, if any",
+						LineType: "item, discount, deposit, fee or tip",
+						Catalog: &llm.CatalogProduct4Llm{
+							Name: "generic name of the product without brand and package size, in the language of the receipt, e.g. Milk 3.5%",
+							Unit: "unit of the package size: g, kg, ml, l or pcs",
+							Size: decimal.NewFromFloat(1.5),
+						},
+						Quantity:       decimal.NewFromFloat(1),
+						Unit:           "unit of the quantity: pcs, kg, l and etc.",
+						UnitPrice:      decimal.NewFromFloat(0.0),
+						Discount:       decimal.NewFromFloat(0.0),
 						TotalBeforeTax: decimal.NewFromFloat(0.0),
+						TaxRate:        decimal.NewFromFloat(19),
 						Tax:            decimal.NewFromFloat(0.0),
 						TotalWithTax:   decimal.NewFromFloat(0.0),
 						Categories: []llm.Category4Llm{
 							{Title: "Category"},
 						},
+						Line:       1,
+						Box:        &llm.Box4Llm{Page: 1, X: 0.1, Y: 0.3, Width: 0.8, Height: 0.02},
+						Confidence: llm.Confidence4Llm{"total_with_tax": 0.5},
 					},
 				},
+				Box:        &llm.Box4Llm{Page: 1, X: 0.05, Y: 0.05, Width: 0.9, Height: 0.9},
+				Confidence: llm.Confidence4Llm{"occured_at": 0.4},
 			},
 		},
 	}
@@ -56,18 +84,21 @@ Parse data from the provided file to the exact JSON structure:`
 	}
 
 	explanation := `Values of the fields are for reference. If you can't parse a value for a field - omit it.
-Assign 1-4 categories to each product. Do not create new categories, use this list only:`
-	var categories []db.Category
-	if err := dbc.Find(&categories).Error; err != nil {
-		return fmt.Errorf("fetching categories: %w", err)
-	}
-	parsedCategories := lo.Map(categories, func(category db.Category, _ int) llm.Category4Llm {
-		return llm.Category4Llm{Title: category.Title, Details: category.Details}
-	})
-	categoryList, err := json.Marshal(parsedCategories)
+Every line of the receipt is a product, with its line_type. For "3 x 1.99" lines put quantity 3 and unit_price 1.99. Totals of a product are what was paid for it, after its discount, and discount is the amount taken off. Discounts on a separate line, like a loyalty discount, are products with line_type discount and negative totals. Bottle deposits, service fees and tips are products with line_type deposit, fee and tip.
+Put to confidence of a receipt or a product the fields you are unsure about, e.g. blurred, cut or handwritten, with your confidence from 0 to 1. Omit the fields you are sure about. Put to line the number of the product's line on the receipt, counting from 1. Put to box where the receipt or the product is on an image or a page: the page counting from 1, x and y of the top left corner, width and height, all as fractions of the page size. Omit box for text files.
+Put the tax summary of the receipt to tax_lines, one per tax rate, with the rate in percent, the amount before tax it applies to and the tax amount. Put the tax rate of each product to tax_rate, receipts usually mark it with a letter next to the price.
+`
+	parseFileStructure = preFileStructure + string(fileStructure) + explanation
+	return nil
+}
+
+// Builds the prompt to parse a file of the user, with categories of the user
+func ParseFile(ctx context.Context, dbc *gorm.DB, userID uint) (string, error) {
+	userCategories, err := categories.ForUser(ctx, dbc, userID)
 	if err != nil {
-		return fmt.Errorf("marshaling categories: %w", err)
+		return "", fmt.Errorf("fetching categories: %w", err)
 	}
-	PROMPT_PARSE_FILE = preFileStructure + string(fileStructure) + explanation + string(categoryList)
-	return nil
+	categoryList := strings.Join(lo.Map(userCategories, func(category db.Category, _ int) string { return categories.Path(category) }), "\n")
+	instruction := fmt.Sprintf(`Assign 1-4 categories to each product, the most specific ones. Subcategories follow their category after %q. Do not create new categories, use this list only, a category per line:`, strings.TrimSpace(categories.PATH_SEPARATOR))
+	return parseFileStructure + instruction + "\n" + categoryList, nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EPecherkin/catty-counting/currency"
+	"github.com/EPecherkin/catty-counting/log"
+)
+
+const RATES_USAGE = "usage: rates <eurofxref-hist.xml | rates.csv | URL>"
+
+// Handles `rates` subcommand: loads exchange rates and converts receipts waiting for them. Returns exit code
+func runRates(args []string) int {
+	if len(args) != 1 {
+		fmt.Println(RATES_USAGE)
+		return 2
+	}
+	fetcher, err := currency.NewFetcher(args[0])
+	if err != nil {
+		fmt.Println(RATES_USAGE)
+		return 2
+	}
+	logger, dbc, err := initializeCommand(true)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Initialization failed")
+		return 1
+	}
+
+	ctx := context.Background()
+	rates, err := fetcher.Fetch(ctx)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Fetching rates failed")
+		return 1
+	}
+	if err := currency.Store(ctx, dbc, rates); err != nil {
+		logger.With(log.ERROR, err).Error("Storing rates failed")
+		return 1
+	}
+	fmt.Printf("%d rates loaded\n", len(rates))
+
+	converted, err := currency.ConvertPending(ctx, dbc, logger)
+	fmt.Printf("%d receipts converted\n", converted)
+	if err != nil {
+		logger.With(log.ERROR, err).Error("Converting receipts failed")
+		return 1
+	}
+	return 0
+}
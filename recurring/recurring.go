@@ -0,0 +1,258 @@
+package recurring
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/scheduler"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// How much a charge may differ from the next one of the same recurring expense, as a fraction of the next one
+	AMOUNT_TOLERANCE = 0.2
+	// When missed charges are checked, in the timezone of the user
+	CHECK_CRON  = "0 10 * * *"
+	DATE_FORMAT = "Jan 2"
+)
+
+// How often a recurring expense is charged. Charges may come up to tolerance days earlier or later
+type cadence struct {
+	kind           db.RecurringCadence
+	months         int
+	days           int
+	tolerance      int
+	minOccurrences int
+}
+
+// Cadences to look for, shortest first. Yearly charges need fewer receipts, there are few of them in the history
+var cadences = []cadence{
+	{kind: db.RecurringCadenceWeekly, days: 7, tolerance: 2, minOccurrences: 3},
+	{kind: db.RecurringCadenceMonthly, months: 1, tolerance: 4, minOccurrences: 3},
+	{kind: db.RecurringCadenceQuarterly, months: 3, tolerance: 10, minOccurrences: 3},
+	{kind: db.RecurringCadenceYearly, months: 12, tolerance: 15, minOccurrences: 2},
+}
+
+func (c cadence) after(t time.Time) time.Time {
+	return t.AddDate(0, c.months, c.days)
+}
+
+func cadenceOf(kind db.RecurringCadence) cadence {
+	c, _ := lo.Find(cadences, func(c cadence) bool { return c.kind == kind })
+	return c
+}
+
+type seriesKey struct {
+	merchantID uint
+	currency   string
+}
+
+// Charges of a recurring expense, the latest first
+type chain struct {
+	cadence cadence
+	charges []db.Receipt
+}
+
+// Recurring expenses of the user with their merchants, the next expected first
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint) ([]db.RecurringExpense, error) {
+	var expenses []db.RecurringExpense
+	if err := dbc.WithContext(ctx).Preload("Merchant", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Where("user_id = ?", userID).Order("next_expected_at asc").Find(&expenses).Error; err != nil {
+		return nil, fmt.Errorf("fetching recurring expenses: %w", errors.WithStack(err))
+	}
+	return expenses, nil
+}
+
+// Finds charges of the same merchant for about the same amount repeating every week, month, quarter or year in receipts of the user,
+// and stores them as recurring expenses. Receipts LLM was unsure of are left out. Returns alerts about recurring expenses which became more expensive
+func Detect(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time) ([]string, error) {
+	dbc = dbc.WithContext(ctx)
+	var receipts []db.Receipt
+	if err := dbc.Select("id", "merchant_id", "occured_at", "total_with_tax", "currency").
+		Where("user_id = ? AND merchant_id IS NOT NULL AND duplicate_of_id IS NULL AND unsure = ?", user.ID, false).
+		Order("occured_at asc").Find(&receipts).Error; err != nil {
+		return nil, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	series := lo.GroupBy(receipts, func(r db.Receipt) seriesKey { return seriesKey{*r.MerchantID, r.Currency} })
+
+	expenses, err := ForUser(ctx, dbc, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	existing := lo.KeyBy(expenses, func(e db.RecurringExpense) seriesKey { return seriesKey{e.MerchantID, e.Currency} })
+
+	var alerts []string
+	for key, charges := range series {
+		found, ok := longestChain(charges)
+		if !ok {
+			continue
+		}
+		last := found.charges[0]
+		expense, known := existing[key]
+		if !known && found.cadence.after(found.cadence.after(last.OccuredAt)).Before(now) {
+			// it ended a while ago
+			continue
+		}
+		if known && expense.LastReceiptID == last.ID && expense.Cadence == found.cadence.kind {
+			continue
+		}
+		if known && last.OccuredAt.After(expense.LastChargedAt) && last.TotalWithTax.GreaterThan(expense.Amount) {
+			alerts = append(alerts, fmt.Sprintf("%s raised the price of your %s charge from %s to %s %s",
+				merchantName(expense), found.cadence.kind, expense.Amount.StringFixed(2), last.TotalWithTax.StringFixed(2), key.currency))
+		}
+
+		expense.UserID, expense.MerchantID, expense.Currency = user.ID, key.merchantID, key.currency
+		expense.Cadence, expense.Amount, expense.Occurrences = found.cadence.kind, last.TotalWithTax, len(found.charges)
+		expense.LastReceiptID, expense.LastChargedAt, expense.NextExpectedAt = last.ID, last.OccuredAt, found.cadence.after(last.OccuredAt)
+		if err := dbc.Omit("Merchant").Save(&expense).Error; err != nil {
+			return alerts, fmt.Errorf("saving recurring expense: %w", errors.WithStack(err))
+		}
+		if !known {
+			spec, _ := scheduler.ParseSpec(CHECK_CRON)
+			if err := scheduler.Ensure(ctx, dbc, user, db.ScheduleKindRecurringCheck, spec); err != nil {
+				return alerts, err
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// The chain with the most charges among all cadences. Returns false if no cadence has enough charges
+func longestChain(charges []db.Receipt) (chain, bool) {
+	var best chain
+	for _, c := range cadences {
+		// the latest charges may be one-off purchases at the same merchant, so each charge is tried as the last one
+		for end := len(charges) - 1; end >= c.minOccurrences-1; end-- {
+			found := chainEndingAt(charges, end, c)
+			if len(found) >= c.minOccurrences {
+				found = extendChain(charges, end, c, found)
+				if len(found) > len(best.charges) {
+					best = chain{cadence: c, charges: found}
+				}
+				break
+			}
+		}
+	}
+	return best, len(best.charges) > 0
+}
+
+// Charges before the one at end, each charged a cadence before the next one for about the same amount
+func chainEndingAt(charges []db.Receipt, end int, c cadence) []db.Receipt {
+	found := []db.Receipt{charges[end]}
+	tolerance := time.Duration(c.tolerance) * 24 * time.Hour
+	for i := end - 1; i >= 0; i-- {
+		next := found[len(found)-1]
+		drift := next.OccuredAt.Sub(c.after(charges[i].OccuredAt))
+		if drift > tolerance {
+			break
+		}
+		if drift < -tolerance || !similar(charges[i].TotalWithTax, next.TotalWithTax) {
+			continue
+		}
+		found = append(found, charges[i])
+	}
+	return found
+}
+
+// Adds charges after the one at end which came when the chain expected them. A single change of the amount is allowed,
+// so a price change continues the chain instead of looking like a missed charge. Charges for the same amount win
+func extendChain(charges []db.Receipt, end int, c cadence, found []db.Receipt) []db.Receipt {
+	tolerance := time.Duration(c.tolerance) * 24 * time.Hour
+	changed := false
+	for last := end; ; {
+		expected := c.after(charges[last].OccuredAt)
+		picked := -1
+		for i := last + 1; i < len(charges); i++ {
+			drift := charges[i].OccuredAt.Sub(expected)
+			if drift > tolerance {
+				break
+			}
+			if drift < -tolerance {
+				continue
+			}
+			if similar(charges[i].TotalWithTax, charges[last].TotalWithTax) {
+				picked = i
+				break
+			}
+			if picked == -1 && !changed {
+				picked = i
+			}
+		}
+		if picked == -1 {
+			return found
+		}
+		if !similar(charges[picked].TotalWithTax, charges[last].TotalWithTax) {
+			changed = true
+		}
+		found = append([]db.Receipt{charges[picked]}, found...)
+		last = picked
+	}
+}
+
+func similar(amount decimal.Decimal, next decimal.Decimal) bool {
+	if !next.IsPositive() {
+		return false
+	}
+	return amount.Sub(next).Abs().LessThanOrEqual(next.Mul(decimal.NewFromFloat(AMOUNT_TOLERANCE)))
+}
+
+// Alerts about recurring expenses of the user which weren't charged when expected. Every missed charge is alerted once
+func CheckMissed(ctx context.Context, dbc *gorm.DB, user db.User, now time.Time) ([]string, error) {
+	expenses, err := ForUser(ctx, dbc, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []string
+	for _, expense := range expenses {
+		c := cadenceOf(expense.Cadence)
+		if now.Before(expense.NextExpectedAt.AddDate(0, 0, c.tolerance)) {
+			continue
+		}
+		if expense.MissedAlertedAt != nil && expense.MissedAlertedAt.Equal(expense.NextExpectedAt) {
+			continue
+		}
+		if err := dbc.WithContext(ctx).Model(&expense).Update("missed_alerted_at", expense.NextExpectedAt).Error; err != nil {
+			return alerts, fmt.Errorf("remembering alert: %w", errors.WithStack(err))
+		}
+		alerts = append(alerts, fmt.Sprintf("No %s charge from %s, expected around %s. Was it cancelled, or is the receipt not sent yet?",
+			expense.Cadence, merchantName(expense), expense.NextExpectedAt.In(user.Location()).Format(DATE_FORMAT)))
+	}
+	return alerts, nil
+}
+
+// Detects recurring expenses and alerts about price increases and missed charges, for scheduler.NewScheduler
+func Job(ctx context.Context, deps deps.Deps, user db.User, at time.Time) error {
+	alerts, err := Detect(ctx, deps.DBC, user, at)
+	if err != nil {
+		return err
+	}
+	missed, err := CheckMissed(ctx, deps.DBC, user, at)
+	if err != nil {
+		return err
+	}
+	for _, alert := range append(alerts, missed...) {
+		if err := deps.Notifier.Notify(ctx, user.ID, alert); err != nil {
+			return fmt.Errorf("sending recurring expense alert: %w", err)
+		}
+	}
+	return nil
+}
+
+// Describes the recurring expense, e.g. "Netflix: 12.99 EUR monthly, next around Nov 3"
+func Describe(expense db.RecurringExpense, loc *time.Location) string {
+	return fmt.Sprintf("%s: %s %s %s, next around %s", merchantName(expense), expense.Amount.StringFixed(2), expense.Currency,
+		expense.Cadence, expense.NextExpectedAt.In(loc).Format(DATE_FORMAT))
+}
+
+func merchantName(expense db.RecurringExpense) string {
+	if expense.Merchant == nil {
+		return fmt.Sprintf("merchant %d", expense.MerchantID)
+	}
+	return expense.Merchant.Name
+}
@@ -0,0 +1,109 @@
+package recurring
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/shopspring/decimal"
+)
+
+// Charges from "2025-01-31 9.99" lines, in order
+func charges(t *testing.T, lines ...string) []db.Receipt {
+	t.Helper()
+	var receipts []db.Receipt
+	for i, line := range lines {
+		date, amount, _ := strings.Cut(line, " ")
+		occuredAt, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipt := db.Receipt{OccuredAt: occuredAt, TotalWithTax: decimal.RequireFromString(amount)}
+		receipt.ID = uint(i + 1)
+		receipts = append(receipts, receipt)
+	}
+	return receipts
+}
+
+func TestLongestChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		charges  []string
+		cadence  db.RecurringCadence
+		expected []string
+	}{
+		{
+			"weekly",
+			[]string{"2025-03-03 5.00", "2025-03-10 5.00", "2025-03-18 5.00", "2025-03-24 5.00"},
+			db.RecurringCadenceWeekly,
+			[]string{"2025-03-24", "2025-03-18", "2025-03-10", "2025-03-03"},
+		},
+		{
+			"month-end drift",
+			[]string{"2025-01-31 9.99", "2025-02-28 9.99", "2025-03-31 9.99", "2025-04-30 9.99", "2025-05-31 9.99"},
+			db.RecurringCadenceMonthly,
+			[]string{"2025-05-31", "2025-04-30", "2025-03-31", "2025-02-28", "2025-01-31"},
+		},
+		{
+			"price change continues the chain",
+			[]string{"2025-01-15 9.99", "2025-02-15 9.99", "2025-03-15 9.99", "2025-04-15 9.99", "2025-05-15 12.99", "2025-06-15 12.99"},
+			db.RecurringCadenceMonthly,
+			[]string{"2025-06-15", "2025-05-15", "2025-04-15", "2025-03-15", "2025-02-15", "2025-01-15"},
+		},
+		{
+			"second price change ends the chain",
+			[]string{"2025-01-15 9.99", "2025-02-15 9.99", "2025-03-15 9.99", "2025-04-15 12.99", "2025-05-15 19.99"},
+			db.RecurringCadenceMonthly,
+			[]string{"2025-04-15", "2025-03-15", "2025-02-15", "2025-01-15"},
+		},
+		{
+			"charge for the same amount wins",
+			[]string{"2025-01-15 9.99", "2025-02-15 9.99", "2025-03-15 9.99", "2025-04-13 3.50", "2025-04-16 9.99"},
+			db.RecurringCadenceMonthly,
+			[]string{"2025-04-16", "2025-03-15", "2025-02-15", "2025-01-15"},
+		},
+		{
+			"one-off purchase after the chain",
+			[]string{"2025-01-15 9.99", "2025-02-15 9.99", "2025-03-15 9.99", "2025-03-20 42.00"},
+			db.RecurringCadenceMonthly,
+			[]string{"2025-03-15", "2025-02-15", "2025-01-15"},
+		},
+		{
+			"yearly",
+			[]string{"2024-02-10 89.00", "2025-02-03 89.00"},
+			db.RecurringCadenceYearly,
+			[]string{"2025-02-03", "2024-02-10"},
+		},
+		{
+			"too few charges",
+			[]string{"2025-01-15 9.99", "2025-02-15 9.99"},
+			"",
+			nil,
+		},
+		{
+			"too far apart",
+			[]string{"2025-01-15 9.99", "2025-02-25 9.99", "2025-04-05 9.99"},
+			"",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, ok := longestChain(charges(t, test.charges...))
+			if ok != (test.expected != nil) {
+				t.Fatalf("longestChain found = %v, expected %v", ok, test.expected != nil)
+			}
+			if !ok {
+				return
+			}
+			dates := make([]string, len(found.charges))
+			for i, charge := range found.charges {
+				dates[i] = charge.OccuredAt.Format(time.DateOnly)
+			}
+			if found.cadence.kind != test.cadence || strings.Join(dates, " ") != strings.Join(test.expected, " ") {
+				t.Errorf("longestChain = %s %v, expected %s %v", found.cadence.kind, dates, test.cadence, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package reply
+
+import (
+	"github.com/EPecherkin/catty-counting/charts"
+	"github.com/EPecherkin/catty-counting/export"
+)
+
+// A piece of a response to the user: text, or a chart or files which clients show the way they can
+type Chunk struct {
+	Text  string
+	Chart *charts.Chart
+	Files []export.File
+}
+
+func Text(text string) Chunk {
+	return Chunk{Text: text}
+}
+
+func Chart(chart charts.Chart) Chunk {
+	return Chunk{Chart: &chart}
+}
+
+func Files(files []export.File) Chunk {
+	return Chunk{Files: files}
+}
+
+func (chunk Chunk) IsEmpty() bool {
+	return chunk.Text == "" && chunk.Chart == nil && len(chunk.Files) == 0
+}
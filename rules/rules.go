@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EPecherkin/catty-counting/catalog"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Rules of the user with their merchants and categories, oldest first
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint) ([]db.CategoryRule, error) {
+	var rules []db.CategoryRule
+	if err := dbc.WithContext(ctx).Preload("Merchant").Preload("Category.Parent").
+		Where("user_id = ?", userID).Order("id asc").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("fetching rules: %w", errors.WithStack(err))
+	}
+	return rules, nil
+}
+
+// Finds the most specific rule for a product title bought at the merchant: rules of the merchant win over rules for any merchant,
+// longer patterns win over shorter ones. Returns nil if no rule matches
+func Match(ctx context.Context, dbc *gorm.DB, userID uint, merchantID *uint, title string) (*db.CategoryRule, error) {
+	titleWords := map[string]bool{}
+	for _, word := range catalog.Words(title) {
+		titleWords[word] = true
+	}
+	if len(titleWords) == 0 {
+		return nil, nil
+	}
+
+	query := dbc.WithContext(ctx).Preload("Category.Parent").Where("user_id = ?", userID)
+	if merchantID != nil {
+		query = query.Where("merchant_id IS NULL OR merchant_id = ?", *merchantID)
+	} else {
+		query = query.Where("merchant_id IS NULL")
+	}
+	var candidates []db.CategoryRule
+	if err := query.Order("updated_at desc").Find(&candidates).Error; err != nil {
+		return nil, fmt.Errorf("fetching rules: %w", errors.WithStack(err))
+	}
+
+	var found *db.CategoryRule
+	foundWords := 0
+	for i, rule := range candidates {
+		// the category was deleted
+		if rule.Category == nil {
+			continue
+		}
+		patternWords := strings.Fields(rule.Pattern)
+		matches := len(patternWords) > 0
+		for _, word := range patternWords {
+			if !titleWords[word] {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		if found == nil || (found.MerchantID == nil && rule.MerchantID != nil) ||
+			((found.MerchantID == nil) == (rule.MerchantID == nil) && len(patternWords) > foundWords) {
+			found, foundWords = &candidates[i], len(patternWords)
+		}
+	}
+	return found, nil
+}
+
+// Categorizes the product by the matching rule, if any. Returns the rule applied
+func Apply(ctx context.Context, dbc *gorm.DB, userID uint, merchantID *uint, product *db.Product) (*db.CategoryRule, error) {
+	rule, err := Match(ctx, dbc, userID, merchantID, product.Title)
+	if err != nil || rule == nil {
+		return nil, err
+	}
+	err = dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&db.ProductCategory{ProductID: product.ID, CategoryID: rule.CategoryID, Source: db.ProductCategorySourceRule}).Error; err != nil {
+			return fmt.Errorf("linking category: %w", errors.WithStack(err))
+		}
+		if err := tx.Model(rule).UpdateColumn("hits", gorm.Expr("hits + 1")).Error; err != nil {
+			return fmt.Errorf("counting hit: %w", errors.WithStack(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	product.Categories = append(product.Categories, *rule.Category)
+	return rule, nil
+}
+
+// Adds a rule for products with all words of the pattern, at the merchant or at any merchant when it's nil
+func Add(ctx context.Context, dbc *gorm.DB, userID uint, merchantID *uint, pattern string, categoryID uint) (*db.CategoryRule, error) {
+	normalized := catalog.Normalize(pattern)
+	if normalized == "" {
+		return nil, errors.New("pattern has no words")
+	}
+	rule := db.CategoryRule{UserID: userID, MerchantID: merchantID, Pattern: normalized, CategoryID: categoryID}
+	if err := dbc.WithContext(ctx).Create(&rule).Error; err != nil {
+		return nil, fmt.Errorf("creating rule: %w", errors.WithStack(err))
+	}
+	return &rule, nil
+}
+
+// Deletes the rule of the user. Returns false if the user has no such rule
+func Delete(ctx context.Context, dbc *gorm.DB, userID uint, id uint) (bool, error) {
+	result := dbc.WithContext(ctx).Where("user_id = ?", userID).Delete(&db.CategoryRule{}, id)
+	if result.Error != nil {
+		return false, fmt.Errorf("deleting rule: %w", errors.WithStack(result.Error))
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Remembers that products with the title at the merchant belong to the category. The rule with the same pattern is updated, if there is one
+func Learn(ctx context.Context, dbc *gorm.DB, userID uint, merchantID *uint, title string, categoryID uint) (*db.CategoryRule, error) {
+	pattern := catalog.Normalize(title)
+	if pattern == "" {
+		return nil, nil
+	}
+	dbc = dbc.WithContext(ctx)
+	query := dbc.Where("user_id = ? AND pattern = ?", userID, pattern)
+	if merchantID != nil {
+		query = query.Where("merchant_id = ?", *merchantID)
+	} else {
+		query = query.Where("merchant_id IS NULL")
+	}
+	var rule db.CategoryRule
+	err := query.First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		rule = db.CategoryRule{UserID: userID, MerchantID: merchantID, Pattern: pattern, CategoryID: categoryID, Learned: true}
+		if err := dbc.Create(&rule).Error; err != nil {
+			return nil, fmt.Errorf("creating rule: %w", errors.WithStack(err))
+		}
+		return &rule, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finding rule: %w", errors.WithStack(err))
+	}
+	if err := dbc.Model(&rule).Update("category_id", categoryID).Error; err != nil {
+		return nil, fmt.Errorf("updating rule: %w", errors.WithStack(err))
+	}
+	return &rule, nil
+}
+
+// Moves the product of the user to the category and learns a rule from it, so similar products get the category from now on.
+// Returns nil if the user has no such product
+func Recategorize(ctx context.Context, dbc *gorm.DB, userID uint, productID uint, category db.Category) (*db.Product, *db.CategoryRule, error) {
+	var product db.Product
+	err := dbc.WithContext(ctx).Preload("Receipt").
+		Joins("JOIN receipts ON receipts.id = products.receipt_id").Where("receipts.user_id = ?", userID).
+		First(&product, productID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding product: %w", errors.WithStack(err))
+	}
+
+	var rule *db.CategoryRule
+	err = dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("product_id = ?", product.ID).Delete(&db.ProductCategory{}).Error; err != nil {
+			return fmt.Errorf("unlinking categories: %w", errors.WithStack(err))
+		}
+		if err := tx.Create(&db.ProductCategory{ProductID: product.ID, CategoryID: category.ID, Source: db.ProductCategorySourceUser}).Error; err != nil {
+			return fmt.Errorf("linking category: %w", errors.WithStack(err))
+		}
+		var err error
+		rule, err = Learn(ctx, tx, userID, product.Receipt.MerchantID, product.Title, category.ID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	product.Categories = []db.Category{category}
+	return &product, rule, nil
+}
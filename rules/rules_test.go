@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dbc, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := dbc.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := db.Migrate(dbc); err != nil {
+		t.Fatal(err)
+	}
+	return dbc
+}
+
+func TestMatch(t *testing.T) {
+	ctx := context.Background()
+	dbc := testDB(t)
+	create := func(value any) {
+		t.Helper()
+		if err := dbc.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, otherUser := db.User{}, db.User{}
+	create(&user)
+	create(&otherUser)
+	shop, otherShop := db.Merchant{UserID: user.ID, Name: "REWE"}, db.Merchant{UserID: user.ID, Name: "Lidl"}
+	create(&shop)
+	create(&otherShop)
+	category := func(title string) db.Category {
+		c := db.Category{UserID: &user.ID, Title: title}
+		create(&c)
+		return c
+	}
+	dairy, drinks, organic, bakery := category("Dairy"), category("Drinks"), category("Organic"), category("Bakery")
+	if err := dbc.Delete(&bakery).Error; err != nil {
+		t.Fatal(err)
+	}
+	rules := map[string]*db.CategoryRule{
+		"milk":          {UserID: user.ID, Pattern: "milk", CategoryID: dairy.ID},
+		"oat milk":      {UserID: user.ID, Pattern: "oat milk", CategoryID: drinks.ID},
+		"milk at shop":  {UserID: user.ID, MerchantID: &shop.ID, Pattern: "milk", CategoryID: organic.ID},
+		"bread":         {UserID: user.ID, Pattern: "bread", CategoryID: bakery.ID},
+		"juice":         {UserID: otherUser.ID, Pattern: "juice", CategoryID: drinks.ID},
+		"juice at shop": {UserID: user.ID, MerchantID: &otherShop.ID, Pattern: "juice", CategoryID: drinks.ID},
+	}
+	for _, name := range []string{"milk", "oat milk", "milk at shop", "bread", "juice", "juice at shop"} {
+		create(rules[name])
+	}
+
+	tests := []struct {
+		title      string
+		merchantID *uint
+		expected   string
+	}{
+		{"Milk 3.5%", nil, "milk"},
+		{"MILK, fresh", &otherShop.ID, "milk"},
+		{"Oat Milk Barista", nil, "oat milk"},
+		{"Barista milk, oat", nil, "oat milk"},
+		{"Oat milk", &shop.ID, "milk at shop"},
+		{"Milkshake", nil, ""},
+		{"Bread", nil, ""},
+		{"Juice", nil, ""},
+		{"Juice", &otherShop.ID, "juice at shop"},
+		{"Juice", &shop.ID, ""},
+		{"3.5%", nil, ""},
+		{"", nil, ""},
+	}
+	for _, test := range tests {
+		rule, err := Match(ctx, dbc, user.ID, test.merchantID, test.title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected *db.CategoryRule
+		if test.expected != "" {
+			expected = rules[test.expected]
+		}
+		switch {
+		case expected == nil && rule != nil:
+			t.Errorf("Match(%q) = rule %q, expected none", test.title, rule.Pattern)
+		case expected != nil && (rule == nil || rule.ID != expected.ID):
+			t.Errorf("Match(%q) = %+v, expected the %q rule", test.title, rule, test.expected)
+		}
+	}
+}
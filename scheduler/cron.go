@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How far ahead Next looks for a matching time, so specs like "0 0 31 2 *" don't loop forever
+const MAX_LOOKAHEAD = 5 * 366 * 24 * time.Hour
+
+// A cron expression of 5 fields: minute, hour, day of month, month and day of week, where Sunday is 0.
+// Fields are "*", numbers, ranges "1-5", lists "1,15" and steps "*/15" or "1-10/2"
+type Spec struct {
+	expression string
+	minutes    map[int]bool
+	hours      map[int]bool
+	days       map[int]bool
+	months     map[int]bool
+	weekdays   map[int]bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+func ParseSpec(expression string) (Spec, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Spec{}, fmt.Errorf("%q should have 5 fields: minute, hour, day of month, month, day of week", expression)
+	}
+	spec := Spec{expression: strings.Join(fields, " "), anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	bounds := []struct {
+		into     *map[int]bool
+		min, max int
+	}{{&spec.minutes, 0, 59}, {&spec.hours, 0, 23}, {&spec.days, 1, 31}, {&spec.months, 1, 12}, {&spec.weekdays, 0, 7}}
+	for i, b := range bounds {
+		if *b.into, err = parseField(fields[i], b.min, b.max); err != nil {
+			return Spec{}, fmt.Errorf("field %d of %q: %w", i+1, expression, err)
+		}
+	}
+	// 7 is Sunday too
+	if spec.weekdays[7] {
+		spec.weekdays[0] = true
+	}
+	return spec, nil
+}
+
+func parseField(field string, min int, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step %q", stepPart)
+			}
+		}
+		from, to := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("bad value %q", first)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("bad value %q", last)
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%q is out of %d-%d", rangePart, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (spec Spec) String() string {
+	return spec.expression
+}
+
+// The first time after the given one matching the spec in the location. Returns zero time if there is none within MAX_LOOKAHEAD
+func (spec Spec) Next(after time.Time, loc *time.Location) time.Time {
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(MAX_LOOKAHEAD)
+	for t.Before(limit) {
+		if !spec.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !spec.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// As in cron, when both day of month and day of week are restricted, either of them matches
+func (spec Spec) matchesDay(t time.Time) bool {
+	day, weekday := spec.days[t.Day()], spec.weekdays[int(t.Weekday())]
+	switch {
+	case spec.anyDay && spec.anyWeekday:
+		return true
+	case spec.anyDay:
+		return weekday
+	case spec.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		expression string
+		ok         bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0 0 1,15 * *", true},
+		{"0 8 * * 7", true},
+		{"0 0 31 2 *", true},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+	}
+	for _, test := range tests {
+		_, err := ParseSpec(test.expression)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseSpec(%q) error = %v, expected ok = %v", test.expression, err, test.ok)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		expression string
+		loc        *time.Location
+		after      time.Time
+		expected   time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", time.UTC, time.Date(2025, 3, 14, 10, 7, 0, 0, time.UTC), time.Date(2025, 3, 14, 10, 15, 0, 0, time.UTC)},
+		{"every 15 minutes on the hour", "*/15 * * * *", time.UTC, time.Date(2025, 3, 14, 10, 45, 0, 0, time.UTC), time.Date(2025, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"strictly after", "0 9 * * *", time.UTC, time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC), time.Date(2025, 3, 15, 9, 0, 0, 0, time.UTC)},
+		{"seconds are dropped", "0 9 * * *", time.UTC, time.Date(2025, 3, 14, 8, 59, 30, 0, time.UTC), time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)},
+		{"weekdays", "0 9 * * 1-5", time.UTC, time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC), time.Date(2025, 3, 17, 9, 0, 0, 0, time.UTC)},
+		{"7 is Sunday", "0 9 * * 7", time.UTC, time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC), time.Date(2025, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"day of month or day of week", "0 9 20 * 0", time.UTC, time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 20, 9, 0, 0, 0, time.UTC)},
+		{"next month", "0 0 1 * *", time.UTC, time.Date(2025, 12, 14, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.UTC, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 31 2 *", berlin, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"in the location", "0 9 * * *", berlin, time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC), time.Date(2025, 3, 15, 8, 0, 0, 0, time.UTC)},
+		{"hourly over the DST start", "0 * * * *", berlin, time.Date(2025, 3, 30, 0, 30, 0, 0, time.UTC), time.Date(2025, 3, 30, 1, 0, 0, 0, time.UTC)},
+		{"skipped hour of the DST start", "30 2 * * *", berlin, time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 0, 30, 0, 0, time.UTC)},
+		{"daily after the DST start", "0 9 * * *", berlin, time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 30, 7, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := ParseSpec(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next := spec.Next(test.after, test.loc); !next.Equal(test.expected) {
+				t.Errorf("Next(%s) of %q = %s, expected %s", test.after, test.expression, next, test.expected)
+			}
+		})
+	}
+}
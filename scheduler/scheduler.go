@@ -0,0 +1,181 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/EPecherkin/catty-counting/deps"
+	"github.com/EPecherkin/catty-counting/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// How often due schedules are looked for
+	TICK = time.Minute
+)
+
+// Runs the work of a schedule of the user, which was due at the time
+type Job func(ctx context.Context, deps deps.Deps, user db.User, at time.Time) error
+
+// Runs jobs of schedules, when they are due. Schedules missed while the bot was down run once on start
+type Scheduler struct {
+	jobs map[db.ScheduleKind]Job
+
+	deps deps.Deps
+}
+
+func NewScheduler(deps deps.Deps, jobs map[db.ScheduleKind]Job) *Scheduler {
+	deps.Logger = deps.Logger.With(log.CALLER, "scheduler.Scheduler")
+	return &Scheduler{jobs: jobs, deps: deps}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	s.deps.Logger.Info("Running scheduler")
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	for {
+		s.runDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			s.deps.Logger.Debug("scheduler done")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) {
+	var due []db.Schedule
+	if err := s.deps.DBC.WithContext(ctx).Preload("User").Where("next_run_at <= ?", now.UTC()).Order("next_run_at asc").Find(&due).Error; err != nil {
+		s.deps.Logger.With(log.ERROR, errors.WithStack(err)).Error("failed to fetch due schedules")
+		return
+	}
+	for _, schedule := range due {
+		s.run(ctx, schedule, now)
+	}
+}
+
+// Runs the job and moves the schedule to its next time, even if the job failed, not to retry it every tick
+func (s *Scheduler) run(ctx context.Context, schedule db.Schedule, now time.Time) {
+	logger := s.deps.Logger.With(log.USER_ID, schedule.UserID).With("schedule_id", schedule.ID).With("kind", schedule.Kind)
+	defer func() {
+		if err := recover(); err != nil {
+			logger.With(log.ERROR, err).Error("panic in scheduled job")
+		}
+	}()
+	if schedule.User == nil {
+		logger.Warn("schedule of a deleted user")
+		return
+	}
+
+	var next time.Time
+	spec, err := ParseSpec(schedule.Cron)
+	if err == nil {
+		next = spec.Next(now, schedule.User.Location())
+	}
+	if next.IsZero() {
+		logger.With(log.ERROR, err).With("cron", schedule.Cron).Error("schedule never runs again, deleting it")
+		if err := s.deps.DBC.WithContext(ctx).Delete(&schedule).Error; err != nil {
+			logger.With(log.ERROR, errors.WithStack(err)).Error("failed to delete schedule")
+		}
+		return
+	}
+	if err := s.deps.DBC.WithContext(ctx).Model(&schedule).Updates(map[string]any{"next_run_at": next.UTC(), "last_run_at": now.UTC()}).Error; err != nil {
+		logger.With(log.ERROR, errors.WithStack(err)).Error("failed to move schedule, skipping it")
+		return
+	}
+
+	job, ok := s.jobs[schedule.Kind]
+	if !ok {
+		logger.Warn("no job for the schedule")
+		return
+	}
+	logger.Debug("running scheduled job")
+	if err := job(ctx, s.deps, *schedule.User, now); err != nil {
+		logger.With(log.ERROR, err).Error("scheduled job failed")
+		return
+	}
+	logger.With("next_run_at", next).Debug("scheduled job done")
+}
+
+// Schedules of the user, by kind
+func ForUser(ctx context.Context, dbc *gorm.DB, userID uint) (map[db.ScheduleKind]db.Schedule, error) {
+	var schedules []db.Schedule
+	if err := dbc.WithContext(ctx).Where("user_id = ?", userID).Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("fetching schedules: %w", errors.WithStack(err))
+	}
+	byKind := map[db.ScheduleKind]db.Schedule{}
+	for _, schedule := range schedules {
+		byKind[schedule.Kind] = schedule
+	}
+	return byKind, nil
+}
+
+// Schedules the job of the kind for the user, replacing the schedule of the kind the user had
+func Set(ctx context.Context, dbc *gorm.DB, user db.User, kind db.ScheduleKind, spec Spec) (*db.Schedule, error) {
+	next := spec.Next(time.Now(), user.Location())
+	if next.IsZero() {
+		return nil, fmt.Errorf("%q never comes", spec)
+	}
+	schedule := db.Schedule{UserID: user.ID, Kind: kind, Cron: spec.String(), NextRunAt: next.UTC()}
+	err := dbc.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND kind = ?", user.ID, kind).Delete(&db.Schedule{}).Error; err != nil {
+			return fmt.Errorf("deleting previous schedule: %w", errors.WithStack(err))
+		}
+		if err := tx.Create(&schedule).Error; err != nil {
+			return fmt.Errorf("creating schedule: %w", errors.WithStack(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// Schedules the job of the kind for the user, unless the user has a schedule of the kind already
+func Ensure(ctx context.Context, dbc *gorm.DB, user db.User, kind db.ScheduleKind, spec Spec) error {
+	var count int64
+	if err := dbc.WithContext(ctx).Model(&db.Schedule{}).Where("user_id = ? AND kind = ?", user.ID, kind).Count(&count).Error; err != nil {
+		return fmt.Errorf("counting schedules: %w", errors.WithStack(err))
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := Set(ctx, dbc, user, kind, spec)
+	return err
+}
+
+// Deletes the schedule of the kind. Returns false if the user had none
+func Delete(ctx context.Context, dbc *gorm.DB, userID uint, kind db.ScheduleKind) (bool, error) {
+	result := dbc.WithContext(ctx).Where("user_id = ? AND kind = ?", userID, kind).Delete(&db.Schedule{})
+	if result.Error != nil {
+		return false, fmt.Errorf("deleting schedule: %w", errors.WithStack(result.Error))
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Recalculates when schedules of the user are due, e.g. after the user changed timezone
+func Reschedule(ctx context.Context, dbc *gorm.DB, user db.User) error {
+	schedules, err := ForUser(ctx, dbc, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		spec, err := ParseSpec(schedule.Cron)
+		if err != nil {
+			continue
+		}
+		next := spec.Next(time.Now(), user.Location())
+		if next.IsZero() {
+			continue
+		}
+		if err := dbc.WithContext(ctx).Model(&schedule).Update("next_run_at", next.UTC()).Error; err != nil {
+			return fmt.Errorf("rescheduling %s: %w", schedule.Kind, errors.WithStack(err))
+		}
+	}
+	return nil
+}
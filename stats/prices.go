@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// A price the user paid for a catalog product
+type PricePoint struct {
+	Date      time.Time       `json:"date"`
+	Product   string          `json:"product"`
+	Title     string          `json:"title"`
+	Merchant  string          `json:"merchant,omitempty"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	UnitPrice decimal.Decimal `json:"unit_price"`
+	Currency  string          `json:"currency"`
+	// Price of a kg, l or piece, to compare packages of different sizes
+	PricePerUnit decimal.Decimal `json:"price_per_unit,omitempty"`
+	Unit         string          `json:"unit,omitempty"`
+}
+
+// Prices of the catalog products the user paid over [from, to), oldest first. Limited to the merchants, if any
+func PriceHistory(ctx context.Context, dbc *gorm.DB, userID uint, catalogProductIDs []uint, merchantIDs []uint, from time.Time, to time.Time) ([]PricePoint, error) {
+	query := dbc.WithContext(ctx).
+		Preload("Receipt.Merchant").Preload("CatalogProduct").
+		Joins("JOIN receipts ON receipts.id = products.receipt_id AND receipts.deleted_at IS NULL").
+		Where("products.catalog_product_id IN ? AND products.line_type = ?", catalogProductIDs, db.ProductLineTypeItem).
+		Where("receipts.user_id = ? AND receipts.occured_at >= ? AND receipts.occured_at < ? AND receipts.duplicate_of_id IS NULL", userID, from.UTC(), to.UTC())
+	if len(merchantIDs) > 0 {
+		query = query.Where("receipts.merchant_id IN ?", merchantIDs)
+	}
+	var products []db.Product
+	if err := query.Find(&products).Error; err != nil {
+		return nil, fmt.Errorf("fetching products: %w", errors.WithStack(err))
+	}
+
+	points := make([]PricePoint, 0, len(products))
+	for _, product := range products {
+		point := PricePoint{
+			Title:     product.Title,
+			Quantity:  product.Quantity,
+			UnitPrice: product.UnitPrice,
+		}
+		if product.Receipt != nil {
+			point.Date = product.Receipt.OccuredAt
+			point.Currency = product.Receipt.Currency
+			if product.Receipt.Merchant != nil {
+				point.Merchant = product.Receipt.Merchant.Name
+			}
+		}
+		if product.CatalogProduct != nil {
+			point.Product = product.CatalogProduct.Name
+			if product.CatalogProduct.Size.IsPositive() {
+				point.PricePerUnit, point.Unit = perUnit(product.UnitPrice, product.CatalogProduct.Size, product.CatalogProduct.Unit)
+			}
+		}
+		points = append(points, point)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Date.Before(points[j].Date) })
+	return points, nil
+}
+
+// Price of a kg or l for packages in g or ml, of a piece for packs
+func perUnit(price decimal.Decimal, size decimal.Decimal, unit string) (decimal.Decimal, string) {
+	switch unit {
+	case "g":
+		return price.Div(size).Mul(decimal.NewFromInt(1000)).Round(2), "kg"
+	case "ml":
+		return price.Div(size).Mul(decimal.NewFromInt(1000)).Round(2), "l"
+	default:
+		return price.Div(size).Round(2), unit
+	}
+}
@@ -0,0 +1,277 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/categories"
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	UNCATEGORIZED    = "Uncategorized"
+	UNKNOWN_MERCHANT = "Unknown merchant"
+)
+
+// Categories of deposits, fees and tips which LLM didn't categorize
+var lineTypeCategories = map[db.ProductLineType]string{
+	db.ProductLineTypeDeposit: "Deposits",
+	db.ProductLineTypeFee:     "Fees",
+	db.ProductLineTypeTip:     "Tips",
+}
+
+// The category spending summaries total deposits, fees and tips without a category in, UNCATEGORIZED for other lines
+func LineTypeCategory(lineType db.ProductLineType) string {
+	if category, ok := lineTypeCategories[lineType]; ok {
+		return category
+	}
+	return UNCATEGORIZED
+}
+
+type CategoryTotal struct {
+	Category string          `json:"category"`
+	Total    decimal.Decimal `json:"total"`
+}
+
+type MerchantTotal struct {
+	MerchantID uint            `json:"merchant_id,omitempty"`
+	Merchant   string          `json:"merchant"`
+	Total      decimal.Decimal `json:"total"`
+	Receipts   int             `json:"receipts"`
+}
+
+// Spending of a user over [From, To), in the base currency of the user
+type Spending struct {
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Currency string          `json:"currency"`
+	Total    decimal.Decimal `json:"total"`
+	Receipts int             `json:"receipts"`
+	// Receipts left out, as they aren't converted to the base currency yet
+	Unconverted int             `json:"unconverted,omitempty"`
+	ByCategory  []CategoryTotal `json:"by_category"`
+	ByMerchant  []MerchantTotal `json:"by_merchant"`
+}
+
+type productCategoryRow struct {
+	ProductID        uint
+	ReceiptID        uint
+	LineType         db.ProductLineType
+	BaseTotalWithTax decimal.Decimal
+	Category         string
+	ParentCategory   string
+}
+
+// Receipts of the user in the period, converted to the base currency
+func convertedReceipts(dbc *gorm.DB, user db.User, from time.Time, to time.Time) *gorm.DB {
+	return dbc.Model(&db.Receipt{}).
+		Where("receipts.user_id = ? AND receipts.occured_at >= ? AND receipts.occured_at < ?", user.ID, from.UTC(), to.UTC()).
+		Where("receipts.base_currency = ? AND receipts.duplicate_of_id IS NULL", user.EffectiveBaseCurrency())
+}
+
+func SpendingBetween(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) (Spending, error) {
+	dbc = dbc.WithContext(ctx)
+	spending := Spending{From: from, To: to, Currency: user.EffectiveBaseCurrency()}
+
+	var receipts []db.Receipt
+	if err := convertedReceipts(dbc, user, from, to).Select("id", "base_total_with_tax", "merchant_id").Find(&receipts).Error; err != nil {
+		return spending, fmt.Errorf("fetching receipts: %w", errors.WithStack(err))
+	}
+	spending.Receipts = len(receipts)
+	for _, r := range receipts {
+		spending.Total = spending.Total.Add(r.BaseTotalWithTax)
+	}
+
+	var unconverted int64
+	if err := dbc.Model(&db.Receipt{}).
+		Where("user_id = ? AND occured_at >= ? AND occured_at < ? AND duplicate_of_id IS NULL", user.ID, from.UTC(), to.UTC()).
+		Where("base_currency IS NULL OR base_currency <> ?", user.EffectiveBaseCurrency()).
+		Count(&unconverted).Error; err != nil {
+		return spending, fmt.Errorf("counting unconverted receipts: %w", errors.WithStack(err))
+	}
+	spending.Unconverted = int(unconverted)
+
+	byCategory, err := byCategory(dbc, user, from, to, false)
+	if err != nil {
+		return spending, err
+	}
+	spending.ByCategory = byCategory
+
+	byMerchant, err := byMerchant(dbc, receipts)
+	if err != nil {
+		return spending, err
+	}
+	spending.ByMerchant = byMerchant
+	return spending, nil
+}
+
+// The biggest receipts of the user over [from, to) in the base currency, with their merchants
+func LargestReceipts(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time, limit int) ([]db.Receipt, error) {
+	var receipts []db.Receipt
+	if err := convertedReceipts(dbc.WithContext(ctx), user, from, to).Preload("Merchant").
+		Order("base_total_with_tax desc").Limit(limit).Find(&receipts).Error; err != nil {
+		return nil, fmt.Errorf("fetching largest receipts: %w", errors.WithStack(err))
+	}
+	return receipts, nil
+}
+
+// Totals per merchant of the receipts, biggest first
+func byMerchant(dbc *gorm.DB, receipts []db.Receipt) ([]MerchantTotal, error) {
+	totals := map[uint]*MerchantTotal{}
+	for _, r := range receipts {
+		var merchantID uint
+		if r.MerchantID != nil {
+			merchantID = *r.MerchantID
+		}
+		total, ok := totals[merchantID]
+		if !ok {
+			total = &MerchantTotal{MerchantID: merchantID, Merchant: UNKNOWN_MERCHANT}
+			totals[merchantID] = total
+		}
+		total.Total = total.Total.Add(r.BaseTotalWithTax)
+		total.Receipts++
+	}
+
+	var merchants []db.Merchant
+	if err := dbc.Unscoped().Where("id IN ?", lo.Keys(totals)).Find(&merchants).Error; err != nil {
+		return nil, fmt.Errorf("fetching merchants: %w", errors.WithStack(err))
+	}
+	for _, merchant := range merchants {
+		totals[merchant.ID].Merchant = merchant.Name
+	}
+
+	result := make([]MerchantTotal, 0, len(totals))
+	for _, total := range totals {
+		total.Total = total.Total.Round(2)
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Total.Equal(result[j].Total) {
+			return result[i].Total.GreaterThan(result[j].Total)
+		}
+		return result[i].Merchant < result[j].Merchant
+	})
+	return result, nil
+}
+
+// Totals per category path of the user over [from, to), in the base currency of the user
+func CategoryTotalsBetween(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) ([]CategoryTotal, error) {
+	return byCategory(dbc.WithContext(ctx), user, from, to, false)
+}
+
+// Totals like CategoryTotalsBetween without receipts LLM was unsure of, for alerts, see db.Receipt
+func SureCategoryTotalsBetween(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) ([]CategoryTotal, error) {
+	return byCategory(dbc.WithContext(ctx), user, from, to, true)
+}
+
+// Totals per category path, like "Food > Groceries"
+func byCategory(dbc *gorm.DB, user db.User, from time.Time, to time.Time, sureOnly bool) ([]CategoryTotal, error) {
+	perReceipt, err := receiptCategoryTotals(dbc, user, from, to, sureOnly)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[string]decimal.Decimal{}
+	for _, receiptTotals := range perReceipt {
+		for category, total := range receiptTotals {
+			totals[category] = totals[category].Add(total)
+		}
+	}
+
+	result := make([]CategoryTotal, 0, len(totals))
+	for category, total := range totals {
+		result = append(result, CategoryTotal{Category: category, Total: total.Round(2)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Total.Equal(result[j].Total) {
+			return result[i].Total.GreaterThan(result[j].Total)
+		}
+		return result[i].Category < result[j].Category
+	})
+	return result, nil
+}
+
+// Totals per category path of every receipt of the user over [from, to), by receipt ID, in the base currency of the user. Totals aren't rounded
+func ReceiptCategoryTotalsBetween(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) (map[uint]map[string]decimal.Decimal, error) {
+	return receiptCategoryTotals(dbc.WithContext(ctx), user, from, to, false)
+}
+
+// Totals like ReceiptCategoryTotalsBetween without receipts LLM was unsure of, for alerts, see db.Receipt
+func SureReceiptCategoryTotalsBetween(ctx context.Context, dbc *gorm.DB, user db.User, from time.Time, to time.Time) (map[uint]map[string]decimal.Decimal, error) {
+	return receiptCategoryTotals(dbc.WithContext(ctx), user, from, to, true)
+}
+
+// A product with several categories is split between them evenly.
+// Discount lines are spread over items of their receipt, deposits, fees and tips without a category are totaled by their type
+func receiptCategoryTotals(dbc *gorm.DB, user db.User, from time.Time, to time.Time, sureOnly bool) (map[uint]map[string]decimal.Decimal, error) {
+	var rows []productCategoryRow
+	query := dbc.Table("products").
+		Select("products.id AS product_id, products.receipt_id, COALESCE(products.line_type, '') AS line_type, COALESCE(products.base_total_with_tax, 0) AS base_total_with_tax, COALESCE(categories.title, '') AS category, COALESCE(parents.title, '') AS parent_category").
+		Joins("JOIN receipts ON receipts.id = products.receipt_id AND receipts.deleted_at IS NULL").
+		Joins("LEFT JOIN product_categories ON product_categories.product_id = products.id").
+		Joins("LEFT JOIN categories ON categories.id = product_categories.category_id AND categories.deleted_at IS NULL").
+		Joins("LEFT JOIN categories parents ON parents.id = categories.parent_id").
+		Where("products.deleted_at IS NULL").
+		Where("receipts.user_id = ? AND receipts.occured_at >= ? AND receipts.occured_at < ?", user.ID, from.UTC(), to.UTC()).
+		Where("receipts.base_currency = ? AND receipts.duplicate_of_id IS NULL", user.EffectiveBaseCurrency())
+	if sureOnly {
+		query = query.Where("receipts.unsure = ?", false)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("fetching products with categories: %w", errors.WithStack(err))
+	}
+
+	categoriesPerProduct := map[uint]int64{}
+	lines := map[uint]productCategoryRow{}
+	for _, row := range rows {
+		categoriesPerProduct[row.ProductID]++
+		lines[row.ProductID] = row
+	}
+	itemsTotals := map[uint]decimal.Decimal{}
+	discountTotals := map[uint]decimal.Decimal{}
+	for _, line := range lines {
+		switch line.LineType {
+		case db.ProductLineTypeItem, "":
+			itemsTotals[line.ReceiptID] = itemsTotals[line.ReceiptID].Add(line.BaseTotalWithTax)
+		case db.ProductLineTypeDiscount:
+			discountTotals[line.ReceiptID] = discountTotals[line.ReceiptID].Add(line.BaseTotalWithTax)
+		}
+	}
+
+	totals := map[uint]map[string]decimal.Decimal{}
+	for _, row := range rows {
+		total := row.BaseTotalWithTax
+		category := row.Category
+		if row.ParentCategory != "" {
+			category = row.ParentCategory + categories.PATH_SEPARATOR + category
+		}
+		switch row.LineType {
+		case db.ProductLineTypeItem, "":
+			if itemsTotal := itemsTotals[row.ReceiptID]; itemsTotal.IsPositive() {
+				total = total.Add(discountTotals[row.ReceiptID].Mul(total).Div(itemsTotal))
+			}
+		case db.ProductLineTypeDiscount:
+			if itemsTotals[row.ReceiptID].IsPositive() {
+				continue
+			}
+		default:
+			if category == "" {
+				category = lineTypeCategories[row.LineType]
+			}
+		}
+		if category == "" {
+			category = UNCATEGORIZED
+		}
+		if totals[row.ReceiptID] == nil {
+			totals[row.ReceiptID] = map[string]decimal.Decimal{}
+		}
+		share := total.Div(decimal.NewFromInt(categoriesPerProduct[row.ProductID]))
+		totals[row.ReceiptID][category] = totals[row.ReceiptID][category].Add(share)
+	}
+	return totals, nil
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/EPecherkin/catty-counting/db"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Tax paid at one rate in one currency. Rate is in percent
+type TaxRateTotal struct {
+	Currency string          `json:"currency"`
+	Rate     decimal.Decimal `json:"rate"`
+	Base     decimal.Decimal `json:"base"`
+	Amount   decimal.Decimal `json:"amount"`
+	Receipts int             `json:"receipts"`
+}
+
+// Tax a user paid over [From, To), by rate, in currencies of the receipts
+type Taxes struct {
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	ByRate []TaxRateTotal `json:"by_rate"`
+	// Receipts with tax, but without a breakdown by rate
+	WithoutBreakdown int `json:"without_breakdown,omitempty"`
+}
+
+type taxLineRow struct {
+	ReceiptID uint
+	Currency  string
+	Rate      decimal.Decimal
+	Base      decimal.Decimal
+	Amount    decimal.Decimal
+}
+
+func TaxesBetween(ctx context.Context, dbc *gorm.DB, userID uint, from time.Time, to time.Time) (Taxes, error) {
+	dbc = dbc.WithContext(ctx)
+	taxes := Taxes{From: from, To: to}
+
+	var rows []taxLineRow
+	err := dbc.Table("receipt_tax_lines").
+		Select("receipt_tax_lines.receipt_id, COALESCE(receipts.currency, '') AS currency, receipt_tax_lines.rate, receipt_tax_lines.base, receipt_tax_lines.amount").
+		Joins("JOIN receipts ON receipts.id = receipt_tax_lines.receipt_id AND receipts.deleted_at IS NULL").
+		Where("receipt_tax_lines.deleted_at IS NULL").
+		Where("receipts.user_id = ? AND receipts.occured_at >= ? AND receipts.occured_at < ? AND receipts.duplicate_of_id IS NULL", userID, from.UTC(), to.UTC()).
+		Scan(&rows).Error
+	if err != nil {
+		return taxes, fmt.Errorf("fetching tax lines: %w", errors.WithStack(err))
+	}
+
+	type key struct {
+		currency string
+		rate     string
+	}
+	totals := map[key]*TaxRateTotal{}
+	receiptsPerKey := map[key]map[uint]bool{}
+	for _, row := range rows {
+		k := key{currency: row.Currency, rate: row.Rate.String()}
+		total, ok := totals[k]
+		if !ok {
+			total = &TaxRateTotal{Currency: row.Currency, Rate: row.Rate}
+			totals[k] = total
+			receiptsPerKey[k] = map[uint]bool{}
+		}
+		total.Base = total.Base.Add(row.Base)
+		total.Amount = total.Amount.Add(row.Amount)
+		receiptsPerKey[k][row.ReceiptID] = true
+	}
+	taxes.ByRate = make([]TaxRateTotal, 0, len(totals))
+	for k, total := range totals {
+		total.Receipts = len(receiptsPerKey[k])
+		taxes.ByRate = append(taxes.ByRate, *total)
+	}
+	sort.Slice(taxes.ByRate, func(i, j int) bool {
+		if taxes.ByRate[i].Currency != taxes.ByRate[j].Currency {
+			return taxes.ByRate[i].Currency < taxes.ByRate[j].Currency
+		}
+		return taxes.ByRate[i].Rate.LessThan(taxes.ByRate[j].Rate)
+	})
+
+	var withoutBreakdown int64
+	err = dbc.Model(&db.Receipt{}).
+		Where("user_id = ? AND occured_at >= ? AND occured_at < ? AND duplicate_of_id IS NULL", userID, from.UTC(), to.UTC()).
+		Where("tax <> 0 AND NOT EXISTS (SELECT 1 FROM receipt_tax_lines WHERE receipt_tax_lines.receipt_id = receipts.id AND receipt_tax_lines.deleted_at IS NULL)").
+		Count(&withoutBreakdown).Error
+	if err != nil {
+		return taxes, fmt.Errorf("counting receipts without tax lines: %w", errors.WithStack(err))
+	}
+	taxes.WithoutBreakdown = int(withoutBreakdown)
+	return taxes, nil
+}